@@ -0,0 +1,215 @@
+package check
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/isi-lincoln/goblkid"
+	"github.com/isi-lincoln/goblkid/filesystems"
+	"github.com/isi-lincoln/goblkid/partitions"
+)
+
+// Severity is how serious a finding is
+type Severity int
+
+// Severity mappings
+const (
+	Info Severity = iota
+	Warning
+	Error
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Info:
+		return "info"
+	case Warning:
+		return "warning"
+	case Error:
+		return "error"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
+
+// ShrunkPercent is the share of its container below which a filesystem is
+// reported as waiting for a resize
+const ShrunkPercent = 90
+
+// Finding is a single problem found on the device
+type Finding struct {
+	Severity Severity
+	// Partition is the partition number, 0 for the whole device
+	Partition int
+	Message   string
+}
+
+// Report is the result of checking a device
+type Report struct {
+	Device   string
+	Size     int64
+	Topology goblkid.Topology
+	Table    *partitions.Table
+	Findings []Finding
+}
+
+// HasErrors reports whether any finding is an error
+func (r *Report) HasErrors() bool {
+	for _, f := range r.Findings {
+		if f.Severity == Error {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *Report) add(sev Severity, part int, format string, args ...interface{}) {
+	r.Findings = append(r.Findings, Finding{
+		Severity:  sev,
+		Partition: part,
+		Message:   fmt.Sprintf(format, args...),
+	})
+}
+
+// Device checks partition alignment and filesystem sizes of the device
+func Device(dev string) (*Report, error) {
+	fi, err := os.Open(dev)
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close()
+
+	info := &goblkid.ProbeInfo{}
+	if err := info.SetDevice(fi); err != nil {
+		return nil, err
+	}
+
+	return Probe(info, dev)
+}
+
+// Probe checks the device behind an already populated info
+func Probe(info *goblkid.ProbeInfo, name string) (*Report, error) {
+	r := &Report{Device: name, Size: info.Size, Topology: info.Topology}
+
+	res, err := partitions.Resolve(info, filesystems.Chains)
+	if err != nil {
+		return nil, err
+	}
+
+	switch res.Interpretation {
+	case partitions.InterpretNothing:
+		/* still looks for superblocks that matched but are corrupt */
+		r.add(Info, 0, "%s", res.Reason)
+		r.checkFilesystem(info, 0, info.Offset, info.Size)
+
+		return r, nil
+	case partitions.InterpretFilesystem:
+		sev := Info
+		if res.Table != nil {
+			sev = Warning
+		}
+
+		r.add(sev, 0, "%s", res.Reason)
+		r.checkFilesystem(info, 0, info.Offset, info.Size)
+
+		return r, nil
+	}
+
+	table := res.Table
+	r.Table = table
+
+	if res.Filesystem != nil {
+		r.add(Warning, 0, "%s", res.Reason)
+	}
+
+	for _, d := range table.Diagnostics {
+		sev := Warning
+		if table.Layout == partitions.LayoutMBR || table.Layout == partitions.LayoutProtective {
+			sev = Info
+		}
+
+		r.add(sev, 0, "%s", d)
+	}
+
+	for _, p := range table.Partitions {
+		start := int64(p.Start * table.SectorSize)
+		size := int64(p.Sectors * table.SectorSize)
+
+		if start+size > info.Size {
+			r.add(Error, p.Number, "partition ends at byte %d, past the end of the device (%d)",
+				start+size, info.Size)
+		}
+
+		r.checkAlignment(p.Number, start)
+		r.checkFilesystem(info, p.Number, info.Offset+start, size)
+	}
+
+	return r, nil
+}
+
+/*
+ * alignment_offset is how far the start of the device is from the natural
+ * alignment, so an aligned partition starts that many bytes past a boundary
+ */
+func (r *Report) checkAlignment(part int, start int64) {
+	t := r.Topology
+	off := start - t.AlignmentOffset
+
+	if t.AlignmentOffset < 0 {
+		r.add(Warning, part, "device reports it cannot be aligned")
+		return
+	}
+
+	if t.PhysicalSectorSize > 0 && off%int64(t.PhysicalSectorSize) != 0 {
+		r.add(Warning, part, "start %d is not aligned to the %d byte physical sector",
+			start, t.PhysicalSectorSize)
+	}
+
+	if t.OptimalIOSize > 0 && off%int64(t.OptimalIOSize) != 0 {
+		r.add(Warning, part, "start %d is not aligned to the %d byte optimal I/O size",
+			start, t.OptimalIOSize)
+	}
+}
+
+func (r *Report) checkFilesystem(dev *goblkid.ProbeInfo, part int, offset, size int64) {
+	info := &goblkid.ProbeInfo{
+		DeviceReader: dev.DeviceReader,
+		Offset:       offset,
+		Size:         size,
+		Devno:        dev.Devno,
+		DiskDevno:    dev.DiskDevno,
+		BlockSize:    dev.BlockSize,
+		Topology:     dev.Topology,
+	}
+
+	_, err := filesystems.Probe(info)
+	if errors.Is(err, goblkid.ErrCorrupt) {
+		r.add(Error, part, "%v", err)
+		return
+	}
+
+	if err != nil {
+		r.add(Warning, part, "probing filesystem: %v", err)
+		return
+	}
+
+	if info.ProbeName == "" || info.FSSize == 0 {
+		return
+	}
+
+	fsSize := int64(info.FSSize)
+
+	switch {
+	case fsSize > size:
+		r.add(Error, part, "%s filesystem is %d bytes but its container is %d bytes, "+
+			"the filesystem is truncated", info.ProbeName, fsSize, size)
+	case fsSize < size/100*ShrunkPercent:
+		r.add(Warning, part, "%s filesystem uses %d of %d bytes, a resize is pending",
+			info.ProbeName, fsSize, size)
+	default:
+		r.add(Info, part, "%s filesystem of %d bytes fits its %d byte container",
+			info.ProbeName, fsSize, size)
+	}
+}
@@ -0,0 +1,239 @@
+package check
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/isi-lincoln/goblkid"
+	"github.com/isi-lincoln/goblkid/partitions"
+	"github.com/stretchr/testify/assert"
+)
+
+func mbrImage(start, sectors uint32) []byte {
+	img := make([]byte, 1<<20)
+	e := img[0x1be:]
+	e[4] = 0x83
+	binary.LittleEndian.PutUint32(e[8:12], start)
+	binary.LittleEndian.PutUint32(e[12:16], sectors)
+	img[0x1fe] = 0x55
+	img[0x1ff] = 0xAA
+
+	return img
+}
+
+func probe(t *testing.T, img []byte, physical uint64) *Report {
+	t.Helper()
+
+	info := &goblkid.ProbeInfo{
+		DeviceReader: bytes.NewReader(img),
+		Size:         int64(len(img)),
+		BlockSize:    512,
+		Topology: goblkid.Topology{
+			LogicalSectorSize:  512,
+			PhysicalSectorSize: physical,
+		},
+	}
+
+	r, err := Probe(info, "test")
+	assert.Nil(t, err)
+
+	return r
+}
+
+func TestMisaligned(t *testing.T) {
+	r := probe(t, mbrImage(63, 100), 4096)
+	assert.Equal(t, 1, len(r.Findings))
+	assert.Equal(t, Warning, r.Findings[0].Severity)
+	assert.False(t, r.HasErrors())
+
+	r = probe(t, mbrImage(64, 100), 4096)
+	assert.Equal(t, 0, len(r.Findings))
+}
+
+func TestPastEnd(t *testing.T) {
+	r := probe(t, mbrImage(2048, 100), 512)
+	assert.True(t, r.HasErrors())
+}
+
+/* putFAT12 writes a FAT12 boot sector of sectors 512 byte sectors at img */
+func putFAT12(img []byte, sectors uint16) {
+	copy(img[0:], []byte{0xeb, 0x3c, 0x90})
+	copy(img[3:], "mkfs.fat")
+	binary.LittleEndian.PutUint16(img[0x0b:], 512)
+	img[0x0d] = 4
+	binary.LittleEndian.PutUint16(img[0x0e:], 1)
+	img[0x10] = 2
+	binary.LittleEndian.PutUint16(img[0x11:], 512)
+	binary.LittleEndian.PutUint16(img[0x13:], sectors)
+	img[0x15] = 0xf8
+	binary.LittleEndian.PutUint16(img[0x16:], 2)
+	copy(img[0x36:], "FAT12   ")
+	img[0x1fe] = 0x55
+	img[0x1ff] = 0xAA
+}
+
+/* putExFAT writes an exFAT boot sector whose boot region checksum is missing */
+func putExFAT(img []byte) {
+	copy(img[0:], []byte{0xeb, 0x76, 0x90})
+	copy(img[3:], "EXFAT   ")
+	binary.LittleEndian.PutUint64(img[0x48:], 2048)
+	binary.LittleEndian.PutUint32(img[0x50:], 128)
+	binary.LittleEndian.PutUint32(img[0x54:], 8)
+	binary.LittleEndian.PutUint32(img[0x58:], 256)
+	binary.LittleEndian.PutUint32(img[0x5c:], 224)
+	binary.LittleEndian.PutUint32(img[0x60:], 4)
+	img[0x6c], img[0x6d], img[0x6e] = 9, 3, 1
+	img[0x1fe] = 0x55
+	img[0x1ff] = 0xAA
+}
+
+/* gptImage is a 1M image with one GPT partition from sector 64 to 1983 */
+func gptImage(t *testing.T) []byte {
+	t.Helper()
+
+	img := make(memDevice, 1<<20)
+
+	_, err := partitions.Write(img, int64(len(img)), &partitions.Spec{
+		Type:       partitions.GPTName,
+		Alignment:  32 << 10,
+		Partitions: []partitions.PartitionSpec{{Size: 960 << 10}},
+	})
+	assert.Nil(t, err)
+
+	return img
+}
+
+type memDevice []byte
+
+func (m memDevice) ReadAt(p []byte, off int64) (int, error) {
+	return copy(p, m[off:]), nil
+}
+
+func (m memDevice) WriteAt(p []byte, off int64) (int, error) {
+	return copy(m[off:], p), nil
+}
+
+/* brokenReader fails every read starting in [from, to) */
+type brokenReader struct {
+	*bytes.Reader
+	from, to int64
+}
+
+func (b brokenReader) Read(p []byte) (int, error) {
+	if pos := b.Size() - int64(b.Len()); pos >= b.from && pos < b.to {
+		return 0, errors.New("input/output error")
+	}
+
+	return b.Reader.Read(p)
+}
+
+func TestFindings(t *testing.T) {
+	tests := []struct {
+		name     string
+		img      func(t *testing.T) []byte
+		topology goblkid.Topology
+		broken   bool /* reads of the partition fail */
+		severity Severity
+		want     string
+	}{
+		{"fits", func(t *testing.T) []byte {
+			img := mbrImage(64, 1920)
+			putFAT12(img[64*512:], 1920)
+
+			return img
+		}, goblkid.Topology{}, false, Info, "vfat filesystem of 983040 bytes fits its 983040 byte container"},
+		{"truncated", func(t *testing.T) []byte {
+			img := mbrImage(64, 1024)
+			putFAT12(img[64*512:], 1920)
+
+			return img
+		}, goblkid.Topology{}, false, Error, "the filesystem is truncated"},
+		{"resize pending", func(t *testing.T) []byte {
+			img := mbrImage(64, 1920)
+			putFAT12(img[64*512:], 1024)
+
+			return img
+		}, goblkid.Topology{}, false, Warning, "a resize is pending"},
+		{"corrupt", func(t *testing.T) []byte {
+			img := mbrImage(64, 1920)
+			putExFAT(img[64*512:])
+
+			return img
+		}, goblkid.Topology{}, false, Error, "exfat boot region checksum"},
+		{"read error", func(t *testing.T) []byte {
+			return mbrImage(64, 1920)
+		}, goblkid.Topology{}, true, Warning, "probing filesystem: input/output error"},
+		{"stale gpt", func(t *testing.T) []byte {
+			img := gptImage(t)
+			img[0x1be+4] = 0x83
+
+			return img
+		}, goblkid.Topology{}, false, Info, "MBR has no 0xee entry but a valid GPT is present"},
+		{"protective", gptImage, goblkid.Topology{}, false, Info, "protective MBR found; using GPT"},
+		{"protective size", func(t *testing.T) []byte {
+			img := gptImage(t)
+			binary.LittleEndian.PutUint32(img[0x1be+12:], 1000)
+
+			return img
+		}, goblkid.Topology{}, false, Info, "protective entry covers 1000 sectors, expected 2047"},
+		{"hybrid", func(t *testing.T) []byte {
+			img := gptImage(t)
+			e := img[0x1be+16:]
+			e[4] = 0x0c
+			binary.LittleEndian.PutUint32(e[8:12], 64)
+			binary.LittleEndian.PutUint32(e[12:16], 1920)
+
+			return img
+		}, goblkid.Topology{}, false, Warning, "hybrid MBR entry 2 (type 0x0c) mirrors GPT partition 1"},
+		{"lost protective", func(t *testing.T) []byte {
+			img := gptImage(t)
+			copy(img[:512], make([]byte, 512))
+
+			return img
+		}, goblkid.Topology{}, false, Warning, "sector 0 has no protective MBR"},
+		{"orphan protective", func(t *testing.T) []byte {
+			img := gptImage(t)
+			copy(img[512:1024], make([]byte, 512))
+			copy(img[len(img)-512:], make([]byte, 512))
+
+			return img
+		}, goblkid.Topology{}, false, Warning, "protective MBR (type 0xee) found but no valid GPT"},
+		{"unalignable", func(t *testing.T) []byte {
+			return mbrImage(64, 1920)
+		}, goblkid.Topology{AlignmentOffset: -1}, false, Warning, "device reports it cannot be aligned"},
+		{"optimal io", func(t *testing.T) []byte {
+			return mbrImage(64, 1920)
+		}, goblkid.Topology{OptimalIOSize: 1 << 20}, false, Warning,
+			"start 32768 is not aligned to the 1048576 byte optimal I/O size"},
+	}
+
+	for _, tt := range tests {
+		img := tt.img(t)
+		info := &goblkid.ProbeInfo{
+			DeviceReader: bytes.NewReader(img),
+			Size:         int64(len(img)),
+			BlockSize:    512,
+			Topology:     tt.topology,
+		}
+
+		if tt.broken {
+			info.DeviceReader = brokenReader{bytes.NewReader(img), 64 * 512, 128 * 512}
+		}
+
+		r, err := Probe(info, "test")
+		assert.Nil(t, err, tt.name)
+
+		found := false
+
+		for _, f := range r.Findings {
+			if f.Severity == tt.severity && strings.Contains(f.Message, tt.want) {
+				found = true
+			}
+		}
+
+		assert.True(t, found, "%s: %v", tt.name, r.Findings)
+	}
+}
@@ -1,6 +1,11 @@
 package main
 
 import (
+	"os"
+
+	"github.com/isi-lincoln/goblkid/check"
+	"github.com/isi-lincoln/goblkid/fat"
+	"github.com/isi-lincoln/goblkid/partitions"
 	goblkid "github.com/isi-lincoln/goblkid/wipefs"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -27,34 +32,321 @@ func main() {
 	}
 	root.AddCommand(wipe)
 
+	var codePage string
 	getInfo := &cobra.Command{
 		Use:   "info [device]",
 		Short: "Get block device information",
 		Args:  cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			info, err := goblkid.GetProbeInfo(args[0])
+			info, err := goblkid.GetProbeInfo(args[0], codePage)
 			if err != nil {
 				log.Fatal(err)
 			}
 			goblkid.PrintProbeInfo(info)
+
+			health, err := goblkid.GetExtHealth(args[0], info)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if health != nil {
+				goblkid.PrintExtHealth(health)
+			}
+
+			fatHealth, err := goblkid.GetFATHealth(args[0], info)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if fatHealth != nil {
+				goblkid.PrintFATHealth(fatHealth)
+			}
 		},
 	}
+	getInfo.Flags().StringVar(&codePage, "codepage", fat.CP437.Name,
+		"code page of FAT labels: cp437, cp850 or cp1252")
 	get.AddCommand(getInfo)
 
+	getTopology := &cobra.Command{
+		Use:   "topology [device]",
+		Short: "Get block device size and I/O topology",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			info, err := goblkid.GetTopology(args[0])
+			if err != nil {
+				log.Fatal(err)
+			}
+			goblkid.PrintTopology(info)
+		},
+	}
+	get.AddCommand(getTopology)
+
+	getExtSuper := &cobra.Command{
+		Use:   "ext-super [device]",
+		Short: "Dump the decoded ext superblock",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			details, err := goblkid.GetExtDetails(args[0])
+			if err != nil {
+				log.Fatal(err)
+			}
+			goblkid.PrintExtDetails(details)
+		},
+	}
+	get.AddCommand(getExtSuper)
+
+	var identify bool
+	getExtBackups := &cobra.Command{
+		Use:   "ext-backups [device]",
+		Short: "Find the ext backup superblocks and check them against the primary",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			report, info, err := goblkid.GetExtBackups(args[0], identify)
+			if err != nil {
+				log.Fatal(err)
+			}
+			goblkid.PrintExtBackups(report)
+			if info != nil {
+				goblkid.PrintProbeInfo(info)
+			}
+		},
+	}
+	getExtBackups.Flags().BoolVarP(&identify, "identify", "i", false,
+		"identify the filesystem from a backup when the primary is gone")
+	get.AddCommand(getExtBackups)
+
+	getFATBackup := &cobra.Command{
+		Use:   "fat-backup [device]",
+		Short: "Compare the FAT32 backup boot sector with the primary",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			backup, info, err := goblkid.GetFATBackup(args[0], identify)
+			if err != nil {
+				log.Fatal(err)
+			}
+			goblkid.PrintFATBackup(backup)
+			if info != nil {
+				goblkid.PrintProbeInfo(info)
+			}
+		},
+	}
+	getFATBackup.Flags().BoolVarP(&identify, "identify", "i", false,
+		"identify the volume from the backup when the primary is damaged")
+	get.AddCommand(getFATBackup)
+
+	getExtMMP := &cobra.Command{
+		Use:   "ext-mmp [device]",
+		Short: "Show whether multi-mount protection has the ext4 filesystem in use",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			mmp, err := goblkid.GetExtMMP(args[0])
+			if err != nil {
+				log.Fatal(err)
+			}
+			goblkid.PrintExtMMP(mmp)
+		},
+	}
+	get.AddCommand(getExtMMP)
+
+	getJournals := &cobra.Command{
+		Use:   "journals [devices...]",
+		Short: "Match external jbd2 journals with the ext filesystems using them",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			links, err := goblkid.GetJournalLinks(args)
+			if err != nil {
+				log.Fatal(err)
+			}
+			goblkid.PrintJournalLinks(links)
+		},
+	}
+	get.AddCommand(getJournals)
+
+	getParts := &cobra.Command{
+		Use:   "parts [device]",
+		Short: "Get partition table information",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			res, err := goblkid.ResolveDisk(args[0])
+			if err != nil {
+				log.Fatal(err)
+			}
+			goblkid.PrintResolution(res)
+		},
+	}
+	get.AddCommand(getParts)
+
+	checkDevice := &cobra.Command{
+		Use:   "check [device]",
+		Short: "Check partition alignment and filesystem sizes",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			report, err := check.Device(args[0])
+			if err != nil {
+				log.Fatal(err)
+			}
+			goblkid.PrintCheckReport(report)
+			if report.HasErrors() {
+				os.Exit(1)
+			}
+		},
+	}
+
+	checkFAT := &cobra.Command{
+		Use:   "fat [device]",
+		Short: "Check the FAT and directory tree of a FAT volume without changing it",
+		Long: "Check the FAT and directory tree of a FAT volume without changing it.\n" +
+			"Exits 1 when it finds a problem a fsck would repair or the hard error flag\n" +
+			"is cleared. A volume that was not cleanly unmounted is only a warning.",
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			report, err := goblkid.CheckFAT(args[0])
+			if err != nil {
+				log.Fatal(err)
+			}
+			goblkid.PrintFATCheck(args[0], report)
+			if !report.OK() {
+				os.Exit(1)
+			}
+		},
+	}
+	checkDevice.AddCommand(checkFAT)
+	root.AddCommand(checkDevice)
+
+	set := &cobra.Command{
+		Use:   "set",
+		Short: "set things",
+	}
+	root.AddCommand(set)
+
+	var backups bool
+	var labelCodePage string
+	setLabel := &cobra.Command{
+		Use:   "label [device] [label]",
+		Short: "Change the filesystem label",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			err := goblkid.SetLabel(args[0], args[1], backups, labelCodePage)
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+	setLabel.Flags().BoolVarP(&backups, "backups", "b", false,
+		"also update the ext backup superblocks, FAT always updates its backup boot sector")
+	setLabel.Flags().StringVar(&labelCodePage, "codepage", fat.CP437.Name,
+		"code page to encode FAT labels in: cp437, cp850 or cp1252")
+	set.AddCommand(setLabel)
+
+	var keepSeed bool
+	setUUID := &cobra.Command{
+		Use:   "uuid [device] [uuid|random]",
+		Short: "Change the filesystem uuid, or the volume serial of FAT",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			uuid, err := goblkid.SetUUID(args[0], args[1], backups, keepSeed)
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Infof("uuid: %s", uuid)
+		},
+	}
+	setUUID.Flags().BoolVarP(&backups, "backups", "b", false,
+		"also update the ext backup superblocks, FAT always updates its backup boot sector")
+	setUUID.Flags().BoolVar(&keepSeed, "csum-seed", false,
+		"with ext metadata_csum, enable metadata_csum_seed to keep the checksums valid; "+
+			"Linux before 4.4 and e2fsprogs before 1.43 cannot use the filesystem afterwards")
+	set.AddCommand(setUUID)
+
+	part := &cobra.Command{
+		Use:   "part",
+		Short: "partition things",
+	}
+	root.AddCommand(part)
+
+	var (
+		tableType string
+		partSpecs []string
+		alignment string
+		diskID    string
+	)
+
+	partCreate := &cobra.Command{
+		Use:   "create [device]",
+		Short: "Write a fresh MBR or GPT partition table",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			spec := &partitions.Spec{Type: tableType, ID: diskID}
+
+			align, err := partitions.ParseSize(alignment)
+			if err != nil {
+				log.Fatal(err)
+			}
+			spec.Alignment = align
+
+			for _, s := range partSpecs {
+				ps, err := partitions.ParsePartitionSpec(s)
+				if err != nil {
+					log.Fatal(err)
+				}
+				spec.Partitions = append(spec.Partitions, ps)
+			}
+
+			table, err := goblkid.CreatePartitionTable(args[0], spec)
+			if err != nil {
+				log.Fatal(err)
+			}
+			goblkid.PrintPartitionTable(table)
+		},
+	}
+	partCreate.Flags().StringVarP(
+		&tableType, "table", "t", partitions.GPTName, "partition table type: gpt or dos")
+	partCreate.Flags().StringArrayVarP(
+		&partSpecs, "part", "p", nil, "partition as size=512M|50%,type=efi,name=ESP,uuid=...,boot")
+	partCreate.Flags().StringVar(
+		&alignment, "align", "1M", "partition start alignment")
+	partCreate.Flags().StringVar(
+		&diskID, "id", "", "disk guid or dos disk id, random by default")
+	part.AddCommand(partCreate)
+
 	// GET COMMANDS
+	var wipeOpts goblkid.WipeOptions
 	wipeFS := &cobra.Command{
 		Use:   "fs [device]",
-		Short: "Get block device information",
+		Short: "Wipe the filesystem signature",
 		Args:  cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			err := goblkid.WipeFileSystemSignature(args[0])
+			plan, err := goblkid.Wipe(args[0], wipeOpts)
+			if plan != nil {
+				goblkid.PrintWipePlan(plan)
+			}
 			if err != nil {
 				log.Fatal(err)
 			}
 		},
 	}
+	wipeFS.Flags().BoolVar(&wipeOpts.Deep, "deep", false,
+		"also wipe backup superblocks, group descriptor copies and the FAT32 and exFAT backup boot sectors")
+	wipeFS.Flags().BoolVarP(&wipeOpts.NoAct, "no-act", "n", false,
+		"print what would be wiped without writing")
+	wipeFS.Flags().StringVarP(&wipeOpts.BackupDir, "backup", "b", "",
+		"save the wiped bytes to wipefs-<device>-0x<offset>.bak files in this directory")
+	wipeFS.Flags().BoolVarP(&wipeOpts.Force, "force", "f", false,
+		"wipe even when multi-mount protection shows the filesystem in use, "+
+			"or when filesystems still list the journal device as theirs")
 	wipe.AddCommand(wipeFS)
 
+	wipeRestore := &cobra.Command{
+		Use:   "restore [device] [backup files...]",
+		Short: "Write back the bytes saved by wipe fs --backup",
+		Args:  cobra.MinimumNArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			err := goblkid.RestoreWipe(args[0], args[1:])
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+	wipe.AddCommand(wipeRestore)
+
 	root.PersistentFlags().BoolVarP(
 		&verbose, "verbose", "v", false, "verbose output")
 
@@ -0,0 +1,379 @@
+package ext
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/isi-lincoln/goblkid"
+)
+
+// Primary superblock states reported by FindBackups
+const (
+	PrimaryOK      = "ok"
+	PrimaryWiped   = "wiped"
+	PrimaryCorrupt = "corrupt"
+	PrimaryMissing = "missing"
+)
+
+// smallest and largest block size mke2fs creates
+const (
+	minBlockSize = 1 << 10
+	maxBlockSize = 64 << 10
+)
+
+// Backup is a single backup superblock location
+type Backup struct {
+	Group  uint32
+	Offset int64 /* in bytes from the start of the filesystem */
+
+	Present    bool
+	Consistent bool
+	Problem    string
+}
+
+// BackupReport describes the primary superblock and all of its backups
+type BackupReport struct {
+	Primary   string
+	BlockSize uint64
+	UUID      goblkid.UUID
+	Backups   []Backup
+}
+
+// Usable returns the first backup that is present and consistent
+func (r *BackupReport) Usable() *Backup {
+	for i := range r.Backups {
+		if r.Backups[i].Present && r.Backups[i].Consistent {
+			return &r.Backups[i]
+		}
+	}
+
+	return nil
+}
+
+// FindBackups locates the backup superblocks of the filesystem behind info.
+// With a healthy primary its geometry says where to look; without one the
+// first backup is searched for at group 1 for every block size from 1K to
+// 64K, which is where both sparse_super and sparse_super2 keep one.
+func FindBackups(info *goblkid.ProbeInfo) (*BackupReport, error) {
+	r := &BackupReport{Primary: PrimaryMissing}
+
+	primary, buf, err := readRawSuper(info, int64(ExtMagic[0].SuperblockKbOffset<<10)) // nolint:gomnd
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+
+	ref := primary
+
+	switch {
+	case primary == nil:
+	case string(primary.Magic[:]) != ExtMagic[0].Magic:
+	case verifySuperChecksum(primary, buf) != nil:
+		r.Primary = PrimaryCorrupt
+	default:
+		r.Primary = PrimaryOK
+	}
+
+	if r.Primary != PrimaryOK {
+		ref, err = searchBackup(info)
+		if err != nil {
+			return nil, err
+		}
+
+		if ref == nil {
+			return r, nil
+		}
+
+		if r.Primary == PrimaryMissing && wipedCopy(primary, buf, ref) {
+			r.Primary = PrimaryWiped
+		}
+	}
+
+	r.BlockSize = extBlockSize(ref)
+	r.UUID = goblkid.NewUUID(goblkid.RFC4122, ref.UUID[:])
+
+	for _, g := range backupGroups(ref) {
+		b := Backup{Group: g, Offset: backupOffset(ref, g)}
+		b.Present, b.Consistent, b.Problem = checkBackup(info, ref, g, b.Offset)
+		r.Backups = append(r.Backups, b)
+	}
+
+	return r, nil
+}
+
+/*
+ * wipedCopy reports whether sb is ref with only its magic cleared, which is
+ * what wipeExt leaves behind. With metadata_csum the checksum must hold
+ * once the magic is put back.
+ */
+func wipedCopy(sb *ext2SuperBlock, buf []byte, ref *ext2SuperBlock) bool {
+	if sb == nil || sb.Magic != [2]byte{} || sb.UUID != ref.UUID || sb.BlocksCount != ref.BlocksCount {
+		return false
+	}
+
+	restored := append([]byte{}, buf...)
+	copy(restored[ExtMagic[0].MagicByteOffset:], ExtMagic[0].Magic)
+
+	return verifySuperChecksum(sb, restored) == nil
+}
+
+// ProbeBackup identifies the filesystem from its first usable backup when
+// the primary superblock is gone, filling info like Chain.Probe does
+func ProbeBackup(info *goblkid.ProbeInfo) (*Backup, error) {
+	r, err := FindBackups(info)
+	if err != nil {
+		return nil, err
+	}
+
+	b := r.Usable()
+	if b == nil {
+		return nil, nil
+	}
+
+	/* shift the view so the backup sits where the probers expect the primary */
+	shifted := *info
+	shifted.Offset = info.Offset + b.Offset - int64(ExtMagic[0].SuperblockKbOffset<<10) // nolint:gomnd
+
+	ok, err := Chain.Probe(&shifted)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	shifted.Offset = info.Offset
+	*info = shifted
+
+	return b, nil
+}
+
+/* searchBackup looks for the group 1 backup of a filesystem of unknown geometry */
+func searchBackup(info *goblkid.ProbeInfo) (*ext2SuperBlock, error) {
+	for bs := uint64(minBlockSize); bs <= maxBlockSize; bs <<= 1 {
+		first := uint64(0)
+		if bs == minBlockSize {
+			first = 1
+		}
+
+		off := int64((8*bs + first) * bs) // nolint:gomnd
+		if info.Size > 0 && off+ext2SuperBlockSize > info.Size {
+			break
+		}
+
+		sb, err := readSuper(info, off)
+		if err != nil {
+			continue
+		}
+
+		if string(sb.Magic[:]) == ExtMagic[0].Magic && extBlockSize(sb) == bs {
+			return sb, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func checkBackup(info *goblkid.ProbeInfo, ref *ext2SuperBlock, group uint32, off int64) (bool, bool, string) {
+	sb, err := readSuper(info, off)
+
+	switch {
+	case errors.Is(err, goblkid.ErrCorrupt):
+		return true, false, err.Error()
+	case err != nil:
+		return false, false, err.Error()
+	case string(sb.Magic[:]) != ExtMagic[0].Magic:
+		return false, false, "no superblock magic"
+	case sb.UUID != ref.UUID:
+		return true, false, "belongs to another filesystem"
+	case sb.BlocksCount != ref.BlocksCount || sb.BlocksCountHi != ref.BlocksCountHi ||
+		sb.LogBlockSize != ref.LogBlockSize || sb.BlocksPerGroup != ref.BlocksPerGroup ||
+		sb.InodesCount != ref.InodesCount:
+		return true, false, "geometry differs from the primary"
+	case sb.BlockGroupNr != 0 && sb.BlockGroupNr != uint16(group): /* only 16 bits on disk */
+		return true, false, fmt.Sprintf("claims to be the backup of group %d", sb.BlockGroupNr)
+	}
+
+	return true, true, ""
+}
+
+func groupCount(sb *ext2SuperBlock) uint32 {
+	if sb.BlocksPerGroup == 0 {
+		return 0
+	}
+
+	blocks := extBlocksCount(sb) - uint64(sb.FirstDataBlock)
+
+	return uint32((blocks + uint64(sb.BlocksPerGroup) - 1) / uint64(sb.BlocksPerGroup))
+}
+
+/* backupGroups lists the groups holding a superblock backup, as mke2fs lays them out */
+func backupGroups(sb *ext2SuperBlock) []uint32 {
+	count := groupCount(sb)
+	groups := []uint32{}
+
+	switch {
+	case sb.FeatureCompat&EXT4_FEATURE_COMPAT_SPARSE_SUPER2 != 0:
+		for _, g := range sb.BackupBgs {
+			if g != 0 && g < count {
+				groups = append(groups, g)
+			}
+		}
+	case sb.FeatureRoCompat&EXT2_FEATURE_RO_COMPAT_SPARSE_SUPER == 0:
+		for g := uint32(1); g < count; g++ {
+			groups = append(groups, g)
+		}
+	default:
+		for g := uint32(1); g < count; g++ {
+			if isPowerOf(g, 3) || isPowerOf(g, 5) || isPowerOf(g, 7) { // nolint:gomnd
+				groups = append(groups, g)
+			}
+		}
+	}
+
+	return groups
+}
+
+func backupOffset(sb *ext2SuperBlock, group uint32) int64 {
+	block := uint64(group)*uint64(sb.BlocksPerGroup) + uint64(sb.FirstDataBlock)
+	return int64(block * extBlockSize(sb))
+}
+
+func isPowerOf(n, base uint32) bool {
+	for n > 1 && n%base == 0 {
+		n /= base
+	}
+
+	return n == 1
+}
+
+// Extent is a byte range of the filesystem holding metadata
+type Extent struct {
+	Offset int64 /* in bytes from the start of the filesystem */
+	Length int64
+	What   string
+}
+
+// MetadataCopies locates every backup superblock and group descriptor copy
+// from the geometry of the primary superblock. The primary copies and the
+// blocks reserved for online resize are left out.
+func MetadataCopies(info *goblkid.ProbeInfo) ([]Extent, error) {
+	sb, err := ext2GetSuper(info)
+	if err != nil {
+		return nil, err
+	}
+
+	if string(sb.Magic[:]) != ExtMagic[0].Magic {
+		return nil, fmt.Errorf("no ext superblock magic")
+	}
+
+	bs := int64(extBlockSize(sb))
+	count := groupCount(sb)
+	perBlock := uint32(bs) / descSize(sb)
+	gdtBlocks := int64((count + perBlock - 1) / perBlock)
+
+	metaBg := sb.FeatureIncompat&EXT2_FEATURE_INCOMPAT_META_BG != 0
+	if metaBg && int64(sb.FirstMetaBg) < gdtBlocks {
+		/* past first_meta_bg each meta group keeps its own descriptor block */
+		gdtBlocks = int64(sb.FirstMetaBg)
+	}
+
+	extents := []Extent{}
+	hasSuper := map[uint32]bool{0: true}
+
+	for _, g := range backupGroups(sb) {
+		hasSuper[g] = true
+		off := backupOffset(sb, g)
+
+		extents = append(extents, Extent{off, ext2SuperBlockSize, fmt.Sprintf("superblock backup in group %d", g)})
+
+		if gdtBlocks > 0 {
+			extents = append(extents, Extent{off + bs, gdtBlocks * bs, fmt.Sprintf("group descriptors in group %d", g)})
+		}
+	}
+
+	if !metaBg {
+		return extents, nil
+	}
+
+	/* meta_bg keeps copies in the second and last group of each meta group */
+	for m := sb.FirstMetaBg; m*perBlock < count; m++ {
+		for _, g := range []uint32{m*perBlock + 1, m*perBlock + perBlock - 1} {
+			if g >= count {
+				continue
+			}
+
+			off := backupOffset(sb, g)
+			if hasSuper[g] {
+				off += bs
+			}
+
+			extents = append(extents, Extent{off, bs, fmt.Sprintf("meta_bg %d descriptors in group %d", m, g)})
+		}
+	}
+
+	return extents, nil
+}
+
+/* descSize is the size of a group descriptor, only variable with 64bit */
+func descSize(sb *ext2SuperBlock) uint32 {
+	if sb.FeatureIncompat&EXT4_FEATURE_INCOMPAT_64BIT != 0 && sb.DescSize >= 64 { // nolint:gomnd
+		return uint32(sb.DescSize)
+	}
+
+	return 32 // nolint:gomnd
+}
+
+/*
+ * descBlocks lists the byte offsets of every group descriptor block, the
+ * primary first and its backup copies after it. Before first_meta_bg the
+ * descriptor table follows each superblock, past it every meta group keeps
+ * its block in its first, second and last group.
+ */
+func descBlocks(sb *ext2SuperBlock) [][]int64 {
+	bs := int64(extBlockSize(sb))
+	count := groupCount(sb)
+	perBlock := uint32(bs) / descSize(sb)
+	metaBlocks := (count + perBlock - 1) / perBlock
+
+	classic := metaBlocks
+	if sb.FeatureIncompat&EXT2_FEATURE_INCOMPAT_META_BG != 0 && sb.FirstMetaBg < classic {
+		classic = sb.FirstMetaBg
+	}
+
+	groups := backupGroups(sb)
+	hasSuper := map[uint32]bool{0: true}
+
+	for _, g := range groups {
+		hasSuper[g] = true
+	}
+
+	blocks := make([][]int64, 0, metaBlocks)
+
+	for i := uint32(0); i < classic; i++ {
+		offsets := []int64{(int64(sb.FirstDataBlock) + 1 + int64(i)) * bs}
+		for _, g := range groups {
+			offsets = append(offsets, backupOffset(sb, g)+bs+int64(i)*bs)
+		}
+
+		blocks = append(blocks, offsets)
+	}
+
+	for m := classic; m < metaBlocks; m++ {
+		offsets := []int64{}
+
+		for _, g := range []uint32{m * perBlock, m*perBlock + 1, m*perBlock + perBlock - 1} {
+			if g >= count || (len(offsets) > 0 && g == m*perBlock) {
+				continue
+			}
+
+			off := backupOffset(sb, g)
+			if hasSuper[g] {
+				off += bs
+			}
+
+			offsets = append(offsets, off)
+		}
+
+		blocks = append(blocks, offsets)
+	}
+
+	return blocks
+}
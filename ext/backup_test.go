@@ -0,0 +1,145 @@
+package ext
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/isi-lincoln/goblkid"
+	"github.com/stretchr/testify/assert"
+)
+
+/* backupImage lays out a 1k block, 4 group filesystem with sparse_super backups */
+func backupImage(t *testing.T) ([]byte, *ext2SuperBlock) {
+	t.Helper()
+
+	sb := &ext2SuperBlock{
+		InodesCount:     8192,
+		BlocksCount:     4 * 8192,
+		FirstDataBlock:  1,
+		BlocksPerGroup:  8192,
+		InodesPerGroup:  2048,
+		RevLevel:        1,
+		InodeSize:       256,
+		FeatureCompat:   EXT3_FEATURE_COMPAT_HAS_JOURNAL,
+		FeatureIncompat: EXT4_FEATURE_INCOMPAT_EXTENTS,
+		FeatureRoCompat: EXT2_FEATURE_RO_COMPAT_SPARSE_SUPER | EXT4_FEATURE_RO_COMPAT_METADATA_CSUM,
+		ChecksumType:    EXT4_CRC32C_CHKSUM,
+	}
+	copy(sb.UUID[:], []byte{0x5e, 0xa7, 0xc8, 0xac, 0x97, 0xa9, 0x4b, 0xe4,
+		0x8f, 0xb9, 0x9d, 0xeb, 0xf8, 0x5b, 0xbb, 0x6a})
+
+	img := make([]byte, 4*8192*1024+1024)
+	copy(img[1024:], packSuper(t, sb))
+
+	for _, g := range []uint16{1, 3} {
+		backup := *sb
+		backup.BlockGroupNr = g
+		copy(img[backupOffset(sb, uint32(g)):], packSuper(t, &backup))
+	}
+
+	return img, sb
+}
+
+func TestBackupGroups(t *testing.T) {
+	sb := &ext2SuperBlock{BlocksCount: 50 * 8192, BlocksPerGroup: 8192}
+	assert.Equal(t, 49, len(backupGroups(sb)))
+
+	sb.FeatureRoCompat = EXT2_FEATURE_RO_COMPAT_SPARSE_SUPER
+	assert.Equal(t, []uint32{1, 3, 5, 7, 9, 25, 27, 49}, backupGroups(sb))
+
+	sb.FeatureCompat = EXT4_FEATURE_COMPAT_SPARSE_SUPER2
+	sb.BackupBgs = [2]uint32{1, 49}
+	assert.Equal(t, []uint32{1, 49}, backupGroups(sb))
+}
+
+func TestFindBackups(t *testing.T) {
+	img, sb := backupImage(t)
+
+	r, err := FindBackups(&goblkid.ProbeInfo{DeviceReader: bytes.NewReader(img)})
+	assert.Nil(t, err)
+	assert.Equal(t, PrimaryOK, r.Primary)
+	assert.Equal(t, uint64(1024), r.BlockSize)
+	assert.Equal(t, []uint32{1, 3}, []uint32{r.Backups[0].Group, r.Backups[1].Group})
+	assert.True(t, r.Backups[0].Consistent)
+	assert.True(t, r.Backups[1].Consistent)
+
+	/* wipeExt only clears the magic */
+	img[1024+0x38], img[1024+0x39] = 0, 0
+	r, err = FindBackups(&goblkid.ProbeInfo{DeviceReader: bytes.NewReader(img)})
+	assert.Nil(t, err)
+	assert.Equal(t, PrimaryWiped, r.Primary)
+	assert.Equal(t, "5ea7c8ac-97a9-4be4-8fb9-9debf85bbb6a", r.UUID.String())
+
+	/* anything else is damage */
+	img[1024+0x78] = 'x'
+	r, err = FindBackups(&goblkid.ProbeInfo{DeviceReader: bytes.NewReader(img)})
+	assert.Nil(t, err)
+	assert.Equal(t, PrimaryMissing, r.Primary)
+
+	/* a backup of another filesystem is not consistent */
+	other := *sb
+	other.UUID[0]++
+	other.BlockGroupNr = 3
+	copy(img[backupOffset(sb, 3):], packSuper(t, &other))
+	r, err = FindBackups(&goblkid.ProbeInfo{DeviceReader: bytes.NewReader(img)})
+	assert.Nil(t, err)
+	assert.False(t, r.Backups[1].Consistent)
+}
+
+func TestCheckBackupHighGroup(t *testing.T) {
+	_, sb := backupImage(t)
+
+	/* s_block_group_nr keeps the low 16 bits of groups past 65535 */
+	backup := *sb
+	backup.BlockGroupNr = 1
+	img := make([]byte, 4096)
+	copy(img[2048:], packSuper(t, &backup))
+	info := &goblkid.ProbeInfo{DeviceReader: bytes.NewReader(img)}
+
+	found, consistent, why := checkBackup(info, sb, 0x10001, 2048)
+	assert.True(t, found)
+	assert.True(t, consistent, why)
+
+	_, consistent, why = checkBackup(info, sb, 0x10003, 2048)
+	assert.False(t, consistent)
+	assert.Equal(t, "claims to be the backup of group 1", why)
+}
+
+func TestProbeBackup(t *testing.T) {
+	img, _ := backupImage(t)
+	img[1024+0x38], img[1024+0x39] = 0, 0
+
+	info := &goblkid.ProbeInfo{DeviceReader: bytes.NewReader(img)}
+	ok, err := Chain.Probe(info)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	b, err := ProbeBackup(info)
+	assert.Nil(t, err)
+	assert.NotNil(t, b)
+	assert.Equal(t, uint32(1), b.Group)
+	assert.Equal(t, Ext4Name, info.ProbeName)
+	assert.Equal(t, "5ea7c8ac-97a9-4be4-8fb9-9debf85bbb6a", info.UUID.String())
+	assert.Equal(t, int64(0), info.Offset)
+}
+
+func TestMetadataCopies(t *testing.T) {
+	img, sb := backupImage(t)
+
+	copies, err := MetadataCopies(&goblkid.ProbeInfo{DeviceReader: bytes.NewReader(img)})
+	assert.Nil(t, err)
+	assert.Equal(t, []Extent{
+		{backupOffset(sb, 1), 1024, "superblock backup in group 1"},
+		{backupOffset(sb, 1) + 1024, 1024, "group descriptors in group 1"},
+		{backupOffset(sb, 3), 1024, "superblock backup in group 3"},
+		{backupOffset(sb, 3) + 1024, 1024, "group descriptors in group 3"},
+	}, copies)
+
+	sb.FeatureIncompat |= EXT2_FEATURE_INCOMPAT_META_BG
+	copy(img[1024:], packSuper(t, sb))
+
+	copies, err = MetadataCopies(&goblkid.ProbeInfo{DeviceReader: bytes.NewReader(img)})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(copies))
+	assert.Equal(t, Extent{backupOffset(sb, 1) + 1024, 1024, "meta_bg 0 descriptors in group 1"}, copies[2])
+}
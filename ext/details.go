@@ -0,0 +1,313 @@
+package ext
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/isi-lincoln/goblkid"
+)
+
+// Feature is a named superblock feature bit
+type Feature struct {
+	Mask uint32
+	Name string
+}
+
+// Feature names as e2fsprogs prints them
+var (
+	CompatFeatures = []Feature{ // nolint:gochecknoglobals
+		{0x0001, "dir_prealloc"},
+		{0x0002, "imagic_inodes"},
+		{0x0004, "has_journal"},
+		{0x0008, "ext_attr"},
+		{0x0010, "resize_inode"},
+		{0x0020, "dir_index"},
+		{0x0040, "lazy_bg"},
+		{0x0080, "exclude_inode"},
+		{0x0100, "snapshot_bitmap"},
+		{0x0200, "sparse_super2"},
+		{0x0400, "fast_commit"},
+		{0x0800, "stable_inodes"},
+		{0x1000, "orphan_file"},
+	}
+	IncompatFeatures = []Feature{ // nolint:gochecknoglobals
+		{0x0001, "compression"},
+		{0x0002, "filetype"},
+		{0x0004, "needs_recovery"},
+		{0x0008, "journal_dev"},
+		{0x0010, "meta_bg"},
+		{0x0040, "extent"},
+		{0x0080, "64bit"},
+		{0x0100, "mmp"},
+		{0x0200, "flex_bg"},
+		{0x0400, "ea_inode"},
+		{0x1000, "dirdata"},
+		{0x2000, "metadata_csum_seed"},
+		{0x4000, "large_dir"},
+		{0x8000, "inline_data"},
+		{0x10000, "encrypt"},
+		{0x20000, "casefold"},
+	}
+	RoCompatFeatures = []Feature{ // nolint:gochecknoglobals
+		{0x0001, "sparse_super"},
+		{0x0002, "large_file"},
+		{0x0004, "btree_dir"},
+		{0x0008, "huge_file"},
+		{0x0010, "uninit_bg"},
+		{0x0020, "dir_nlink"},
+		{0x0040, "extra_isize"},
+		{0x0080, "snapshot"},
+		{0x0100, "quota"},
+		{0x0200, "bigalloc"},
+		{0x0400, "metadata_csum"},
+		{0x0800, "replica"},
+		{0x1000, "read-only"},
+		{0x2000, "project"},
+		{0x4000, "shared_blocks"},
+		{0x8000, "verity"},
+		{0x10000, "orphan_present"},
+	}
+)
+
+// superblock state bits
+const (
+	EXT2_VALID_FS  = 0x0001 // nolint:golint,stylecheck
+	EXT2_ERROR_FS  = 0x0002 // nolint:golint,stylecheck
+	EXT3_ORPHAN_FS = 0x0004 // nolint:golint,stylecheck
+)
+
+// DecodeFeatures names every set bit, unknown ones as FEATURE_<kind><bit>
+func DecodeFeatures(table []Feature, kind string, flags uint32) []string {
+	names := []string{}
+
+	for bit := uint(0); bit < 32; bit++ {
+		mask := uint32(1) << bit
+		if flags&mask == 0 {
+			continue
+		}
+
+		name := fmt.Sprintf("FEATURE_%s%d", kind, bit)
+
+		for _, f := range table {
+			if f.Mask == mask {
+				name = f.Name
+				break
+			}
+		}
+
+		names = append(names, name)
+	}
+
+	return names
+}
+
+// Details is the decoded content of an ext superblock, the equivalent of
+// the header printed by dumpe2fs -h
+type Details struct {
+	Label         string
+	UUID          goblkid.UUID
+	LastMountedOn string
+
+	RevLevel      uint32
+	MinorRevLevel uint16
+	CreatorOS     string
+
+	Compat   []string
+	Incompat []string
+	RoCompat []string
+
+	State  string
+	Errors string
+
+	BlockSize   uint64
+	ClusterSize uint64
+	InodeSize   uint16
+
+	InodesCount     uint32
+	FreeInodesCount uint32
+	BlocksCount     uint64
+	RBlocksCount    uint64
+	FreeBlocksCount uint64
+	FirstDataBlock  uint32
+	BlocksPerGroup  uint32
+	InodesPerGroup  uint32
+	FirstInode      uint32
+
+	Created       time.Time
+	LastMount     time.Time
+	LastWrite     time.Time
+	LastCheck     time.Time
+	CheckInterval time.Duration
+	MountCount    uint16
+	MaxMountCount int16
+
+	JournalUUID   goblkid.UUID
+	JournalInode  uint32
+	JournalDevice uint32
+	LastOrphan    uint32
+	ErrorCount    uint32
+}
+
+// ReadDetails reads and decodes the superblock of the ext filesystem
+// behind info
+func ReadDetails(info *goblkid.ProbeInfo) (*Details, error) {
+	ok, err := ExtMagic[0].Match(info)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("no ext superblock magic")
+	}
+
+	sb, err := ext2GetSuper(info)
+	if err != nil {
+		return nil, err
+	}
+
+	return newDetails(sb), nil
+}
+
+func newDetails(sb *ext2SuperBlock) *Details {
+	d := &Details{
+		Label:         cString(sb.VolumeName[:]),
+		UUID:          goblkid.NewUUID(goblkid.RFC4122, sb.UUID[:]),
+		LastMountedOn: cString(sb.LastMounted[:]),
+
+		RevLevel:      sb.RevLevel,
+		MinorRevLevel: sb.MinorRevLevel,
+		CreatorOS:     creatorOS(sb.CreatorOs),
+
+		Compat:   DecodeFeatures(CompatFeatures, "C", sb.FeatureCompat),
+		Incompat: DecodeFeatures(IncompatFeatures, "I", sb.FeatureIncompat),
+		RoCompat: DecodeFeatures(RoCompatFeatures, "R", sb.FeatureRoCompat),
+
+		State:  fsState(sb.State),
+		Errors: errorBehavior(sb.Errors),
+
+		BlockSize:   extBlockSize(sb),
+		ClusterSize: 1024 << sb.LogClusterSize, // nolint:gomnd
+		InodeSize:   extInodeSize(sb),
+
+		InodesCount:     sb.InodesCount,
+		FreeInodesCount: sb.FreeInodesCount,
+		BlocksCount:     extBlocksCount(sb),
+		RBlocksCount:    hiLo(sb, sb.RBlocksCountHi, sb.RBlocksCount),
+		FreeBlocksCount: hiLo(sb, sb.FreeBlocksHi, sb.FreeBlocksCount),
+		FirstDataBlock:  sb.FirstDataBlock,
+		BlocksPerGroup:  sb.BlocksPerGroup,
+		InodesPerGroup:  sb.InodesPerGroup,
+		FirstInode:      sb.FirstIno,
+
+		Created:       timestamp(sb.MkfsTime, sb.MkfsTimeHi),
+		LastMount:     timestamp(sb.Mtime, sb.MtimeHi),
+		LastWrite:     timestamp(sb.Wtime, sb.WtimeHi),
+		LastCheck:     timestamp(sb.Lastcheck, sb.LastcheckHi),
+		CheckInterval: time.Duration(sb.Checkinterval) * time.Second,
+		MountCount:    sb.MntCount,
+		MaxMountCount: sb.MaxMntCount,
+
+		JournalUUID:   goblkid.NewUUID(goblkid.RFC4122, sb.JournalUUID[:]),
+		JournalInode:  sb.JournalInum,
+		JournalDevice: sb.JournalDev,
+		LastOrphan:    sb.LastOrphan,
+		ErrorCount:    sb.ErrorCount,
+	}
+
+	if sb.FeatureRoCompat&EXT4_FEATURE_RO_COMPAT_BIGALLOC == 0 {
+		d.ClusterSize = d.BlockSize
+	}
+
+	return d
+}
+
+// Features returns every feature name in the order dumpe2fs prints them
+func (d *Details) Features() []string {
+	features := append([]string{}, d.Compat...)
+	features = append(features, d.Incompat...)
+
+	return append(features, d.RoCompat...)
+}
+
+/* the 64bit feature widens block counts with the *Hi halves */
+func hiLo(sb *ext2SuperBlock, hi, lo uint32) uint64 {
+	v := uint64(lo)
+	if sb.FeatureIncompat&EXT4_FEATURE_INCOMPAT_64BIT != 0 {
+		v |= uint64(hi) << 32 // nolint:gomnd
+	}
+
+	return v
+}
+
+/* revision 0 filesystems have fixed 128 byte inodes */
+func extInodeSize(sb *ext2SuperBlock) uint16 {
+	if sb.RevLevel == 0 {
+		return 128 // nolint:gomnd
+	}
+
+	return sb.InodeSize
+}
+
+func timestamp(lo uint32, hi uint8) time.Time {
+	if lo == 0 && hi == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(int64(lo)|int64(hi)<<32, 0).UTC() // nolint:gomnd
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+
+	return string(b)
+}
+
+func creatorOS(os uint32) string {
+	switch os {
+	case 0:
+		return "Linux"
+	case 1:
+		return "Hurd"
+	case 2: // nolint:gomnd
+		return "Masix"
+	case 3: // nolint:gomnd
+		return "FreeBSD"
+	case 4: // nolint:gomnd
+		return "Lites"
+	default:
+		return fmt.Sprintf("(unknown os %d)", os)
+	}
+}
+
+func fsState(state uint16) string {
+	s := "not clean"
+	if state&EXT2_VALID_FS != 0 {
+		s = "clean"
+	}
+
+	if state&EXT2_ERROR_FS != 0 {
+		s += " with errors"
+	}
+
+	if state&EXT3_ORPHAN_FS != 0 {
+		s += " orphans being recovered"
+	}
+
+	return s
+}
+
+func errorBehavior(errors uint16) string {
+	switch errors {
+	case 1:
+		return "Continue"
+	case 2: // nolint:gomnd
+		return "Remount read-only"
+	case 3: // nolint:gomnd
+		return "Panic"
+	default:
+		return fmt.Sprintf("Unknown (continue) %d", errors)
+	}
+}
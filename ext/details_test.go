@@ -0,0 +1,90 @@
+package ext
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/isi-lincoln/goblkid"
+	"github.com/lunixbochs/struc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSuperBlockSize(t *testing.T) {
+	size, err := struc.Sizeof(&ext2SuperBlock{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1024, size)
+}
+
+func TestDecodeFeatures(t *testing.T) {
+	assert.Equal(t, []string{"has_journal", "dir_index"},
+		DecodeFeatures(CompatFeatures, "C", 0x0024))
+	assert.Equal(t, []string{"extent", "64bit", "FEATURE_I31"},
+		DecodeFeatures(IncompatFeatures, "I", 0x800000c0))
+}
+
+func TestReadDetails(t *testing.T) {
+	sb := &ext2SuperBlock{
+		BlocksCount:     64,
+		LogBlockSize:    2,
+		RevLevel:        1,
+		InodeSize:       256,
+		FeatureCompat:   0x0004 | 0x0020 | 0x0080 | 0x0200 | 0x1000,
+		FeatureIncompat: 0x0040 | 0x0080 | 0x0200,
+		FeatureRoCompat: 0x0001 | 0x0400 | 0x10000,
+		ChecksumType:    EXT4_CRC32C_CHKSUM,
+	}
+	copy(sb.VolumeName[:], "rootfs")
+
+	d, err := ReadDetails(&goblkid.ProbeInfo{DeviceReader: bytes.NewReader(testImage(t, sb))})
+	assert.Nil(t, err)
+	assert.Equal(t, "rootfs", d.Label)
+	assert.Equal(t, uint64(4096), d.BlockSize)
+	assert.Equal(t, uint16(256), d.InodeSize)
+
+	/* exclude_inode holds bit 0x80, everything above it follows from there */
+	assert.Equal(t, []string{"has_journal", "dir_index", "exclude_inode", "sparse_super2", "orphan_file"}, d.Compat)
+	assert.Equal(t, []string{"extent", "64bit", "flex_bg"}, d.Incompat)
+	assert.Equal(t, []string{"sparse_super", "metadata_csum", "orphan_present"}, d.RoCompat)
+}
+
+/* the feature tables must name every bit as e2fsprogs does */
+func TestFeaturesMatchDumpe2fs(t *testing.T) {
+	for _, tool := range []string{"mkfs.ext4", "dumpe2fs"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not installed", tool)
+		}
+	}
+
+	img := filepath.Join(t.TempDir(), "fs.img")
+	assert.Nil(t, ioutil.WriteFile(img, make([]byte, 16<<20), 0600))
+
+	features := "^resize_inode,sparse_super2,orphan_file,quota,project,encrypt,casefold,verity,stable_inodes"
+	if out, err := exec.Command("mkfs.ext4", "-q", "-F", "-O", features, img).CombinedOutput(); err != nil {
+		t.Skipf("mkfs.ext4 -O %s: %v: %s", features, err, out)
+	}
+
+	out, err := exec.Command("dumpe2fs", "-h", img).Output()
+	assert.Nil(t, err)
+
+	var want []string
+
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "Filesystem features:") {
+			want = strings.Fields(strings.TrimPrefix(line, "Filesystem features:"))
+		}
+	}
+
+	fi, err := os.Open(img)
+	assert.Nil(t, err)
+
+	defer fi.Close()
+
+	d, err := ReadDetails(&goblkid.ProbeInfo{DeviceReader: fi})
+	assert.Nil(t, err)
+	assert.Equal(t, want, d.Features())
+}
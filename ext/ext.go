@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 	"io"
 
 	"github.com/isi-lincoln/goblkid"
@@ -28,15 +29,24 @@ const (
 	EXT3_FEATURE_INCOMPAT_JOURNAL_DEV  = 0x0008                               // nolint:golint,stylecheck
 	EXT3_FEATURE_RO_COMPAT_UNSUPPORTED = ^uint32(EXT3_FEATURE_RO_COMPAT_SUPP) // nolint:golint,stylecheck
 
-	EXT4_FEATURE_RO_COMPAT_HUGE_FILE   = 0x0008                    // nolint:golint,stylecheck
-	EXT4_FEATURE_RO_COMPAT_GDT_CSUM    = 0x0010                    // nolint:golint,stylecheck
-	EXT4_FEATURE_RO_COMPAT_DIR_NLINK   = 0x0020                    // nolint:golint,stylecheck
-	EXT4_FEATURE_RO_COMPAT_EXTRA_ISIZE = 0x0040                    // nolint:golint,stylecheck
-	EXT4_FEATURE_INCOMPAT_EXTENTS      = 0x0040                    // nolint:golint,stylecheck
-	EXT4_FEATURE_INCOMPAT_64BIT        = 0x0080                    // nolint:golint,stylecheck
-	EXT4_FEATURE_INCOMPAT_MMP          = 0x0100                    // nolint:golint,stylecheck
-	EXT4_FEATURE_INCOMPAT_FLEX_BG      = 0x0200                    // nolint:golint,stylecheck
-	EXT4_SUPPORTS_EXT2                 = (2 << 16) + (6 << 8) + 29 // nolint:golint,stylecheck
+	EXT4_FEATURE_COMPAT_SPARSE_SUPER2    = 0x0200                    // nolint:golint,stylecheck
+	EXT4_FEATURE_RO_COMPAT_HUGE_FILE     = 0x0008                    // nolint:golint,stylecheck
+	EXT4_FEATURE_RO_COMPAT_GDT_CSUM      = 0x0010                    // nolint:golint,stylecheck
+	EXT4_FEATURE_RO_COMPAT_DIR_NLINK     = 0x0020                    // nolint:golint,stylecheck
+	EXT4_FEATURE_RO_COMPAT_EXTRA_ISIZE   = 0x0040                    // nolint:golint,stylecheck
+	EXT4_FEATURE_RO_COMPAT_BIGALLOC      = 0x0200                    // nolint:golint,stylecheck
+	EXT4_FEATURE_RO_COMPAT_METADATA_CSUM = 0x0400                    // nolint:golint,stylecheck
+	EXT4_CRC32C_CHKSUM                   = 1                         // nolint:golint,stylecheck
+	EXT4_FEATURE_INCOMPAT_EXTENTS        = 0x0040                    // nolint:golint,stylecheck
+	EXT4_FEATURE_INCOMPAT_64BIT          = 0x0080                    // nolint:golint,stylecheck
+	EXT4_FEATURE_INCOMPAT_MMP            = 0x0100                    // nolint:golint,stylecheck
+	EXT4_FEATURE_INCOMPAT_FLEX_BG        = 0x0200                    // nolint:golint,stylecheck
+	EXT4_FEATURE_INCOMPAT_CSUM_SEED      = 0x2000                    // nolint:golint,stylecheck
+	EXT4_FEATURE_INCOMPAT_CASEFOLD       = 0x20000                   // nolint:golint,stylecheck
+	EXT4_FEATURE_RO_COMPAT_QUOTA         = 0x0100                    // nolint:golint,stylecheck
+	EXT4_FEATURE_RO_COMPAT_PROJECT       = 0x2000                    // nolint:golint,stylecheck
+	EXT4_FEATURE_RO_COMPAT_VERITY        = 0x8000                    // nolint:golint,stylecheck
+	EXT4_SUPPORTS_EXT2                   = (2 << 16) + (6 << 8) + 29 // nolint:golint,stylecheck
 
 	EXT2_FEATURE_RO_COMPAT_SUPP = ( // nolint:golint,stylecheck
 	EXT2_FEATURE_RO_COMPAT_SPARSE_SUPER |
@@ -73,6 +83,10 @@ var (
 	}
 )
 
+const ext2SuperBlockSize = 1024
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli) // nolint:gochecknoglobals
+
 var Chain = goblkid.Chain{ // nolint:gochecknoglobals
 	Jbd2Prober,
 	Ext2Prober,
@@ -188,28 +202,91 @@ func ext4devProbe(info *goblkid.ProbeInfo, magic goblkid.MagicInfo) (bool, error
 }
 
 func ext2GetSuper(info *goblkid.ProbeInfo) (*ext2SuperBlock, error) {
+	return readSuper(info, int64(ExtMagic[0].SuperblockKbOffset<<10)) // nolint:gomnd
+}
+
+/* readSuper reads and verifies a superblock copy at offset into the device */
+func readSuper(info *goblkid.ProbeInfo, offset int64) (*ext2SuperBlock, error) {
+	sb, buf, err := readRawSuper(info, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifySuperChecksum(sb, buf); err != nil {
+		return nil, err
+	}
+
+	return sb, nil
+}
+
+/* readRawSuper reads a superblock copy without checking it */
+func readRawSuper(info *goblkid.ProbeInfo, offset int64) (*ext2SuperBlock, []byte, error) {
 	var sb ext2SuperBlock
 
-	_, err := info.DeviceReader.Seek(
-		int64(ExtMagic[0].SuperblockKbOffset<<10), // nolint:gomnd
-		io.SeekStart,
-	)
+	_, err := info.DeviceReader.Seek(info.Offset+offset, io.SeekStart)
+	if err != nil {
+		return nil, nil, err
+	}
 
+	buf := make([]byte, ext2SuperBlockSize)
+	if _, err := io.ReadFull(info.DeviceReader, buf); err != nil {
+		return nil, nil, err
+	}
+
+	err = struc.UnpackWithOrder(bytes.NewReader(buf), &sb, binary.LittleEndian)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	return &sb, buf, nil
+}
+
+/*
+ * With metadata_csum the last 4 bytes hold a crc32c of everything before
+ * them. libblkid refuses to identify a superblock failing it, and so do we,
+ * returning goblkid.ErrCorrupt so callers can tell it from no match.
+ */
+func verifySuperChecksum(sb *ext2SuperBlock, buf []byte) error {
+	if sb.FeatureRoCompat&EXT4_FEATURE_RO_COMPAT_METADATA_CSUM == 0 {
+		return nil
+	}
+
+	if sb.ChecksumType != EXT4_CRC32C_CHKSUM {
+		return fmt.Errorf("unknown ext checksum type %d: %w", sb.ChecksumType, goblkid.ErrCorrupt)
 	}
 
-	err = struc.UnpackWithOrder(info.DeviceReader, &sb, binary.LittleEndian)
+	if csum := superChecksum(buf); csum != sb.Checksum {
+		return fmt.Errorf("ext superblock checksum %08x != %08x: %w", csum, sb.Checksum, goblkid.ErrCorrupt)
+	}
+
+	return nil
+}
+
+/* crc32c seeded with ~0 and without the final inversion, as ext4 does */
+func superChecksum(buf []byte) uint32 {
+	return ^crc32.Checksum(buf[:ext2SuperBlockSize-4], crc32c)
+}
 
-	return &sb, err
+/* crc32c_le as the kernel has it: no inversion on the way in or out */
+func extChecksum(seed uint32, buf []byte) uint32 {
+	return ^crc32.Update(^seed, crc32c, buf)
+}
+
+/* csumSeed seeds every metadata checksum but the superblock's own */
+func csumSeed(sb *ext2SuperBlock) uint32 {
+	if sb.FeatureIncompat&EXT4_FEATURE_INCOMPAT_CSUM_SEED != 0 {
+		return sb.ChecksumSeed
+	}
+
+	return extChecksum(^uint32(0), sb.UUID[:])
 }
 
 func extGetInfo(info *goblkid.ProbeInfo, extVersion int, sb *ext2SuperBlock) {
-	info.Label = string(sb.VolumeName[:bytes.Index(sb.VolumeName[:], []byte{0})])
-	info.UUID = string(sb.UUID[:])
+	info.Label = cString(sb.VolumeName[:])
+	info.UUID = goblkid.NewUUID(goblkid.RFC4122, sb.UUID[:])
 
 	if sb.FeatureCompat&EXT3_FEATURE_COMPAT_HAS_JOURNAL != 0 {
-		info.ExtJournal = string(sb.JournalUUID[:])
+		info.ExtJournal = goblkid.NewUUID(goblkid.RFC4122, sb.JournalUUID[:])
 	}
 
 	if extVersion != 2 && sb.FeatureCompat&EXT2_FEATURE_INCOMPAT_UNSUPPORTED != 0 {
@@ -217,6 +294,21 @@ func extGetInfo(info *goblkid.ProbeInfo, extVersion int, sb *ext2SuperBlock) {
 	}
 
 	info.Version = fmt.Sprint(sb.RevLevel, ".", sb.MinorRevLevel)
+	info.FSBlockSize = extBlockSize(sb)
+	info.FSLastBlock = extBlocksCount(sb)
+	info.FSSize = info.FSLastBlock * info.FSBlockSize
+	info.FreeBlocks = hiLo(sb, sb.FreeBlocksHi, sb.FreeBlocksCount)
+	info.FreeInodes = uint64(sb.FreeInodesCount)
+
+	extFeatureTags(info, sb)
+}
+
+func extBlockSize(sb *ext2SuperBlock) uint64 {
+	return 1024 << sb.LogBlockSize // nolint:gomnd
+}
+
+func extBlocksCount(sb *ext2SuperBlock) uint64 {
+	return hiLo(sb, sb.BlocksCountHi, sb.BlocksCount)
 }
 
 var Jbd2Prober = goblkid.Prober{ // nolint:gochecknoglobals
@@ -255,58 +347,107 @@ var Ext4DevProber = goblkid.Prober{ // nolint:gochecknoglobals
 }
 
 type ext2SuperBlock struct {
-	InodesCount          uint32
-	BlocksCount          uint32
-	RBlocksCount         uint32
-	FreeBlocksCount      uint32
-	FreeInodesCount      uint32
-	FirstDataBlock       uint32
-	LogBlockSize         uint32
-	Dummy3               [7]uint32
-	Magic                [2]uint8
-	State                uint16
-	Errors               uint16
-	MinorRevLevel        uint16
-	Lastcheck            uint32
-	Checkinterval        uint32
-	CreatorOs            uint32
-	RevLevel             uint32
-	DefResuid            uint16
-	DefResgid            uint16
-	FirstIno             uint32
-	InodeSize            uint16
-	BlockGroupNr         uint16
-	FeatureCompat        uint32
-	FeatureIncompat      uint32
-	FeatureRoCompat      uint32
-	UUID                 [16]uint8
-	VolumeName           [16]uint8
-	LastMounted          [64]int8
-	AlgorithmUsageBitmap uint32
-	PreallocBlocks       uint8
-	PreallocDirBlocks    uint8
-	ReservedGdtBlocks    uint16
-	JournalUUID          [16]uint8
-	JournalInum          uint32
-	JournalDev           uint32
-	LastOrphan           uint32
-	HashSeed             [4]uint32
-	DefHashVersion       uint8
-	JnlBackupType        uint8
-	ReservedWordPad      uint16
-	DefaultMountOpts     uint32
-	FirstMetaBg          uint32
-	MkfsTime             uint32
-	JnlBlocks            [17]uint32
-	BlocksCountHi        uint32
-	RBlocksCountHi       uint32
-	FreeBlocksHi         uint32
-	MinExtraIsize        uint16
-	WantExtraIsize       uint16
-	Flags                uint32
-	RaidStride           uint16
-	MmpInterval          uint16
-	MmpBlock             uint64
-	RaidStripeWidth      uint32
-	Reserved             [163]uint32
+	/*000*/ InodesCount uint32
+	/*004*/ BlocksCount uint32
+	/*008*/ RBlocksCount uint32
+	/*00c*/ FreeBlocksCount uint32
+	/*010*/ FreeInodesCount uint32
+	/*014*/ FirstDataBlock uint32
+	/*018*/ LogBlockSize uint32
+	/*01c*/ LogClusterSize uint32
+	/*020*/ BlocksPerGroup uint32
+	/*024*/ ClustersPerGroup uint32
+	/*028*/ InodesPerGroup uint32
+	/*02c*/ Mtime uint32
+	/*030*/ Wtime uint32
+	/*034*/ MntCount uint16
+	/*036*/ MaxMntCount int16
+	/*038*/ Magic [2]uint8
+	/*03a*/ State uint16
+	/*03c*/ Errors uint16
+	/*03e*/ MinorRevLevel uint16
+	/*040*/ Lastcheck uint32
+	/*044*/ Checkinterval uint32
+	/*048*/ CreatorOs uint32
+	/*04c*/ RevLevel uint32
+	/*050*/ DefResuid uint16
+	/*052*/ DefResgid uint16
+	/*054*/ FirstIno uint32
+	/*058*/ InodeSize uint16
+	/*05a*/ BlockGroupNr uint16
+	/*05c*/ FeatureCompat uint32
+	/*060*/ FeatureIncompat uint32
+	/*064*/ FeatureRoCompat uint32
+	/*068*/ UUID [16]uint8
+	/*078*/ VolumeName [16]uint8
+	/*088*/ LastMounted [64]uint8
+	/*0c8*/ AlgorithmUsageBitmap uint32
+	/*0cc*/ PreallocBlocks uint8
+	/*0cd*/ PreallocDirBlocks uint8
+	/*0ce*/ ReservedGdtBlocks uint16
+	/*0d0*/ JournalUUID [16]uint8
+	/*0e0*/ JournalInum uint32
+	/*0e4*/ JournalDev uint32
+	/*0e8*/ LastOrphan uint32
+	/*0ec*/ HashSeed [4]uint32
+	/*0fc*/ DefHashVersion uint8
+	/*0fd*/ JnlBackupType uint8
+	/*0fe*/ DescSize uint16
+	/*100*/ DefaultMountOpts uint32
+	/*104*/ FirstMetaBg uint32
+	/*108*/ MkfsTime uint32
+	/*10c*/ JnlBlocks [17]uint32
+	/*150*/ BlocksCountHi uint32
+	/*154*/ RBlocksCountHi uint32
+	/*158*/ FreeBlocksHi uint32
+	/*15c*/ MinExtraIsize uint16
+	/*15e*/ WantExtraIsize uint16
+	/*160*/ Flags uint32
+	/*164*/ RaidStride uint16
+	/*166*/ MmpInterval uint16
+	/*168*/ MmpBlock uint64
+	/*170*/ RaidStripeWidth uint32
+	/*174*/ LogGroupsPerFlex uint8
+	/*175*/ ChecksumType uint8
+	/*176*/ EncryptionLevel uint8
+	/*177*/ ReservedPad uint8
+	/*178*/ KbytesWritten uint64
+	/*180*/ SnapshotInum uint32
+	/*184*/ SnapshotID uint32
+	/*188*/ SnapshotRBlocksCount uint64
+	/*190*/ SnapshotList uint32
+	/*194*/ ErrorCount uint32
+	/*198*/ FirstErrorTime uint32
+	/*19c*/ FirstErrorIno uint32
+	/*1a0*/ FirstErrorBlock uint64
+	/*1a8*/ FirstErrorFunc [32]uint8
+	/*1c8*/ FirstErrorLine uint32
+	/*1cc*/ LastErrorTime uint32
+	/*1d0*/ LastErrorIno uint32
+	/*1d4*/ LastErrorLine uint32
+	/*1d8*/ LastErrorBlock uint64
+	/*1e0*/ LastErrorFunc [32]uint8
+	/*200*/ MountOpts [64]uint8
+	/*240*/ UsrQuotaInum uint32
+	/*244*/ GrpQuotaInum uint32
+	/*248*/ OverheadClusters uint32
+	/*24c*/ BackupBgs [2]uint32
+	/*254*/ EncryptAlgos [4]uint8
+	/*258*/ EncryptPwSalt [16]uint8
+	/*268*/ LpfIno uint32
+	/*26c*/ PrjQuotaInum uint32
+	/*270*/ ChecksumSeed uint32
+	/*274*/ WtimeHi uint8
+	/*275*/ MtimeHi uint8
+	/*276*/ MkfsTimeHi uint8
+	/*277*/ LastcheckHi uint8
+	/*278*/ FirstErrorTimeHi uint8
+	/*279*/ LastErrorTimeHi uint8
+	/*27a*/ FirstErrorErrcode uint8
+	/*27b*/ LastErrorErrcode uint8
+	/*27c*/ Encoding uint16
+	/*27e*/ EncodingFlags uint16
+	/*280*/ OrphanFileInum uint32
+	/*284*/ Reserved [94]uint32
+	/*3fc*/ Checksum uint32
 }
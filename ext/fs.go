@@ -0,0 +1,542 @@
+package ext
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/isi-lincoln/goblkid"
+)
+
+// incompat features Open refuses, everything else is readable
+const (
+	EXT2_FEATURE_INCOMPAT_COMPRESSION = 0x0001 // nolint:golint,stylecheck
+	EXT4_FEATURE_INCOMPAT_DIRDATA     = 0x1000 // nolint:golint,stylecheck
+
+	fsIncompatUnsupported = EXT2_FEATURE_INCOMPAT_COMPRESSION |
+		EXT3_FEATURE_INCOMPAT_JOURNAL_DEV |
+		EXT4_FEATURE_INCOMPAT_DIRDATA
+)
+
+/* symlinks followed while resolving one path, as the kernel allows */
+const maxSymlinks = 40
+
+// FS is a read-only view of the files of an ext2/3/4 filesystem. It never
+// replays the journal, so a filesystem that needs recovery reads as it was
+// at the last checkpoint.
+type FS struct {
+	r  io.ReaderAt
+	sb *ext2SuperBlock
+
+	bs        int64
+	groups    uint32
+	descSize  uint32
+	inodeSize uint16
+
+	descBlocks [][]int64
+}
+
+var (
+	_ fs.ReadDirFS  = (*FS)(nil)
+	_ fs.ReadFileFS = (*FS)(nil)
+	_ fs.StatFS     = (*FS)(nil)
+)
+
+// Open reads the superblock of the filesystem in r and returns a
+// read-only fs.FS over its files
+func Open(r io.ReaderAt) (*FS, error) {
+	info := &goblkid.ProbeInfo{DeviceReader: io.NewSectionReader(r, 0, 1<<63-1)}
+
+	sb, err := ext2GetSuper(info)
+	if err != nil {
+		return nil, err
+	}
+
+	if string(sb.Magic[:]) != ExtMagic[0].Magic {
+		return nil, fmt.Errorf("no ext superblock magic")
+	}
+
+	if flags := sb.FeatureIncompat & fsIncompatUnsupported; flags != 0 {
+		return nil, fmt.Errorf("unsupported features: %s",
+			strings.Join(DecodeFeatures(IncompatFeatures, "I", flags), " "))
+	}
+
+	if sb.InodesPerGroup == 0 || sb.BlocksPerGroup == 0 || sb.LogBlockSize > 6 { // nolint:gomnd
+		return nil, fmt.Errorf("bad geometry: %w", goblkid.ErrCorrupt)
+	}
+
+	f := &FS{
+		r:         r,
+		sb:        sb,
+		bs:        int64(extBlockSize(sb)),
+		groups:    groupCount(sb),
+		descSize:  descSize(sb),
+		inodeSize: extInodeSize(sb),
+
+		descBlocks: descBlocks(sb),
+	}
+
+	return f, nil
+}
+
+// Open opens the named file, following symlinks
+func (f *FS) Open(name string) (fs.File, error) {
+	ino, err := f.lookup("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	st := f.stat(path.Base(name), ino)
+
+	if ino.isDir() {
+		return &dir{f: f, ino: ino, st: st}, nil
+	}
+
+	r, err := f.content(ino)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return &file{st: st, sr: io.NewSectionReader(r, 0, int64(ino.Size))}, nil
+}
+
+// ReadFile reads the named file, following symlinks
+func (f *FS) ReadFile(name string) ([]byte, error) {
+	file, err := f.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if _, ok := file.(*dir); ok {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errors.New("is a directory")}
+	}
+
+	return io.ReadAll(file)
+}
+
+// ReadDir reads the named directory, sorted by file name
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	ino, err := f.lookup("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &dir{f: f, ino: ino, st: f.stat(path.Base(name), ino)}
+
+	entries, err := d.ReadDir(-1)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+
+	return entries, nil
+}
+
+// Stat describes the named file, following symlinks
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	ino, err := f.lookup("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.stat(path.Base(name), ino), nil
+}
+
+// Lstat describes the named file without following a final symlink
+func (f *FS) Lstat(name string) (fs.FileInfo, error) {
+	ino, err := f.lookup("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.stat(path.Base(name), ino), nil
+}
+
+// ReadLink returns the target of the named symlink
+func (f *FS) ReadLink(name string) (string, error) {
+	ino, err := f.lookup("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+
+	if !ino.isSymlink() {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+
+	target, err := f.readLink(ino)
+	if err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: err}
+	}
+
+	return target, nil
+}
+
+/*
+ * lookup walks name from the root. Symlinks are resolved inside the
+ * filesystem, absolute targets from its root, so a link never escapes it.
+ */
+func (f *FS) lookup(op, name string, follow bool) (*inode, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	fail := func(err error) error {
+		return &fs.PathError{Op: op, Path: name, Err: err}
+	}
+
+	root, err := f.readInode(EXT2_ROOT_INO)
+	if err != nil {
+		return nil, fail(err)
+	}
+
+	parents := []*inode{}
+	cur := root
+	links := 0
+	rest := strings.Split(name, "/")
+
+	if name == "." {
+		rest = nil
+	}
+
+	for len(rest) > 0 {
+		elem := rest[0]
+		rest = rest[1:]
+
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			if len(parents) > 0 {
+				cur, parents = parents[len(parents)-1], parents[:len(parents)-1]
+			}
+
+			continue
+		}
+
+		if !cur.isDir() {
+			return nil, fail(errors.New("not a directory"))
+		}
+
+		num, err := f.find(cur, elem)
+		if err != nil {
+			return nil, fail(err)
+		}
+
+		next, err := f.readInode(num)
+		if err != nil {
+			return nil, fail(err)
+		}
+
+		if next.isSymlink() && (follow || len(rest) > 0) {
+			if links++; links > maxSymlinks {
+				return nil, fail(errors.New("too many levels of symbolic links"))
+			}
+
+			target, err := f.readLink(next)
+			if err != nil {
+				return nil, fail(err)
+			}
+
+			if strings.HasPrefix(target, "/") {
+				cur, parents = root, parents[:0]
+			}
+
+			rest = append(strings.Split(target, "/"), rest...)
+
+			continue
+		}
+
+		parents = append(parents, cur)
+		cur = next
+	}
+
+	return cur, nil
+}
+
+/* find looks name up in the directory ino */
+func (f *FS) find(ino *inode, name string) (uint32, error) {
+	var found uint32
+
+	err := f.dirents(ino, func(num uint32, entry string, _ uint8) bool {
+		if entry == name {
+			found = num
+			return false
+		}
+
+		return true
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	if found == 0 {
+		return 0, fs.ErrNotExist
+	}
+
+	return found, nil
+}
+
+/*
+ * dirents calls fn for every entry of a linear or htree directory until it
+ * returns false. htree index blocks read as a single unused entry, so
+ * walking every block visits each leaf once. With inline data the first 4
+ * bytes of i_block hold the parent inode and the rest are entries.
+ */
+func (f *FS) dirents(ino *inode, fn func(num uint32, name string, ftype uint8) bool) error {
+	if ino.Flags&EXT4_INLINE_DATA_FL != 0 {
+		data := ino.inlineData()
+		if len(data) < 4 { // nolint:gomnd
+			return fmt.Errorf("inline directory %d too short: %w", ino.num, goblkid.ErrCorrupt)
+		}
+
+		if !fn(binary.LittleEndian.Uint32(data), "..", 2) { // nolint:gomnd
+			return nil
+		}
+
+		split := EXT2_N_BLOCKS * 4
+		if len(data) < split {
+			split = len(data)
+		}
+
+		more, err := f.parseDirents(data[4:split], fn)
+		if err != nil || !more {
+			return err
+		}
+
+		_, err = f.parseDirents(data[split:], fn)
+
+		return err
+	}
+
+	r, err := f.content(ino)
+	if err != nil {
+		return err
+	}
+
+	block := make([]byte, f.bs)
+
+	for off := int64(0); off < int64(ino.Size); off += f.bs {
+		buf := block
+		if rest := int64(ino.Size) - off; rest < f.bs {
+			buf = block[:rest]
+		}
+
+		if _, err := r.ReadAt(buf, off); err != nil {
+			return err
+		}
+
+		more, err := f.parseDirents(buf, fn)
+		if err != nil || !more {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f *FS) parseDirents(buf []byte, fn func(num uint32, name string, ftype uint8) bool) (bool, error) {
+	le := binary.LittleEndian
+	filetype := f.sb.FeatureIncompat&EXT2_FEATURE_INCOMPAT_FILETYPE != 0
+
+	for off := 0; off+8 <= len(buf); {
+		num := le.Uint32(buf[off:])
+		recLen := int(le.Uint16(buf[off+4:]))
+		nameLen := int(buf[off+6])
+		ftype := buf[off+7]
+
+		if !filetype {
+			nameLen |= int(ftype) << 8
+			ftype = 0
+		}
+
+		/* 64k blocks store a whole-block rec_len as 0 or 65535 */
+		if recLen == 0 || recLen == 65535 { // nolint:gomnd
+			recLen = len(buf) - off
+		}
+
+		if recLen < 8 || off+recLen > len(buf) || 8+nameLen > recLen { // nolint:gomnd
+			return false, fmt.Errorf("bad directory entry at %d: %w", off, goblkid.ErrCorrupt)
+		}
+
+		if num != 0 && !fn(num, string(buf[off+8:off+8+nameLen]), ftype) {
+			return false, nil
+		}
+
+		off += recLen
+	}
+
+	return true, nil
+}
+
+/* readLink reads a fast symlink from i_block or a slow one from its data */
+func (f *FS) readLink(ino *inode) (string, error) {
+	if ino.Size > uint64(f.bs)*16 { // nolint:gomnd
+		return "", fmt.Errorf("symlink %d too long: %w", ino.num, goblkid.ErrCorrupt)
+	}
+
+	if ino.Flags&(EXT4_EXTENTS_FL|EXT4_INLINE_DATA_FL) == 0 && ino.Size < uint64(len(ino.Block)) {
+		return string(ino.Block[:ino.Size]), nil
+	}
+
+	r, err := f.content(ino)
+	if err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, ino.Size)
+	if _, err := r.ReadAt(buf, 0); err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+
+	return string(buf), nil
+}
+
+func (f *FS) readAt(buf []byte, off int64) error {
+	n, err := f.r.ReadAt(buf, off)
+	if n == len(buf) {
+		return nil
+	}
+
+	if err == nil || errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+func (f *FS) stat(name string, ino *inode) *fileInfo {
+	return &fileInfo{name: name, ino: ino}
+}
+
+/* fileInfo implements fs.FileInfo, Sys returns the inode number */
+type fileInfo struct {
+	name string
+	ino  *inode
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return int64(fi.ino.Size) }
+func (fi *fileInfo) Mode() fs.FileMode  { return fi.ino.fileMode() }
+func (fi *fileInfo) ModTime() time.Time { return fi.ino.Mtime }
+func (fi *fileInfo) IsDir() bool        { return fi.ino.isDir() }
+func (fi *fileInfo) Sys() interface{}   { return fi.ino.num }
+
+/* file is an open regular file, or anything else that is not a directory */
+type file struct {
+	st *fileInfo
+	sr *io.SectionReader
+}
+
+func (fl *file) Stat() (fs.FileInfo, error)                { return fl.st, nil }
+func (fl *file) Read(p []byte) (int, error)                { return fl.sr.Read(p) }
+func (fl *file) ReadAt(p []byte, off int64) (int, error)   { return fl.sr.ReadAt(p, off) }
+func (fl *file) Seek(off int64, whence int) (int64, error) { return fl.sr.Seek(off, whence) }
+func (fl *file) Close() error                              { return nil }
+
+/* dir is an open directory, its entries are read on the first ReadDir */
+type dir struct {
+	f       *FS
+	ino     *inode
+	st      *fileInfo
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) { return d.st, nil }
+func (d *dir) Close() error               { return nil }
+
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.st.name, Err: errors.New("is a directory")}
+}
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		d.read = true
+
+		err := d.f.dirents(d.ino, func(num uint32, name string, ftype uint8) bool {
+			if name != "." && name != ".." {
+				d.entries = append(d.entries, &dirEntry{f: d.f, num: num, name: name, ftype: ftype})
+			}
+
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		sort.Slice(d.entries, func(i, j int) bool { return d.entries[i].Name() < d.entries[j].Name() })
+	}
+
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+
+		return entries, nil
+	}
+
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+
+	return entries, nil
+}
+
+/* dirEntry reads its inode only when Info or, without filetype, Type needs it */
+type dirEntry struct {
+	f     *FS
+	num   uint32
+	name  string
+	ftype uint8
+}
+
+func (e *dirEntry) Name() string { return e.name }
+func (e *dirEntry) IsDir() bool  { return e.Type().IsDir() }
+
+func (e *dirEntry) Type() fs.FileMode {
+	switch e.ftype {
+	case 1:
+		return 0
+	case 2: // nolint:gomnd
+		return fs.ModeDir
+	case 3: // nolint:gomnd
+		return fs.ModeDevice | fs.ModeCharDevice
+	case 4: // nolint:gomnd
+		return fs.ModeDevice
+	case 5: // nolint:gomnd
+		return fs.ModeNamedPipe
+	case 6: // nolint:gomnd
+		return fs.ModeSocket
+	case 7: // nolint:gomnd
+		return fs.ModeSymlink
+	}
+
+	info, err := e.Info()
+	if err != nil {
+		return 0
+	}
+
+	return info.Mode().Type()
+}
+
+func (e *dirEntry) Info() (fs.FileInfo, error) {
+	ino, err := e.f.readInode(e.num)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.f.stat(e.name, ino), nil
+}
@@ -0,0 +1,195 @@
+package ext
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/* fixtureTree fills dir with the files every fixture image is built from */
+func fixtureTree(t *testing.T, dir string) map[string][]byte {
+	t.Helper()
+
+	rnd := rand.New(rand.NewSource(1)) // nolint:gosec
+	big := make([]byte, 300<<10)
+	rnd.Read(big)
+
+	files := map[string][]byte{
+		"etc/machine-id":       []byte("5ea7c8ac97a94be48fb99debf85bbb6a\n"),
+		"boot/grub/grub.cfg":   []byte("set default=0\nset timeout=5\n"),
+		"boot/vmlinuz-5.10":    big,
+		"tiny":                 []byte("x"),
+		"empty":                {},
+		"var/lib/sparse":       append(make([]byte, 200<<10), []byte("end")...),
+		"usr/share/long/name":  bytes.Repeat([]byte("long "), 100),
+		"var/many/placeholder": []byte("keep"),
+	}
+
+	for i := 0; i < 400; i++ {
+		files[fmt.Sprintf("var/many/file-with-a-longish-name-%03d", i)] = []byte(fmt.Sprint(i))
+	}
+
+	for name, data := range files {
+		p := filepath.Join(dir, name)
+		assert.Nil(t, os.MkdirAll(filepath.Dir(p), 0755))
+		assert.Nil(t, os.WriteFile(p, data, 0644))
+	}
+
+	/* punch the hole back into the sparse file */
+	sparse := filepath.Join(dir, "var/lib/sparse")
+	assert.Nil(t, os.WriteFile(sparse, nil, 0644))
+	fi, err := os.OpenFile(sparse, os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = fi.WriteAt([]byte("end"), 200<<10)
+	assert.Nil(t, err)
+	fi.Close()
+
+	/* a byte every 8K leaves more extents than the inode holds, so the tree grows index nodes */
+	frag := make([]byte, 63*8<<10+1)
+	fi, err = os.Create(filepath.Join(dir, "var/lib/fragmented"))
+	assert.Nil(t, err)
+
+	for i := 0; i < 64; i++ {
+		frag[i*8<<10] = byte(i + 1)
+		_, err = fi.WriteAt(frag[i*8<<10:i*8<<10+1], int64(i*8<<10))
+		assert.Nil(t, err)
+	}
+
+	fi.Close()
+
+	files["var/lib/fragmented"] = frag
+
+	target := "usr/share/long/" + strings.Repeat("./", 30) + "name"
+	links := map[string]string{
+		"etc/os-release":  "../usr/lib/os-release",
+		"boot/vmlinuz":    "vmlinuz-5.10",
+		"abs":             "/boot/grub",
+		"slow":            target,
+		"broken/dangling": "nowhere",
+	}
+
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "usr/lib"), 0755))
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "broken"), 0755))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "usr/lib/os-release"), []byte("ID=test\n"), 0644))
+	files["usr/lib/os-release"] = []byte("ID=test\n")
+
+	for name, target := range links {
+		assert.Nil(t, os.Symlink(target, filepath.Join(dir, name)))
+	}
+
+	return files
+}
+
+/* fixture builds an image with mkfs from the fixture tree, skipping without e2fsprogs */
+func fixture(t *testing.T, mkfs string, args ...string) (*os.File, map[string][]byte) {
+	t.Helper()
+
+	if _, err := exec.LookPath(mkfs); err != nil {
+		t.Skipf("%s not installed", mkfs)
+	}
+
+	dir := t.TempDir()
+	files := fixtureTree(t, filepath.Join(dir, "root"))
+	img := filepath.Join(dir, "fs.img")
+
+	args = append([]string{"-q", "-F", "-d", filepath.Join(dir, "root")}, args...)
+	args = append(args, img, "16M")
+
+	out, err := exec.Command(mkfs, args...).CombinedOutput()
+	if err != nil {
+		t.Skipf("%s %v: %v: %s", mkfs, args, err, out)
+	}
+
+	/* rebuild large directories as htrees */
+	if out, err := exec.Command("e2fsck", "-fyD", img).CombinedOutput(); err != nil && !strings.Contains(string(out), "MODIFIED") {
+		t.Fatalf("e2fsck: %v: %s", err, out)
+	}
+
+	fi, err := os.Open(img)
+	assert.Nil(t, err)
+	t.Cleanup(func() { fi.Close() })
+
+	return fi, files
+}
+
+func TestOpenFS(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		mkfs string
+		args []string
+	}{
+		{"ext2-1k-blockmap", "mkfs.ext2", []string{"-b", "1024"}},
+		{"ext3", "mkfs.ext3", nil},
+		{"ext4", "mkfs.ext4", []string{"-O", "64bit"}},
+		{"ext4-inline", "mkfs.ext4", []string{"-O", "inline_data"}},
+		{"ext4-meta-bg", "mkfs.ext4", []string{"-b", "1024", "-O", "meta_bg,^resize_inode"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			img, files := fixture(t, tc.mkfs, tc.args...)
+
+			fsys, err := Open(img)
+			if !assert.Nil(t, err) {
+				return
+			}
+
+			for name, data := range files {
+				got, err := fsys.ReadFile(name)
+				assert.Nil(t, err, name)
+				assert.True(t, bytes.Equal(data, got), name)
+			}
+
+			frag, err := fsys.lookup("open", "var/lib/fragmented", true)
+			assert.Nil(t, err)
+
+			if frag.Flags&EXT4_EXTENTS_FL != 0 {
+				assert.Greater(t, binary.LittleEndian.Uint16(frag.Block[6:]), uint16(0), "extent tree depth")
+			}
+
+			id, err := fs.ReadFile(fsys, "etc/os-release")
+			assert.Nil(t, err)
+			assert.Equal(t, "ID=test\n", string(id))
+
+			cfg, err := fs.ReadFile(fsys, "abs/grub.cfg")
+			assert.Nil(t, err)
+			assert.Equal(t, files["boot/grub/grub.cfg"], cfg)
+
+			link, err := fsys.ReadLink("slow")
+			assert.Nil(t, err)
+			assert.True(t, len(link) > 60)
+
+			st, err := fsys.Lstat("boot/vmlinuz")
+			assert.Nil(t, err)
+			assert.Equal(t, fs.ModeSymlink, st.Mode().Type())
+
+			_, err = fsys.Open("broken/dangling")
+			assert.True(t, errors.Is(err, fs.ErrNotExist))
+
+			entries, err := fsys.ReadDir("var/many")
+			assert.Nil(t, err)
+			assert.Equal(t, 401, len(entries))
+
+			/* TestFS expects every listed name to open, dangling links included */
+			for sub, expected := range map[string]string{
+				"etc":  "machine-id",
+				"boot": "grub/grub.cfg",
+				"var":  "many/placeholder",
+				"usr":  "lib/os-release",
+			} {
+				subfs, err := fs.Sub(fsys, sub)
+				assert.Nil(t, err)
+				assert.Nil(t, fstest.TestFS(subfs, expected), sub)
+			}
+		})
+	}
+}
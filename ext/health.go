@@ -0,0 +1,109 @@
+package ext
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/isi-lincoln/goblkid"
+)
+
+// Health is what the superblock says about the state of the filesystem,
+// the same signals e2fsck -p uses to decide whether a check is needed
+type Health struct {
+	// Clean is set when the filesystem was cleanly unmounted
+	Clean bool
+	// Errors is set when the kernel recorded errors
+	Errors bool
+	// NeedsRecovery is set when the journal must be replayed
+	NeedsRecovery bool
+	// CheckOverdue is set when the mount count or check interval ran out
+	CheckOverdue bool
+	// OrphansPending is set when orphan inodes wait to be cleaned up
+	OrphansPending bool
+
+	// Reasons explains every unhealthy signal
+	Reasons []string
+}
+
+// NeedsFsck reports whether the filesystem should be checked before mounting
+func (h *Health) NeedsFsck() bool {
+	return !h.Clean || h.Errors || h.CheckOverdue
+}
+
+// String summarizes the health in one line
+func (h *Health) String() string {
+	if len(h.Reasons) == 0 {
+		return "clean"
+	}
+
+	s := h.Reasons[0]
+	for _, r := range h.Reasons[1:] {
+		s += ", " + r
+	}
+
+	return s
+}
+
+// ReadHealth reads the superblock of the ext filesystem behind info and
+// evaluates its health as of now
+func ReadHealth(info *goblkid.ProbeInfo, now time.Time) (*Health, error) {
+	ok, err := ExtMagic[0].Match(info)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("no ext superblock magic")
+	}
+
+	sb, err := ext2GetSuper(info)
+	if err != nil {
+		return nil, err
+	}
+
+	return newHealth(sb, now), nil
+}
+
+func newHealth(sb *ext2SuperBlock, now time.Time) *Health {
+	h := &Health{
+		Clean:          sb.State&EXT2_VALID_FS != 0,
+		Errors:         sb.State&EXT2_ERROR_FS != 0 || sb.ErrorCount != 0,
+		NeedsRecovery:  sb.FeatureIncompat&EXT3_FEATURE_INCOMPAT_RECOVER != 0,
+		OrphansPending: sb.State&EXT3_ORPHAN_FS != 0 || sb.LastOrphan != 0,
+	}
+
+	if !h.Clean {
+		h.Reasons = append(h.Reasons, "not cleanly unmounted")
+	}
+
+	switch {
+	case sb.ErrorCount != 0:
+		h.Reasons = append(h.Reasons, fmt.Sprintf("%d errors recorded", sb.ErrorCount))
+	case h.Errors:
+		h.Reasons = append(h.Reasons, "errors recorded")
+	}
+
+	if h.NeedsRecovery {
+		h.Reasons = append(h.Reasons, "journal needs recovery")
+	}
+
+	if h.OrphansPending {
+		h.Reasons = append(h.Reasons, "orphan inodes pending")
+	}
+
+	/* a negative maximum disables the mount count check, as does 0 */
+	if sb.MaxMntCount > 0 && int(sb.MntCount) >= int(sb.MaxMntCount) {
+		h.CheckOverdue = true
+		h.Reasons = append(h.Reasons, fmt.Sprintf("mounted %d times without being checked", sb.MntCount))
+	}
+
+	interval := time.Duration(sb.Checkinterval) * time.Second
+	last := timestamp(sb.Lastcheck, sb.LastcheckHi)
+
+	if interval != 0 && !now.Before(last.Add(interval)) {
+		h.CheckOverdue = true
+		h.Reasons = append(h.Reasons, fmt.Sprintf("not checked since %s", last.Format(time.RFC3339)))
+	}
+
+	return h
+}
@@ -0,0 +1,42 @@
+package ext
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/isi-lincoln/goblkid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealth(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	sb := &ext2SuperBlock{
+		State:         EXT2_VALID_FS,
+		MaxMntCount:   -1,
+		Lastcheck:     uint32(now.Add(-time.Hour).Unix()),
+		Checkinterval: uint32((24 * time.Hour).Seconds()),
+	}
+	img := testImage(t, sb)
+
+	h, err := ReadHealth(&goblkid.ProbeInfo{DeviceReader: bytes.NewReader(img)}, now)
+	assert.Nil(t, err)
+	assert.True(t, h.Clean)
+	assert.False(t, h.NeedsFsck())
+	assert.Equal(t, "clean", h.String())
+
+	sb.State = EXT2_ERROR_FS
+	sb.FeatureIncompat = EXT3_FEATURE_INCOMPAT_RECOVER
+	sb.LastOrphan = 12
+	sb.MntCount, sb.MaxMntCount = 20, 20
+
+	h = newHealth(sb, now.Add(48*time.Hour))
+	assert.False(t, h.Clean)
+	assert.True(t, h.Errors)
+	assert.True(t, h.NeedsRecovery)
+	assert.True(t, h.OrphansPending)
+	assert.True(t, h.CheckOverdue)
+	assert.True(t, h.NeedsFsck())
+	assert.Equal(t, 6, len(h.Reasons))
+}
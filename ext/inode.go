@@ -0,0 +1,420 @@
+package ext
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+
+	"github.com/isi-lincoln/goblkid"
+)
+
+// inode constants
+const (
+	EXT2_ROOT_INO         = 2          // nolint:golint,stylecheck
+	EXT2_N_BLOCKS         = 15         // nolint:golint,stylecheck
+	EXT2_NDIR_BLOCKS      = 12         // nolint:golint,stylecheck
+	EXT4_ENCRYPT_FL       = 0x800      // nolint:golint,stylecheck
+	EXT4_INDEX_FL         = 0x1000     // nolint:golint,stylecheck
+	EXT4_EXTENTS_FL       = 0x80000    // nolint:golint,stylecheck
+	EXT4_INLINE_DATA_FL   = 0x10000000 // nolint:golint,stylecheck
+	EXT4_EXT_MAGIC        = 0xf30a     // nolint:golint,stylecheck
+	EXT4_EXT_INIT_MAX_LEN = 1 << 15    // nolint:golint,stylecheck
+	EXT4_XATTR_MAGIC      = 0xea020000 // nolint:golint,stylecheck
+	EXT4_XATTR_INDEX_SYS  = 7          // nolint:golint,stylecheck
+
+	EXT4_FEATURE_INCOMPAT_LARGEDIR    = 0x4000  // nolint:golint,stylecheck
+	EXT4_FEATURE_INCOMPAT_INLINE_DATA = 0x8000  // nolint:golint,stylecheck
+	EXT4_FEATURE_INCOMPAT_ENCRYPT     = 0x10000 // nolint:golint,stylecheck
+)
+
+// i_mode file types
+const (
+	sIFMT   = 0xf000
+	sIFSOCK = 0xc000
+	sIFLNK  = 0xa000
+	sIFREG  = 0x8000
+	sIFBLK  = 0x6000
+	sIFDIR  = 0x4000
+	sIFCHR  = 0x2000
+	sIFIFO  = 0x1000
+)
+
+/* the fields of struct ext2_inode and the extra part of ext4_inode used here */
+type inode struct {
+	num uint32
+
+	Mode       uint16
+	Size       uint64
+	Mtime      time.Time
+	LinksCount uint16
+	Flags      uint32
+	Block      [EXT2_N_BLOCKS * 4]byte
+
+	/* in-inode extended attributes, inline data lives in system.data */
+	xattrs []byte
+}
+
+/* extent is a run of blocks flattened out of an extent tree */
+type extent struct {
+	logical   uint64
+	length    uint64
+	physical  uint64
+	unwritten bool
+}
+
+func (ino *inode) isDir() bool     { return ino.Mode&sIFMT == sIFDIR }
+func (ino *inode) isSymlink() bool { return ino.Mode&sIFMT == sIFLNK }
+
+func (ino *inode) fileMode() fs.FileMode {
+	mode := fs.FileMode(ino.Mode & 0777) // nolint:gomnd
+
+	switch ino.Mode & sIFMT {
+	case sIFDIR:
+		mode |= fs.ModeDir
+	case sIFLNK:
+		mode |= fs.ModeSymlink
+	case sIFCHR:
+		mode |= fs.ModeDevice | fs.ModeCharDevice
+	case sIFBLK:
+		mode |= fs.ModeDevice
+	case sIFIFO:
+		mode |= fs.ModeNamedPipe
+	case sIFSOCK:
+		mode |= fs.ModeSocket
+	}
+
+	if ino.Mode&0x800 != 0 { // nolint:gomnd
+		mode |= fs.ModeSetuid
+	}
+
+	if ino.Mode&0x400 != 0 { // nolint:gomnd
+		mode |= fs.ModeSetgid
+	}
+
+	if ino.Mode&0x200 != 0 { // nolint:gomnd
+		mode |= fs.ModeSticky
+	}
+
+	return mode
+}
+
+/* readInode finds inode num in the inode table of its group */
+func (f *FS) readInode(num uint32) (*inode, error) {
+	if num == 0 || num > f.sb.InodesCount {
+		return nil, fmt.Errorf("inode %d out of range: %w", num, goblkid.ErrCorrupt)
+	}
+
+	group := (num - 1) / f.sb.InodesPerGroup
+	index := (num - 1) % f.sb.InodesPerGroup
+
+	table, err := f.inodeTable(group)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, f.inodeSize)
+	if err := f.readAt(buf, int64(table)*f.bs+int64(index)*int64(f.inodeSize)); err != nil {
+		return nil, err
+	}
+
+	le := binary.LittleEndian
+	ino := &inode{
+		num:        num,
+		Mode:       le.Uint16(buf[0x00:]),
+		Size:       uint64(le.Uint32(buf[0x04:])),
+		LinksCount: le.Uint16(buf[0x1a:]),
+		Flags:      le.Uint32(buf[0x20:]),
+	}
+	copy(ino.Block[:], buf[0x28:0x28+len(ino.Block)])
+
+	/* i_size_high was i_dir_acl for directories before large_dir */
+	if !ino.isDir() || f.sb.FeatureIncompat&EXT4_FEATURE_INCOMPAT_LARGEDIR != 0 {
+		ino.Size |= uint64(le.Uint32(buf[0x6c:])) << 32
+	}
+
+	mtime := int64(int32(le.Uint32(buf[0x10:])))
+	extra := 0
+
+	if f.inodeSize > 128 { // nolint:gomnd
+		extra = int(le.Uint16(buf[0x80:]))
+	}
+
+	if extra >= 0x0c && 0x80+extra <= len(buf) { // nolint:gomnd
+		/* the low 2 bits of i_mtime_extra widen the epoch */
+		mtime += int64(le.Uint32(buf[0x88:])&3) << 32 // nolint:gomnd
+	}
+
+	ino.Mtime = time.Unix(mtime, 0).UTC()
+
+	if start := 0x80 + extra; extra != 0 && start+4 <= len(buf) && le.Uint32(buf[start:]) == EXT4_XATTR_MAGIC {
+		ino.xattrs = buf[start+4:]
+	}
+
+	return ino, nil
+}
+
+/* inodeTable reads the inode table location from the group descriptor */
+func (f *FS) inodeTable(group uint32) (uint64, error) {
+	if group >= f.groups {
+		return 0, fmt.Errorf("group %d out of range: %w", group, goblkid.ErrCorrupt)
+	}
+
+	perBlock := uint32(f.bs) / f.descSize
+	off := f.descBlocks[group/perBlock][0]
+
+	buf := make([]byte, f.descSize)
+	if err := f.readAt(buf, off+int64(group%perBlock)*int64(f.descSize)); err != nil {
+		return 0, err
+	}
+
+	table := uint64(binary.LittleEndian.Uint32(buf[0x08:]))
+	if f.descSize >= 64 { // nolint:gomnd
+		table |= uint64(binary.LittleEndian.Uint32(buf[0x28:])) << 32
+	}
+
+	return table, nil
+}
+
+/* inlineData returns the content of an inline data inode */
+func (ino *inode) inlineData() []byte {
+	data := append([]byte{}, ino.Block[:]...)
+	data = append(data, ino.xattr(EXT4_XATTR_INDEX_SYS, "data")...)
+
+	if uint64(len(data)) > ino.Size {
+		data = data[:ino.Size]
+	}
+
+	return data
+}
+
+/* xattr looks up an in-inode extended attribute */
+func (ino *inode) xattr(index uint8, name string) []byte {
+	le := binary.LittleEndian
+	entries := ino.xattrs
+
+	for off := 0; off+16 <= len(entries) && le.Uint32(entries[off:]) != 0; {
+		nameLen := int(entries[off])
+		valueOffs := int(le.Uint16(entries[off+2:]))
+		valueSize := int(le.Uint32(entries[off+8:]))
+
+		if off+16+nameLen > len(entries) {
+			break
+		}
+
+		if entries[off+1] == index && string(entries[off+16:off+16+nameLen]) == name {
+			if valueOffs+valueSize > len(entries) {
+				break
+			}
+
+			return entries[valueOffs : valueOffs+valueSize]
+		}
+
+		off += (16 + nameLen + 3) &^ 3 // nolint:gomnd
+	}
+
+	return nil
+}
+
+/* extents flattens the extent tree rooted in i_block */
+func (f *FS) extents(ino *inode) ([]extent, error) {
+	runs := []extent{}
+
+	err := f.walkExtents(ino.Block[:], 0, &runs)
+	if err != nil {
+		return nil, fmt.Errorf("inode %d: %w", ino.num, err)
+	}
+
+	return runs, nil
+}
+
+func (f *FS) walkExtents(node []byte, level int, runs *[]extent) error {
+	le := binary.LittleEndian
+
+	if len(node) < 12 || le.Uint16(node[0:]) != EXT4_EXT_MAGIC {
+		return fmt.Errorf("bad extent header: %w", goblkid.ErrCorrupt)
+	}
+
+	entries := int(le.Uint16(node[2:]))
+	depth := int(le.Uint16(node[6:]))
+
+	if level > 5 || 12+entries*12 > len(node) { // nolint:gomnd
+		return fmt.Errorf("bad extent node: %w", goblkid.ErrCorrupt)
+	}
+
+	for i := 0; i < entries; i++ {
+		e := node[12+i*12:]
+
+		if depth == 0 {
+			length := uint64(le.Uint16(e[4:]))
+			unwritten := length > EXT4_EXT_INIT_MAX_LEN
+
+			if unwritten {
+				length -= EXT4_EXT_INIT_MAX_LEN
+			}
+
+			*runs = append(*runs, extent{
+				logical:   uint64(le.Uint32(e[0:])),
+				length:    length,
+				physical:  uint64(le.Uint16(e[6:]))<<32 | uint64(le.Uint32(e[8:])),
+				unwritten: unwritten,
+			})
+
+			continue
+		}
+
+		leaf := uint64(le.Uint16(e[8:]))<<32 | uint64(le.Uint32(e[4:]))
+		child := make([]byte, f.bs)
+
+		if err := f.readAt(child, int64(leaf)*f.bs); err != nil {
+			return err
+		}
+
+		if err := f.walkExtents(child, level+1, runs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/* bmap maps a logical block through the legacy direct and indirect blocks */
+func (f *FS) bmap(ino *inode, lblk uint64) (uint64, error) {
+	le := binary.LittleEndian
+	perBlock := uint64(f.bs / 4) // nolint:gomnd
+
+	if lblk < EXT2_NDIR_BLOCKS {
+		return uint64(le.Uint32(ino.Block[lblk*4:])), nil
+	}
+
+	lblk -= EXT2_NDIR_BLOCKS
+	slot, levels := uint64(EXT2_NDIR_BLOCKS), 1
+
+	for span := perBlock; lblk >= span; span *= perBlock {
+		lblk -= span
+		slot++
+		levels++
+
+		if levels > 3 { // nolint:gomnd
+			return 0, fmt.Errorf("block %d beyond triple indirect: %w", lblk, goblkid.ErrCorrupt)
+		}
+	}
+
+	block := uint64(le.Uint32(ino.Block[slot*4:]))
+	buf := make([]byte, 4) // nolint:gomnd
+
+	for l := levels - 1; l >= 0 && block != 0; l-- {
+		span := uint64(1)
+		for i := 0; i < l; i++ {
+			span *= perBlock
+		}
+
+		if err := f.readAt(buf, int64(block)*f.bs+int64(lblk/span*4)); err != nil {
+			return 0, err
+		}
+
+		block = uint64(le.Uint32(buf))
+		lblk %= span
+	}
+
+	return block, nil
+}
+
+/* content returns a reader over the data of ino, holes read as zeros */
+func (f *FS) content(ino *inode) (io.ReaderAt, error) {
+	if ino.Flags&EXT4_ENCRYPT_FL != 0 {
+		return nil, fmt.Errorf("inode %d is encrypted", ino.num)
+	}
+
+	if ino.Flags&EXT4_INLINE_DATA_FL != 0 {
+		return bytes.NewReader(ino.inlineData()), nil
+	}
+
+	d := &data{f: f, ino: ino}
+
+	if ino.Flags&EXT4_EXTENTS_FL != 0 {
+		runs, err := f.extents(ino)
+		if err != nil {
+			return nil, err
+		}
+
+		d.runs = runs
+	}
+
+	return d, nil
+}
+
+/* data reads the blocks of a file through its extents or block map */
+type data struct {
+	f    *FS
+	ino  *inode
+	runs []extent
+}
+
+func (d *data) ReadAt(p []byte, off int64) (int, error) {
+	size := int64(d.ino.Size)
+	if off >= size {
+		return 0, io.EOF
+	}
+
+	n := 0
+
+	for n < len(p) && off < size {
+		lblk := uint64(off / d.f.bs)
+		within := off % d.f.bs
+
+		chunk := int64(len(p) - n)
+		if rest := d.f.bs - within; chunk > rest {
+			chunk = rest
+		}
+
+		if rest := size - off; chunk > rest {
+			chunk = rest
+		}
+
+		phys, err := d.physical(lblk)
+		if err != nil {
+			return n, err
+		}
+
+		buf := p[n : n+int(chunk)]
+
+		if phys == 0 {
+			for i := range buf {
+				buf[i] = 0
+			}
+		} else if err := d.f.readAt(buf, int64(phys)*d.f.bs+within); err != nil {
+			return n, err
+		}
+
+		n += int(chunk)
+		off += chunk
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+/* physical maps lblk to a block on disk, 0 for holes and unwritten extents */
+func (d *data) physical(lblk uint64) (uint64, error) {
+	if d.ino.Flags&EXT4_EXTENTS_FL == 0 {
+		return d.f.bmap(d.ino, lblk)
+	}
+
+	for _, r := range d.runs {
+		if lblk >= r.logical && lblk < r.logical+r.length {
+			if r.unwritten {
+				return 0, nil
+			}
+
+			return r.physical + lblk - r.logical, nil
+		}
+	}
+
+	return 0, nil
+}
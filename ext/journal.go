@@ -0,0 +1,206 @@
+package ext
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/isi-lincoln/goblkid"
+	"github.com/lunixbochs/struc"
+)
+
+// jbd2 constants
+const (
+	JBD2_MAGIC_NUMBER     = 0xc03b3998 // nolint:golint,stylecheck
+	JBD2_DESCRIPTOR_BLOCK = 1          // nolint:golint,stylecheck
+	JBD2_COMMIT_BLOCK     = 2          // nolint:golint,stylecheck
+	JBD2_SUPERBLOCK_V1    = 3          // nolint:golint,stylecheck
+	JBD2_SUPERBLOCK_V2    = 4          // nolint:golint,stylecheck
+	JBD2_REVOKE_BLOCK     = 5          // nolint:golint,stylecheck
+	JBD2_USERS_MAX        = 48         // nolint:golint,stylecheck
+)
+
+/* the jbd2 superblock is big endian, unlike everything else in ext */
+type journalSuperBlock struct {
+	/*000*/ Magic uint32
+	/*004*/ BlockType uint32
+	/*008*/ HSequence uint32
+	/*00c*/ BlockSize uint32
+	/*010*/ MaxLen uint32
+	/*014*/ First uint32
+	/*018*/ Sequence uint32
+	/*01c*/ Start uint32
+	/*020*/ Errno int32
+	/*024*/ FeatureCompat uint32
+	/*028*/ FeatureIncompat uint32
+	/*02c*/ FeatureRoCompat uint32
+	/*030*/ UUID [16]uint8
+	/*040*/ NrUsers uint32
+	/*044*/ DynSuper uint32
+	/*048*/ MaxTransaction uint32
+	/*04c*/ MaxTransData uint32
+	/*050*/ ChecksumType uint8
+	/*051*/ Padding2 [3]uint8
+	/*054*/ NumFcBlocks uint32
+	/*058*/ Head uint32
+	/*05c*/ Padding [40]uint32
+	/*0fc*/ Checksum uint32
+	/*100*/ Users [JBD2_USERS_MAX * 16]uint8
+}
+
+// Journal is the superblock of an external jbd2 journal device
+type Journal struct {
+	BlockType uint32
+	BlockSize uint32
+	MaxLen    uint32 /* in journal blocks */
+	First     uint32
+	Sequence  uint32
+	Start     uint32 /* 0 when the journal is empty */
+	Errno     int32
+
+	UUID  goblkid.UUID
+	Users []goblkid.UUID
+}
+
+// Version is 1 or 2 from the superblock block type
+func (j *Journal) Version() int {
+	if j.BlockType == JBD2_SUPERBLOCK_V1 {
+		return 1
+	}
+
+	return 2 // nolint:gomnd
+}
+
+// ReadJournal reads the jbd2 superblock of the external journal device
+// behind info. It follows the ext superblock, in block 2 for 1k blocks and
+// in block 1 otherwise.
+func ReadJournal(info *goblkid.ProbeInfo) (*Journal, error) {
+	sb, err := ext2GetSuper(info)
+	if err != nil {
+		return nil, err
+	}
+
+	if string(sb.Magic[:]) != ExtMagic[0].Magic || sb.FeatureIncompat&EXT3_FEATURE_INCOMPAT_JOURNAL_DEV == 0 {
+		return nil, fmt.Errorf("not an external journal device")
+	}
+
+	bs := int64(extBlockSize(sb))
+	start := int64(1)
+
+	if bs == minBlockSize {
+		start = 2
+	}
+
+	_, err = info.DeviceReader.Seek(info.Offset+start*bs, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	var jsb journalSuperBlock
+
+	err = struc.UnpackWithOrder(io.LimitReader(info.DeviceReader, 1024), &jsb, binary.BigEndian) // nolint:gomnd
+	if err != nil {
+		return nil, err
+	}
+
+	if jsb.Magic != JBD2_MAGIC_NUMBER {
+		return nil, fmt.Errorf("bad jbd2 magic %08x: %w", jsb.Magic, goblkid.ErrCorrupt)
+	}
+
+	if jsb.BlockType != JBD2_SUPERBLOCK_V1 && jsb.BlockType != JBD2_SUPERBLOCK_V2 {
+		return nil, fmt.Errorf("jbd2 block type %d is not a superblock: %w", jsb.BlockType, goblkid.ErrCorrupt)
+	}
+
+	if jsb.NrUsers > JBD2_USERS_MAX {
+		return nil, fmt.Errorf("jbd2 superblock lists %d users: %w", jsb.NrUsers, goblkid.ErrCorrupt)
+	}
+
+	j := &Journal{
+		BlockType: jsb.BlockType,
+		BlockSize: jsb.BlockSize,
+		MaxLen:    jsb.MaxLen,
+		First:     jsb.First,
+		Sequence:  jsb.Sequence,
+		Start:     jsb.Start,
+		Errno:     jsb.Errno,
+		UUID:      goblkid.NewUUID(goblkid.RFC4122, jsb.UUID[:]),
+		Users:     []goblkid.UUID{},
+	}
+
+	for i := uint32(0); i < jsb.NrUsers; i++ {
+		j.Users = append(j.Users, goblkid.NewUUID(goblkid.RFC4122, jsb.Users[i*16:(i+1)*16]))
+	}
+
+	return j, nil
+}
+
+// JournalLink ties an external journal to the filesystems using it
+type JournalLink struct {
+	Device  string
+	Journal *Journal
+
+	// Filesystems are the devices whose superblock names the journal
+	Filesystems []string
+	// Missing are users in the journal superblock not found among the devices
+	Missing []goblkid.UUID
+}
+
+// InUse reports whether any filesystem still uses the journal
+func (l *JournalLink) InUse() bool {
+	return len(l.Journal.Users) > 0 || len(l.Filesystems) > 0
+}
+
+// LinkJournals matches the external journals among devices with the
+// ext3/ext4 filesystems referencing them through their journal UUID. Every
+// device must have been probed with Chain.
+func LinkJournals(devices map[string]*goblkid.ProbeInfo) ([]JournalLink, error) {
+	names := make([]string, 0, len(devices))
+	for name := range devices {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	links := []JournalLink{}
+
+	for _, name := range names {
+		info := devices[name]
+		if info.ProbeName != JbdName {
+			continue
+		}
+
+		j, err := ReadJournal(info)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+
+		l := JournalLink{Device: name, Journal: j, Filesystems: []string{}, Missing: []goblkid.UUID{}}
+
+		for _, fs := range names {
+			if devices[fs].ProbeName != JbdName && devices[fs].ExtJournal.Equal(j.UUID) {
+				l.Filesystems = append(l.Filesystems, fs)
+			}
+		}
+
+		for _, u := range j.Users {
+			if !hasUser(devices, u) {
+				l.Missing = append(l.Missing, u)
+			}
+		}
+
+		links = append(links, l)
+	}
+
+	return links, nil
+}
+
+func hasUser(devices map[string]*goblkid.ProbeInfo, u goblkid.UUID) bool {
+	for _, info := range devices {
+		if info.ProbeName != JbdName && info.UUID.Equal(u) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,91 @@
+package ext
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/isi-lincoln/goblkid"
+	"github.com/lunixbochs/struc"
+	"github.com/stretchr/testify/assert"
+)
+
+func journalImage(t *testing.T, users ...[16]byte) []byte {
+	t.Helper()
+
+	sb := &ext2SuperBlock{
+		BlocksCount:     16,
+		LogBlockSize:    2,
+		RevLevel:        1,
+		FeatureIncompat: EXT3_FEATURE_INCOMPAT_JOURNAL_DEV,
+	}
+	sb.UUID[0] = 0x11
+	img := testImage(t, sb)
+
+	jsb := &journalSuperBlock{
+		Magic:     JBD2_MAGIC_NUMBER,
+		BlockType: JBD2_SUPERBLOCK_V2,
+		BlockSize: 4096,
+		MaxLen:    16,
+		First:     2,
+		Sequence:  7,
+		NrUsers:   uint32(len(users)),
+	}
+	jsb.UUID[0] = 0x11
+
+	for i, u := range users {
+		copy(jsb.Users[i*16:], u[:])
+	}
+
+	var b bytes.Buffer
+	assert.Nil(t, struc.PackWithOrder(&b, jsb, binary.BigEndian))
+	copy(img[4096:], b.Bytes())
+
+	return img
+}
+
+func TestReadJournal(t *testing.T) {
+	info := &goblkid.ProbeInfo{DeviceReader: bytes.NewReader(journalImage(t, [16]byte{0x22}))}
+
+	ok, err := Chain.Probe(info)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, JbdName, info.ProbeName)
+
+	j, err := ReadJournal(info)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, j.Version())
+	assert.Equal(t, uint32(4096), j.BlockSize)
+	assert.Equal(t, uint32(7), j.Sequence)
+	assert.Equal(t, info.UUID, j.UUID)
+	assert.Equal(t, 1, len(j.Users))
+	assert.Equal(t, byte(0x22), j.Users[0].Bytes()[0])
+
+	img := journalImage(t)
+	img[4096] = 0
+	_, err = ReadJournal(&goblkid.ProbeInfo{DeviceReader: bytes.NewReader(img)})
+	assert.True(t, errors.Is(err, goblkid.ErrCorrupt))
+}
+
+func TestLinkJournals(t *testing.T) {
+	journal := &goblkid.ProbeInfo{DeviceReader: bytes.NewReader(journalImage(t, [16]byte{0x22}, [16]byte{0x33}))}
+	_, err := Chain.Probe(journal)
+	assert.Nil(t, err)
+
+	fs := &goblkid.ProbeInfo{
+		ProbeName:  Ext4Name,
+		UUID:       goblkid.NewUUID(goblkid.RFC4122, []byte{0x22}),
+		ExtJournal: journal.UUID,
+	}
+	other := &goblkid.ProbeInfo{ProbeName: Ext4Name, UUID: goblkid.NewUUID(goblkid.RFC4122, []byte{0x44})}
+
+	links, err := LinkJournals(map[string]*goblkid.ProbeInfo{"sdc": journal, "sda": fs, "sdb": other})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(links))
+	assert.Equal(t, "sdc", links[0].Device)
+	assert.Equal(t, []string{"sda"}, links[0].Filesystems)
+	assert.Equal(t, 1, len(links[0].Missing))
+	assert.Equal(t, byte(0x33), links[0].Missing[0].Bytes()[0])
+	assert.True(t, links[0].InUse())
+}
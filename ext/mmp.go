@@ -0,0 +1,140 @@
+package ext
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/isi-lincoln/goblkid"
+	"github.com/lunixbochs/struc"
+)
+
+// MMP block magic and the sequence numbers with a special meaning
+const (
+	EXT4_MMP_MAGIC     = 0x004D4D50 // nolint:golint,stylecheck
+	EXT4_MMP_SEQ_CLEAN = 0xFF4D4D50 // nolint:golint,stylecheck
+	EXT4_MMP_SEQ_FSCK  = 0xE24D4D50 // nolint:golint,stylecheck
+	EXT4_MMP_SEQ_MAX   = 0xE24D4D4F // nolint:golint,stylecheck
+)
+
+// MMP verdicts
+const (
+	MMPClean = "clean"
+	MMPFsck  = "fsck-running"
+	MMPInUse = "in-use"
+)
+
+type mmpStruct struct {
+	/*000*/ Magic uint32
+	/*004*/ Seq uint32
+	/*008*/ Time uint64
+	/*010*/ NodeName [64]uint8
+	/*050*/ BdevName [32]uint8
+	/*070*/ CheckInterval uint16
+	/*072*/ Pad1 uint16
+	/*074*/ Pad2 [226]uint32
+	/*3fc*/ Checksum uint32
+}
+
+const mmpStructSize = 1024
+
+// MMP is the multi-mount protection block of an ext4 filesystem. A node
+// mounting the filesystem bumps the sequence number every update interval,
+// so a sequence up to EXT4_MMP_SEQ_MAX means a node holds it, or crashed
+// while holding it. Above that only the clean and fsck markers are valid.
+type MMP struct {
+	Block          uint64
+	Sequence       uint32
+	Updated        time.Time
+	NodeName       string
+	DeviceName     string
+	CheckInterval  time.Duration
+	UpdateInterval time.Duration
+
+	State string
+}
+
+// Active reports whether another node may be using the filesystem
+func (m *MMP) Active() bool {
+	return m.State != MMPClean
+}
+
+// ReadMMP reads the MMP block of the ext4 filesystem behind info, returning
+// nil when the mmp feature is not enabled
+func ReadMMP(info *goblkid.ProbeInfo) (*MMP, error) {
+	sb, err := ext2GetSuper(info)
+	if err != nil {
+		return nil, err
+	}
+
+	if string(sb.Magic[:]) != ExtMagic[0].Magic {
+		return nil, fmt.Errorf("no ext superblock magic")
+	}
+
+	if sb.FeatureIncompat&EXT4_FEATURE_INCOMPAT_MMP == 0 {
+		return nil, nil
+	}
+
+	if sb.MmpBlock == 0 || sb.MmpBlock >= extBlocksCount(sb) {
+		return nil, fmt.Errorf("mmp block %d out of range: %w", sb.MmpBlock, goblkid.ErrCorrupt)
+	}
+
+	_, err = info.DeviceReader.Seek(info.Offset+int64(sb.MmpBlock*extBlockSize(sb)), io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, mmpStructSize)
+	if _, err := io.ReadFull(info.DeviceReader, buf); err != nil {
+		return nil, err
+	}
+
+	var raw mmpStruct
+
+	err = struc.UnpackWithOrder(bytes.NewReader(buf), &raw, binary.LittleEndian)
+	if err != nil {
+		return nil, err
+	}
+
+	if raw.Magic != EXT4_MMP_MAGIC {
+		return nil, fmt.Errorf("bad mmp magic %08x: %w", raw.Magic, goblkid.ErrCorrupt)
+	}
+
+	if sb.FeatureRoCompat&EXT4_FEATURE_RO_COMPAT_METADATA_CSUM != 0 {
+		csum := extChecksum(csumSeed(sb), buf[:mmpStructSize-4])
+		if csum != raw.Checksum {
+			return nil, fmt.Errorf("mmp checksum %08x != %08x: %w", csum, raw.Checksum, goblkid.ErrCorrupt)
+		}
+	}
+
+	m := &MMP{
+		Block:          sb.MmpBlock,
+		Sequence:       raw.Seq,
+		NodeName:       cString(raw.NodeName[:]),
+		DeviceName:     cString(raw.BdevName[:]),
+		CheckInterval:  time.Duration(raw.CheckInterval) * time.Second,
+		UpdateInterval: time.Duration(sb.MmpInterval) * time.Second,
+	}
+
+	if raw.Seq > EXT4_MMP_SEQ_MAX && raw.Seq != EXT4_MMP_SEQ_CLEAN && raw.Seq != EXT4_MMP_SEQ_FSCK {
+		return nil, fmt.Errorf("mmp sequence %08x above the maximum %08x: %w",
+			raw.Seq, uint32(EXT4_MMP_SEQ_MAX), goblkid.ErrCorrupt)
+	}
+
+	if raw.Time != 0 {
+		m.Updated = time.Unix(int64(raw.Time), 0).UTC()
+	}
+
+	switch {
+	case raw.Seq == EXT4_MMP_SEQ_CLEAN:
+		m.State = MMPClean
+	case raw.Seq == EXT4_MMP_SEQ_FSCK:
+		m.State = MMPFsck
+	default:
+		m.State = MMPInUse
+	}
+
+	return m, nil
+}
@@ -0,0 +1,73 @@
+package ext
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/isi-lincoln/goblkid"
+	"github.com/lunixbochs/struc"
+	"github.com/stretchr/testify/assert"
+)
+
+func mmpImage(t *testing.T, seq uint32) []byte {
+	t.Helper()
+
+	sb := &ext2SuperBlock{
+		BlocksCount:     64,
+		FeatureIncompat: EXT4_FEATURE_INCOMPAT_MMP,
+		FeatureRoCompat: EXT4_FEATURE_RO_COMPAT_METADATA_CSUM,
+		ChecksumType:    EXT4_CRC32C_CHKSUM,
+		MmpBlock:        40,
+		MmpInterval:     5,
+	}
+	img := testImage(t, sb)
+
+	mmp := &mmpStruct{Magic: EXT4_MMP_MAGIC, Seq: seq, Time: 1700000000, CheckInterval: 5}
+	copy(mmp.NodeName[:], "node-a")
+	copy(mmp.BdevName[:], "/dev/sdb1")
+
+	var b bytes.Buffer
+	assert.Nil(t, struc.PackWithOrder(&b, mmp, binary.LittleEndian))
+
+	buf := b.Bytes()
+	binary.LittleEndian.PutUint32(buf[mmpStructSize-4:], extChecksum(csumSeed(sb), buf[:mmpStructSize-4]))
+	copy(img[40*1024:], buf)
+
+	return img
+}
+
+func TestReadMMP(t *testing.T) {
+	m, err := ReadMMP(&goblkid.ProbeInfo{DeviceReader: bytes.NewReader(mmpImage(t, EXT4_MMP_SEQ_CLEAN))})
+	assert.Nil(t, err)
+	assert.Equal(t, MMPClean, m.State)
+	assert.False(t, m.Active())
+	assert.Equal(t, "node-a", m.NodeName)
+	assert.Equal(t, "/dev/sdb1", m.DeviceName)
+	assert.Equal(t, int64(1700000000), m.Updated.Unix())
+
+	m, err = ReadMMP(&goblkid.ProbeInfo{DeviceReader: bytes.NewReader(mmpImage(t, EXT4_MMP_SEQ_FSCK))})
+	assert.Nil(t, err)
+	assert.Equal(t, MMPFsck, m.State)
+
+	img := mmpImage(t, 42)
+	m, err = ReadMMP(&goblkid.ProbeInfo{DeviceReader: bytes.NewReader(img)})
+	assert.Nil(t, err)
+	assert.Equal(t, MMPInUse, m.State)
+	assert.True(t, m.Active())
+
+	m, err = ReadMMP(&goblkid.ProbeInfo{DeviceReader: bytes.NewReader(mmpImage(t, EXT4_MMP_SEQ_MAX))})
+	assert.Nil(t, err)
+	assert.Equal(t, MMPInUse, m.State)
+
+	/* nothing but the clean and fsck markers lies above the maximum */
+	for _, seq := range []uint32{EXT4_MMP_SEQ_FSCK + 1, EXT4_MMP_SEQ_CLEAN - 1, 0xffffffff} {
+		_, err = ReadMMP(&goblkid.ProbeInfo{DeviceReader: bytes.NewReader(mmpImage(t, seq))})
+		assert.True(t, errors.Is(err, goblkid.ErrCorrupt), "%08x: %v", seq, err)
+	}
+
+	img[40*1024+4]++
+	_, err = ReadMMP(&goblkid.ProbeInfo{DeviceReader: bytes.NewReader(img)})
+	assert.True(t, errors.Is(err, goblkid.ErrCorrupt))
+}
@@ -0,0 +1,259 @@
+package ext
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/isi-lincoln/goblkid"
+	"github.com/lunixbochs/struc"
+)
+
+// Device is what superblock changes are written to
+type Device interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// LabelLength is the size of s_volume_name
+const LabelLength = 16
+
+// RandomUUID returns a random version 4 uuid for SetUUID
+func RandomUUID() (goblkid.UUID, error) {
+	u := goblkid.UUID{Format: goblkid.RFC4122}
+
+	if _, err := rand.Read(u.Raw[:]); err != nil {
+		return u, err
+	}
+
+	u.Raw[6] = u.Raw[6]&0x0f | 0x40 /* version 4 */
+	u.Raw[8] = u.Raw[8]&0x3f | 0x80 /* RFC 4122 variant */
+
+	return u, nil
+}
+
+// SetLabel changes the volume name of the ext filesystem on dev, as
+// tune2fs -L does. With backups set the backup superblocks follow.
+func SetLabel(dev Device, label string, backups bool) error {
+	if len(label) > LabelLength {
+		return fmt.Errorf("label %q longer than %d bytes", label, LabelLength)
+	}
+
+	return updateSupers(dev, backups, func(sb *ext2SuperBlock) {
+		sb.VolumeName = [LabelLength]uint8{}
+		copy(sb.VolumeName[:], label)
+	})
+}
+
+// SetUUID changes the uuid of the ext filesystem on dev, as tune2fs -U
+// does. With metadata_csum every checksum is seeded from the uuid and is
+// not rewritten here: unless metadata_csum_seed is already on, keepSeed
+// must allow turning it on to keep the old seed in s_checksum_seed. That
+// is an incompat feature, so Linux before 4.4 and e2fsprogs before 1.43
+// no longer mount or check the filesystem. The crc16 group descriptor
+// checksums of uninit_bg are rewritten instead. An external journal still
+// lists the old uuid among its users.
+func SetUUID(dev Device, uuid goblkid.UUID, backups, keepSeed bool) error {
+	if uuid.Format != goblkid.RFC4122 || uuid.IsZero() {
+		return fmt.Errorf("ext needs a non-zero rfc4122 uuid, not %#v", uuid)
+	}
+
+	sb, err := readSuper(deviceInfo(dev), int64(ExtMagic[0].SuperblockKbOffset<<10)) // nolint:gomnd
+	if err != nil {
+		return err
+	}
+
+	if string(sb.Magic[:]) != ExtMagic[0].Magic {
+		return fmt.Errorf("no ext superblock magic")
+	}
+
+	if sb.FeatureIncompat&EXT3_FEATURE_INCOMPAT_RECOVER != 0 {
+		return fmt.Errorf("the journal needs recovery, run e2fsck first")
+	}
+
+	if sb.FeatureRoCompat&EXT4_FEATURE_RO_COMPAT_METADATA_CSUM != 0 &&
+		sb.FeatureIncompat&EXT4_FEATURE_INCOMPAT_CSUM_SEED == 0 && !keepSeed {
+		return fmt.Errorf("metadata checksums are seeded from the uuid; " +
+			"keeping them valid needs metadata_csum_seed, which Linux before 4.4 cannot mount")
+	}
+
+	metadataCsum := sb.FeatureRoCompat&EXT4_FEATURE_RO_COMPAT_METADATA_CSUM != 0
+	seed := csumSeed(sb)
+
+	err = updateSupers(dev, backups, func(sb *ext2SuperBlock) {
+		if metadataCsum && sb.FeatureIncompat&EXT4_FEATURE_INCOMPAT_CSUM_SEED == 0 {
+			sb.FeatureIncompat |= EXT4_FEATURE_INCOMPAT_CSUM_SEED
+			sb.ChecksumSeed = seed
+		}
+
+		copy(sb.UUID[:], uuid.Bytes())
+	})
+	if err != nil {
+		return err
+	}
+
+	if metadataCsum || sb.FeatureRoCompat&EXT4_FEATURE_RO_COMPAT_GDT_CSUM == 0 {
+		return nil
+	}
+
+	copy(sb.UUID[:], uuid.Bytes())
+
+	return rewriteDescChecksums(dev, sb, backups)
+}
+
+/*
+ * updateSupers applies change to the primary superblock and, with backups
+ * set, to every backup still belonging to the same filesystem. Each copy
+ * keeps its own s_block_group_nr and gets its checksum recomputed. Like
+ * tune2fs it refuses while MMP shows the filesystem in use.
+ */
+func updateSupers(dev Device, backups bool, change func(sb *ext2SuperBlock)) error {
+	info := deviceInfo(dev)
+	primary := int64(ExtMagic[0].SuperblockKbOffset << 10) // nolint:gomnd
+
+	sb, err := readSuper(info, primary)
+	if err != nil {
+		return err
+	}
+
+	if string(sb.Magic[:]) != ExtMagic[0].Magic {
+		return fmt.Errorf("no ext superblock magic")
+	}
+
+	/* a node holding the filesystem through MMP rewrites the superblock itself */
+	mmp, err := ReadMMP(info)
+	if err != nil {
+		return fmt.Errorf("cannot read mmp block: %w", err)
+	}
+
+	if mmp != nil && mmp.Active() {
+		return fmt.Errorf("filesystem is %s on %s (%s), mmp sequence %08x",
+			mmp.State, mmp.NodeName, mmp.DeviceName, mmp.Sequence)
+	}
+
+	offsets := []int64{primary}
+
+	if backups {
+		for _, g := range backupGroups(sb) {
+			offsets = append(offsets, backupOffset(sb, g))
+		}
+	}
+
+	uuid := sb.UUID
+
+	for _, off := range offsets {
+		copySb, err := readSuper(info, off)
+		if err != nil || string(copySb.Magic[:]) != ExtMagic[0].Magic || copySb.UUID != uuid {
+			/* leave missing or foreign backups alone */
+			continue
+		}
+
+		change(copySb)
+
+		if err := writeSuper(dev, off, copySb); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeSuper(dev Device, off int64, sb *ext2SuperBlock) error {
+	var b bytes.Buffer
+	if err := struc.PackWithOrder(&b, sb, binary.LittleEndian); err != nil {
+		return err
+	}
+
+	buf := b.Bytes()
+	if sb.FeatureRoCompat&EXT4_FEATURE_RO_COMPAT_METADATA_CSUM != 0 {
+		binary.LittleEndian.PutUint32(buf[ext2SuperBlockSize-4:], superChecksum(buf))
+	}
+
+	return writeAll(dev, buf, off)
+}
+
+/* rewriteDescChecksums recomputes the uninit_bg checksum of every group descriptor */
+func rewriteDescChecksums(dev Device, sb *ext2SuperBlock, backups bool) error {
+	bs := int64(extBlockSize(sb))
+	size := descSize(sb)
+	perBlock := uint32(bs) / size
+	count := groupCount(sb)
+	block := make([]byte, bs)
+
+	for i, offsets := range descBlocks(sb) {
+		if _, err := dev.ReadAt(block, offsets[0]); err != nil {
+			return err
+		}
+
+		for j := uint32(0); j < perBlock; j++ {
+			group := uint32(i)*perBlock + j
+			if group >= count {
+				break
+			}
+
+			desc := block[j*size : (j+1)*size]
+			binary.LittleEndian.PutUint16(desc[0x1e:], descChecksum(sb, group, desc))
+		}
+
+		if !backups {
+			offsets = offsets[:1]
+		}
+
+		for _, off := range offsets {
+			if err := writeAll(dev, block, off); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+/* descChecksum is crc16 of the uuid, the group number and the descriptor without bg_checksum */
+func descChecksum(sb *ext2SuperBlock, group uint32, desc []byte) uint16 {
+	var nr [4]byte
+
+	binary.LittleEndian.PutUint32(nr[:], group)
+
+	crc := crc16(0xffff, sb.UUID[:])
+	crc = crc16(crc, nr[:])
+	crc = crc16(crc, desc[:0x1e])
+
+	return crc16(crc, desc[0x20:])
+}
+
+/* crc16 as lib/ext2fs/crc16.c, the reflected 0x8005 polynomial */
+func crc16(crc uint16, buf []byte) uint16 {
+	for _, b := range buf {
+		crc ^= uint16(b)
+
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xa001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+
+	return crc
+}
+
+func deviceInfo(dev Device) *goblkid.ProbeInfo {
+	return &goblkid.ProbeInfo{DeviceReader: io.NewSectionReader(dev, 0, 1<<63-1)}
+}
+
+func writeAll(dev Device, buf []byte, off int64) error {
+	n, err := dev.WriteAt(buf, off)
+	if err != nil {
+		return err
+	}
+
+	if n != len(buf) {
+		return fmt.Errorf("write != length: %d != %d", n, len(buf))
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package ext
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/isi-lincoln/goblkid"
+	"github.com/stretchr/testify/assert"
+)
+
+type memDevice []byte
+
+func (m memDevice) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(m)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, m[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (m memDevice) WriteAt(p []byte, off int64) (int, error) {
+	if off+int64(len(p)) > int64(len(m)) {
+		return 0, io.ErrShortWrite
+	}
+
+	return copy(m[off:], p), nil
+}
+
+func TestSetLabel(t *testing.T) {
+	img, sb := backupImage(t)
+	dev := memDevice(img)
+
+	assert.NotNil(t, SetLabel(dev, "a label far too long", false))
+	assert.Nil(t, SetLabel(dev, "data", false))
+
+	d, err := ReadDetails(&goblkid.ProbeInfo{DeviceReader: bytes.NewReader(img)})
+	assert.Nil(t, err)
+	assert.Equal(t, "data", d.Label)
+
+	backup, err := readSuper(deviceInfo(dev), backupOffset(sb, 1))
+	assert.Nil(t, err)
+	assert.Equal(t, "", cString(backup.VolumeName[:]))
+
+	assert.Nil(t, SetLabel(dev, "scratch", true))
+
+	for _, g := range []uint32{1, 3} {
+		backup, err = readSuper(deviceInfo(dev), backupOffset(sb, g))
+		assert.Nil(t, err)
+		assert.Equal(t, "scratch", cString(backup.VolumeName[:]))
+		assert.Equal(t, uint16(g), backup.BlockGroupNr)
+	}
+}
+
+func TestSetUUID(t *testing.T) {
+	img, sb := backupImage(t)
+	dev := memDevice(img)
+	seed := csumSeed(sb)
+
+	u, err := RandomUUID()
+	assert.Nil(t, err)
+	assert.Equal(t, byte(0x40), u.Bytes()[6]&0xf0)
+
+	assert.NotNil(t, SetUUID(dev, goblkid.UUID{}, false, true))
+
+	/* metadata_csum_seed locks out older kernels, so it takes an opt-in */
+	assert.NotNil(t, SetUUID(dev, u, true, false))
+	assert.Equal(t, sb.UUID, readPrimary(t, dev).UUID)
+
+	assert.Nil(t, SetUUID(dev, u, true, true))
+
+	r, err := FindBackups(&goblkid.ProbeInfo{DeviceReader: bytes.NewReader(img)})
+	assert.Nil(t, err)
+	assert.Equal(t, PrimaryOK, r.Primary)
+	assert.Equal(t, u.String(), r.UUID.String())
+	assert.NotNil(t, r.Usable())
+
+	/* metadata checksums keep their seed */
+	primary := readPrimary(t, dev)
+	assert.NotZero(t, primary.FeatureIncompat&EXT4_FEATURE_INCOMPAT_CSUM_SEED)
+	assert.Equal(t, seed, csumSeed(primary))
+
+	/* once the seed is kept, later changes need no opt-in */
+	u, err = RandomUUID()
+	assert.Nil(t, err)
+	assert.Nil(t, SetUUID(dev, u, true, false))
+	assert.Equal(t, seed, csumSeed(readPrimary(t, dev)))
+}
+
+func TestSetInUse(t *testing.T) {
+	u, err := RandomUUID()
+	assert.Nil(t, err)
+
+	dev := memDevice(mmpImage(t, EXT4_MMP_SEQ_CLEAN))
+	assert.Nil(t, SetLabel(dev, "free", false))
+
+	dev = memDevice(mmpImage(t, 42))
+	assert.NotNil(t, SetLabel(dev, "held", false))
+	assert.NotNil(t, SetUUID(dev, u, false, true))
+	assert.Equal(t, "", cString(readPrimary(t, dev).VolumeName[:]))
+}
+
+func readPrimary(t *testing.T, dev memDevice) *ext2SuperBlock {
+	t.Helper()
+
+	sb, err := ext2GetSuper(deviceInfo(dev))
+	assert.Nil(t, err)
+
+	return sb
+}
+
+func TestCRC16(t *testing.T) {
+	/* CRC-16/MODBUS check value */
+	assert.Equal(t, uint16(0x4b37), crc16(0xffff, []byte("123456789")))
+}
@@ -0,0 +1,92 @@
+package ext
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/isi-lincoln/goblkid"
+	"github.com/lunixbochs/struc"
+	"github.com/stretchr/testify/assert"
+)
+
+/* packSuper packs sb with its magic set and the checksum fixed up */
+func packSuper(t *testing.T, sb *ext2SuperBlock) []byte {
+	t.Helper()
+
+	copy(sb.Magic[:], ExtMagic[0].Magic)
+
+	var b bytes.Buffer
+	assert.Nil(t, struc.PackWithOrder(&b, sb, binary.LittleEndian))
+
+	buf := b.Bytes()
+	if sb.FeatureRoCompat&EXT4_FEATURE_RO_COMPAT_METADATA_CSUM != 0 {
+		binary.LittleEndian.PutUint32(buf[ext2SuperBlockSize-4:], superChecksum(buf))
+	}
+
+	return buf
+}
+
+/* testImage packs sb at 1024 of a 64k image */
+func testImage(t *testing.T, sb *ext2SuperBlock) []byte {
+	t.Helper()
+
+	img := make([]byte, 64<<10)
+	copy(img[1024:], packSuper(t, sb))
+
+	return img
+}
+
+func TestSuperChecksum(t *testing.T) {
+	sb := &ext2SuperBlock{
+		FeatureCompat:   EXT3_FEATURE_COMPAT_HAS_JOURNAL,
+		FeatureIncompat: EXT4_FEATURE_INCOMPAT_EXTENTS,
+		FeatureRoCompat: EXT4_FEATURE_RO_COMPAT_METADATA_CSUM,
+		ChecksumType:    EXT4_CRC32C_CHKSUM,
+	}
+	img := testImage(t, sb)
+
+	info := &goblkid.ProbeInfo{DeviceReader: bytes.NewReader(img)}
+	ok, err := Chain.Probe(info)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, Ext4Name, info.ProbeName)
+
+	img[1024+0x78] = 'x'
+	info = &goblkid.ProbeInfo{DeviceReader: bytes.NewReader(img)}
+	ok, err = Chain.Probe(info)
+	assert.False(t, ok)
+	assert.True(t, errors.Is(err, goblkid.ErrCorrupt))
+}
+
+func TestSizes(t *testing.T) {
+	sb := &ext2SuperBlock{
+		FeatureCompat:   EXT3_FEATURE_COMPAT_HAS_JOURNAL,
+		FeatureIncompat: EXT4_FEATURE_INCOMPAT_EXTENTS,
+		LogBlockSize:    2,
+		BlocksCount:     0x10,
+		BlocksCountHi:   1,
+		FreeBlocksCount: 0x8,
+		FreeBlocksHi:    1,
+		FreeInodesCount: 100,
+	}
+
+	info := &goblkid.ProbeInfo{DeviceReader: bytes.NewReader(testImage(t, sb))}
+	_, err := Chain.Probe(info)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4096), info.FSBlockSize)
+	assert.Equal(t, uint64(0x10), info.FSLastBlock, "hi halves only count with 64bit")
+	assert.Equal(t, uint64(0x10*4096), info.FSSize)
+	assert.Equal(t, uint64(0x8), info.FreeBlocks)
+	assert.Equal(t, uint64(100), info.FreeInodes)
+
+	sb.FeatureIncompat |= EXT4_FEATURE_INCOMPAT_64BIT
+
+	info = &goblkid.ProbeInfo{DeviceReader: bytes.NewReader(testImage(t, sb))}
+	_, err = Chain.Probe(info)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1<<32|0x10), info.FSLastBlock)
+	assert.Equal(t, uint64((1<<32|0x10)*4096), info.FSSize)
+	assert.Equal(t, uint64(1<<32|0x8), info.FreeBlocks)
+}
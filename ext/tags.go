@@ -0,0 +1,110 @@
+package ext
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/isi-lincoln/goblkid"
+)
+
+// Tags the ext probers set for features a security audit cares about
+const (
+	TagEncrypt       = "EXT_ENCRYPT"
+	TagEncryptAlgos  = "EXT_ENCRYPT_ALGOS"
+	TagEncryptPwSalt = "EXT_ENCRYPT_PW_SALT"
+	TagCasefold      = "EXT_CASEFOLD"
+	TagEncoding      = "EXT_ENCODING"
+	TagEncodingFlags = "EXT_ENCODING_FLAGS"
+	TagVerity        = "EXT_VERITY"
+	TagQuota         = "EXT_QUOTA"
+	TagQuotaUsrInode = "EXT_QUOTA_USR_INODE"
+	TagQuotaGrpInode = "EXT_QUOTA_GRP_INODE"
+	TagQuotaPrjInode = "EXT_QUOTA_PRJ_INODE"
+	TagProject       = "EXT_PROJECT"
+)
+
+// casefold encodings
+const (
+	EXT4_ENC_UTF8_12_1      = 1 // nolint:golint,stylecheck
+	EXT4_ENC_STRICT_MODE_FL = 1 // nolint:golint,stylecheck
+)
+
+/* extFeatureTags reports encryption, casefolding, verity, quota and project state */
+func extFeatureTags(info *goblkid.ProbeInfo, sb *ext2SuperBlock) {
+	if sb.FeatureIncompat&EXT4_FEATURE_INCOMPAT_ENCRYPT != 0 {
+		algos := []string{}
+
+		for _, mode := range sb.EncryptAlgos {
+			if mode != 0 {
+				algos = append(algos, encryptionMode(mode))
+			}
+		}
+
+		info.SetTag(TagEncrypt, "1")
+		info.SetTag(TagEncryptAlgos, strings.Join(algos, ","))
+		info.SetTag(TagEncryptPwSalt, boolTag(sb.EncryptPwSalt != [16]uint8{}))
+	}
+
+	if sb.FeatureIncompat&EXT4_FEATURE_INCOMPAT_CASEFOLD != 0 {
+		info.SetTag(TagCasefold, "1")
+		info.SetTag(TagEncoding, encodingName(sb.Encoding))
+
+		if sb.EncodingFlags&EXT4_ENC_STRICT_MODE_FL != 0 {
+			info.SetTag(TagEncodingFlags, "strict")
+		}
+	}
+
+	if sb.FeatureRoCompat&EXT4_FEATURE_RO_COMPAT_VERITY != 0 {
+		info.SetTag(TagVerity, "1")
+	}
+
+	if sb.FeatureRoCompat&EXT4_FEATURE_RO_COMPAT_QUOTA != 0 {
+		info.SetTag(TagQuota, "1")
+
+		for tag, inum := range map[string]uint32{
+			TagQuotaUsrInode: sb.UsrQuotaInum,
+			TagQuotaGrpInode: sb.GrpQuotaInum,
+			TagQuotaPrjInode: sb.PrjQuotaInum,
+		} {
+			if inum != 0 {
+				info.SetTag(tag, fmt.Sprint(inum))
+			}
+		}
+	}
+
+	if sb.FeatureRoCompat&EXT4_FEATURE_RO_COMPAT_PROJECT != 0 {
+		info.SetTag(TagProject, "1")
+	}
+}
+
+/* encryptionMode names a fscrypt mode as e2p_encmode2string does */
+func encryptionMode(mode uint8) string {
+	switch mode {
+	case 1:
+		return "AES-256-XTS"
+	case 2: // nolint:gomnd
+		return "AES-256-GCM"
+	case 3: // nolint:gomnd
+		return "AES-256-CBC"
+	case 4: // nolint:gomnd
+		return "AES-256-CTS"
+	default:
+		return fmt.Sprintf("ENC_MODE_%d", mode)
+	}
+}
+
+func encodingName(encoding uint16) string {
+	if encoding == EXT4_ENC_UTF8_12_1 {
+		return "utf8-12.1"
+	}
+
+	return fmt.Sprintf("ENCODING_%d", encoding)
+}
+
+func boolTag(b bool) string {
+	if b {
+		return "1"
+	}
+
+	return "0"
+}
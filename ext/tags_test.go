@@ -0,0 +1,44 @@
+package ext
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/isi-lincoln/goblkid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeatureTags(t *testing.T) {
+	sb := &ext2SuperBlock{
+		FeatureCompat:   EXT3_FEATURE_COMPAT_HAS_JOURNAL,
+		FeatureIncompat: EXT4_FEATURE_INCOMPAT_EXTENTS | EXT4_FEATURE_INCOMPAT_ENCRYPT | EXT4_FEATURE_INCOMPAT_CASEFOLD,
+		FeatureRoCompat: EXT4_FEATURE_RO_COMPAT_QUOTA | EXT4_FEATURE_RO_COMPAT_VERITY,
+		EncryptAlgos:    [4]uint8{1, 4},
+		Encoding:        EXT4_ENC_UTF8_12_1,
+		UsrQuotaInum:    3,
+		GrpQuotaInum:    4,
+	}
+	sb.EncryptPwSalt[0] = 1
+
+	info := &goblkid.ProbeInfo{DeviceReader: bytes.NewReader(testImage(t, sb))}
+	ok, err := Chain.Probe(info)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	assert.Equal(t, map[string]string{
+		TagEncrypt:       "1",
+		TagEncryptAlgos:  "AES-256-XTS,AES-256-CTS",
+		TagEncryptPwSalt: "1",
+		TagCasefold:      "1",
+		TagEncoding:      "utf8-12.1",
+		TagVerity:        "1",
+		TagQuota:         "1",
+		TagQuotaUsrInode: "3",
+		TagQuotaGrpInode: "4",
+	}, info.Tags)
+
+	info = &goblkid.ProbeInfo{DeviceReader: bytes.NewReader(testImage(t, &ext2SuperBlock{}))}
+	_, err = Chain.Probe(info)
+	assert.Nil(t, err)
+	assert.Nil(t, info.Tags)
+}
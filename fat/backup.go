@@ -0,0 +1,269 @@
+package fat
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/isi-lincoln/goblkid"
+)
+
+// FAT32_BACKUP_BOOT is the sector mkfs.fat and Windows put the backup in
+const FAT32_BACKUP_BOOT = 6 // nolint:golint,stylecheck
+
+// TagBackupBoot reports how the FAT32 backup boot sector compares to the
+// primary: ok, missing or the fields that differ
+const TagBackupBoot = "FAT_BACKUP_BOOT"
+
+/* the fields of a FAT32 boot sector compared against the backup */
+var bootFields = []struct { // nolint:gochecknoglobals
+	name       string
+	start, end int
+}{
+	{"oem name", 0x00, 0x0b},
+	{"bpb", 0x0b, 0x24},
+	{"fat32 bpb", 0x24, 0x40},
+	{"drive and flags", 0x40, 0x43},
+	{"serial", 0x43, 0x47},
+	{"label", 0x47, 0x52},
+	{"fs type", 0x52, 0x5a},
+	{"boot code", 0x5a, 0x1fe},
+	{"signature", 0x1fe, 0x200},
+}
+
+// BackupBoot is the FAT32 backup boot sector as found on the volume
+type BackupBoot struct {
+	Sector uint16
+	Offset int64 /* in bytes from the start of the volume */
+
+	// Present is set when the sector holds a FAT32 boot sector
+	Present bool
+	// Differences names the boot sector fields the primary disagrees on,
+	// all of them when the primary is gone
+	Differences []string
+}
+
+// Matches reports whether the backup is a copy of the primary
+func (b *BackupBoot) Matches() bool {
+	return b.Present && len(b.Differences) == 0
+}
+
+// String summarizes the comparison as TagBackupBoot reports it
+func (b *BackupBoot) String() string {
+	switch {
+	case !b.Present:
+		return "missing"
+	case b.Matches():
+		return "ok"
+	default:
+		return "differs in " + strings.Join(b.Differences, ", ")
+	}
+}
+
+// ReadBackupBoot reads the backup boot sector of the FAT32 volume behind
+// info and compares it with the primary. When the primary is damaged the
+// backup is looked for in sector 6 for every sector size. It returns nil
+// for FAT12 and FAT16, and when no backup is found.
+func ReadBackupBoot(info *goblkid.ProbeInfo) (*BackupBoot, error) {
+	r := readerAt{info}
+
+	g, err := readGeometry(info)
+	if err == nil {
+		if g.bits != 32 {
+			return nil, nil
+		}
+
+		return g.readBackupBoot(r)
+	}
+
+	primary := make([]byte, SuperblockSize)
+	if _, err := r.ReadAt(primary, 0); err != nil {
+		return nil, err
+	}
+
+	/* no usable primary, so its geometry cannot say where the backup is */
+	for ss := int64(512); ss <= 4096; ss <<= 1 {
+		b, buf, err := readBackupBoot(r, FAT32_BACKUP_BOOT, FAT32_BACKUP_BOOT*ss)
+		if err != nil {
+			return nil, ignoreShort(err)
+		}
+
+		if ms, _, _ := vfatGetSuperblock(bytes.NewReader(buf)); b.Present && int64(ms.SectorSize) == ss {
+			b.Differences = compareBoot(primary, buf)
+			return b, nil
+		}
+	}
+
+	return nil, nil
+}
+
+/* readBackupBoot compares the backup the FAT32 boot sector points at */
+func (g *geometry) readBackupBoot(r io.ReaderAt) (*BackupBoot, error) {
+	primary := make([]byte, SuperblockSize)
+	if _, err := r.ReadAt(primary, 0); err != nil {
+		return nil, err
+	}
+
+	b, buf, err := readBackupBoot(r, g.vs.BackupBoot, int64(g.vs.BackupBoot)*g.sectorSize)
+	if err != nil || b == nil {
+		return b, err
+	}
+
+	b.Differences = compareBoot(primary, buf)
+
+	return b, nil
+}
+
+func readBackupBoot(r io.ReaderAt, sector uint16, offset int64) (*BackupBoot, []byte, error) {
+	b := &BackupBoot{Sector: sector, Offset: offset}
+	if sector == 0 || sector == 0xffff {
+		return nil, nil, nil
+	}
+
+	buf := make([]byte, SuperblockSize)
+	if _, err := r.ReadAt(buf, offset); err != nil {
+		return nil, nil, err
+	}
+
+	ms, vs, err := vfatGetSuperblock(bytes.NewReader(buf))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	b.Present = hasJump(buf[0]) && ms.FatLength == 0 && vs.Fat32Length != 0 &&
+		vs.BackupBoot == sector && isFATValidSuperblock(ms, vs, jumpMagic, false)
+
+	return b, buf, nil
+}
+
+/* compareBoot names the fields two boot sectors differ in */
+func compareBoot(primary, backup []byte) []string {
+	names := []string{}
+
+	for _, f := range bootFields {
+		if !bytes.Equal(primary[f.start:f.end], backup[f.start:f.end]) {
+			names = append(names, f.name)
+		}
+	}
+
+	return names
+}
+
+// ProbeBackup identifies the FAT32 volume from its backup boot sector when
+// the primary is damaged, filling info like Chain.Probe does
+func ProbeBackup(info *goblkid.ProbeInfo) (*BackupBoot, error) {
+	b, err := ReadBackupBoot(info)
+	if err != nil || b == nil || !b.Present {
+		return nil, err
+	}
+
+	boot := make([]byte, SuperblockSize)
+	if _, err := (readerAt{info}).ReadAt(boot, b.Offset); err != nil {
+		return nil, err
+	}
+
+	/* read the volume as if the backup was the primary */
+	shifted := *info
+	shifted.DeviceReader = &bootOverlay{ReadSeeker: info.DeviceReader, boot: boot, base: info.Offset}
+
+	ok, err := Chain.Probe(&shifted)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	shifted.DeviceReader = info.DeviceReader
+	*info = shifted
+
+	return b, nil
+}
+
+/* bootOverlay reads a device with its boot sector replaced */
+type bootOverlay struct {
+	io.ReadSeeker
+	boot []byte
+	base int64
+	pos  int64
+}
+
+func (o *bootOverlay) Seek(offset int64, whence int) (int64, error) {
+	pos, err := o.ReadSeeker.Seek(offset, whence)
+	o.pos = pos
+
+	return pos, err
+}
+
+func (o *bootOverlay) Read(p []byte) (int, error) {
+	n, err := o.ReadSeeker.Read(p)
+
+	for i := 0; i < n; i++ {
+		if off := o.pos + int64(i) - o.base; off >= 0 && off < int64(len(o.boot)) {
+			p[i] = o.boot[off]
+		}
+	}
+
+	o.pos += int64(n)
+
+	return n, err
+}
+
+// Extent is a byte range of the volume holding a signature
+type Extent struct {
+	Offset int64 /* in bytes from the start of the volume */
+	Length int64
+	What   string
+}
+
+// SignatureExtents lists the boot sector signatures the prober matches,
+// which is what wipefs erases. With backup set the signatures of the FAT32
+// backup boot sector, or the exFAT backup boot region, are included,
+// without which the volume can still be recovered from it. info must have
+// been probed with Chain.
+func SignatureExtents(info *goblkid.ProbeInfo, backup bool) ([]Extent, error) {
+	if info.ProbeName == ExFATName {
+		return exfatSignatureExtents(info, backup)
+	}
+
+	extents, err := signatureExtents(info, 0, "boot sector")
+	if err != nil || !backup {
+		return extents, err
+	}
+
+	b, err := ReadBackupBoot(info)
+	if err != nil || b == nil || !b.Present {
+		return extents, err
+	}
+
+	more, err := signatureExtents(info, b.Offset, "backup boot sector")
+
+	return append(extents, more...), err
+}
+
+func signatureExtents(info *goblkid.ProbeInfo, base int64, what string) ([]Extent, error) {
+	extents := []Extent{}
+	covered := map[uint64]bool{}
+
+	for _, magic := range FATProber.MagicInfos {
+		if covered[magic.MagicByteOffset] {
+			continue
+		}
+
+		at := *info
+		at.Offset = info.Offset + base
+
+		ok, err := magic.Match(&at)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			covered[magic.MagicByteOffset] = true
+			extents = append(extents, Extent{
+				base + int64(magic.MagicByteOffset), int64(len(magic.Magic)),
+				fmt.Sprintf("%s magic % x", what, magic.Magic),
+			})
+		}
+	}
+
+	return extents, nil
+}
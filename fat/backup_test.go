@@ -0,0 +1,120 @@
+package fat
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/isi-lincoln/goblkid"
+	"github.com/stretchr/testify/assert"
+)
+
+/* syncBackup copies the boot sector and FSInfo over their backups */
+func (f *fatImage) syncBackup() {
+	copy(f.data[6*f.sectorSize:], f.data[:2*f.sectorSize])
+}
+
+func TestReadBackupBoot(t *testing.T) {
+	f := newFATImage(32)
+	info := &goblkid.ProbeInfo{DeviceReader: bytes.NewReader(f.data)}
+
+	b, err := ReadBackupBoot(info)
+	assert.Nil(t, err)
+	assert.True(t, b.Matches())
+	assert.Equal(t, uint16(6), b.Sector)
+	assert.Equal(t, int64(6*512), b.Offset)
+	assert.Equal(t, "ok", f.probe(t).Tags[TagBackupBoot])
+
+	/* relabelled by a tool that forgot the backup */
+	f.setBootLabel("NEWLABEL")
+
+	b, err = ReadBackupBoot(info)
+	assert.Nil(t, err)
+	assert.True(t, b.Present)
+	assert.False(t, b.Matches())
+	assert.Equal(t, []string{"label"}, b.Differences)
+	assert.Equal(t, "differs in label", f.probe(t).Tags[TagBackupBoot])
+
+	copy(f.data[6*512:], make([]byte, 512))
+
+	b, err = ReadBackupBoot(info)
+	assert.Nil(t, err)
+	assert.False(t, b.Present)
+	assert.Equal(t, "missing", f.probe(t).Tags[TagBackupBoot])
+
+	b, err = ReadBackupBoot(&goblkid.ProbeInfo{DeviceReader: bytes.NewReader(newFATImage(16).data)})
+	assert.Nil(t, err)
+	assert.Nil(t, b)
+}
+
+func TestProbeBackup(t *testing.T) {
+	f := newFATImage(32)
+	f.setBootLabel("RESCUE")
+	f.syncBackup()
+
+	/* damage the primary */
+	copy(f.data, make([]byte, 512))
+
+	info := &goblkid.ProbeInfo{DeviceReader: bytes.NewReader(f.data)}
+	ok, err := Chain.Probe(info)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	b, err := ProbeBackup(info)
+	assert.Nil(t, err)
+	assert.NotNil(t, b)
+	assert.Equal(t, FatName, info.ProbeName)
+	assert.Equal(t, "RESCUE", info.Label)
+	assert.Equal(t, "1234-ABCD", info.UUID.String())
+	assert.Equal(t, "FAT32", info.Version)
+	assert.Equal(t, uint64(f.clusters()-1), info.FreeBlocks)
+	assert.Contains(t, b.Differences, "signature")
+
+	/* nothing to recover from */
+	copy(f.data[6*512:], make([]byte, 512))
+	info = &goblkid.ProbeInfo{DeviceReader: bytes.NewReader(f.data)}
+
+	b, err = ProbeBackup(info)
+	assert.Nil(t, err)
+	assert.Nil(t, b)
+	assert.Equal(t, "", info.ProbeName)
+}
+
+func TestSignatureExtents(t *testing.T) {
+	f := newFATImage(32)
+	info := &goblkid.ProbeInfo{DeviceReader: bytes.NewReader(f.data)}
+
+	extents, err := SignatureExtents(info, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{0x52, 0x00, 0x1fe}, offsets(extents))
+
+	extents, err = SignatureExtents(info, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{0x52, 0x00, 0x1fe, 0xc52, 0xc00, 0xdfe}, offsets(extents))
+
+	/* wiping them leaves nothing for either the prober or the recovery */
+	for _, e := range extents {
+		copy(f.data[e.Offset:], make([]byte, e.Length))
+	}
+
+	ok, err := Chain.Probe(info)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	b, err := ProbeBackup(info)
+	assert.Nil(t, err)
+	assert.Nil(t, b)
+
+	f = newFATImage(12)
+	extents, err = SignatureExtents(&goblkid.ProbeInfo{DeviceReader: bytes.NewReader(f.data)}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{0x36, 0x00, 0x1fe}, offsets(extents))
+}
+
+func offsets(extents []Extent) []int64 {
+	o := []int64{}
+	for _, e := range extents {
+		o = append(o, e.Offset)
+	}
+
+	return o
+}
@@ -0,0 +1,299 @@
+package fat
+
+import (
+	"fmt"
+	"io"
+	"path"
+)
+
+// clean shutdown and hard error bits of FAT[1], cleared when the volume was
+// not unmounted cleanly or hit I/O errors. FAT12 has neither.
+const (
+	FAT16_CLEAN_SHUTDOWN = 0x8000     // nolint:golint,stylecheck
+	FAT16_HARD_ERROR     = 0x4000     // nolint:golint,stylecheck
+	FAT32_CLEAN_SHUTDOWN = 0x08000000 // nolint:golint,stylecheck
+	FAT32_HARD_ERROR     = 0x04000000 // nolint:golint,stylecheck
+)
+
+// CheckReport is what Check found on a FAT volume
+type CheckReport struct {
+	Version  string
+	Clusters uint32
+	Used     uint32 /* clusters owned by files and directories */
+	Lost     uint32 /* allocated clusters nothing owns */
+	Files    int
+	Dirs     int
+
+	// Dirty is set when FAT[1] says the volume was not cleanly unmounted
+	Dirty bool
+
+	// Problems are inconsistencies a fsck would repair
+	Problems []string
+	// Warnings are signals that need no repair, such as a dirty volume
+	Warnings []string
+}
+
+// OK reports whether the volume passed the check: it has no Problems,
+// a hard error flag among them. Warnings such as a dirty volume do not
+// fail it.
+func (r *CheckReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *CheckReport) problem(format string, args ...interface{}) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+/* checker walks the directory tree, recording which path owns each cluster */
+type checker struct {
+	f      *FS
+	r      *CheckReport
+	owners map[uint32]string
+}
+
+// Check walks the FAT and the directory tree of the volume in r without
+// writing to it, as fsck.fat -n does. It compares the FAT copies, follows
+// every cluster chain looking for clusters out of range, loops, cross-links
+// and chains that disagree with file sizes, and counts the clusters the FAT
+// allocates that nothing owns.
+func Check(r io.ReaderAt) (*CheckReport, error) {
+	f, err := Open(r)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &checker{
+		f:      f,
+		r:      &CheckReport{Version: fmt.Sprintf("FAT%d", f.g.bits), Clusters: f.g.clusters},
+		owners: map[uint32]string{},
+	}
+
+	if err := c.compareFATs(); err != nil {
+		return nil, err
+	}
+
+	c.checkDirty()
+
+	if err := c.walk("/", f.g.rootCluster, 0); err != nil {
+		return nil, err
+	}
+
+	c.countLost()
+
+	h, err := f.g.readFSInfo(f.r)
+	if err != nil {
+		return nil, err
+	}
+
+	if h != nil {
+		c.r.Warnings = append(c.r.Warnings, h.Problems...)
+
+		free := freeClusters(f.fat)
+		if h.FreeKnown() && h.FreeClusters != free {
+			c.r.Warnings = append(c.r.Warnings,
+				fmt.Sprintf("fsinfo free count %d, the FAT has %d free clusters", h.FreeClusters, free))
+		}
+	}
+
+	return c.r, nil
+}
+
+/* compareFATs reports every FAT copy that differs from the first */
+func (c *checker) compareFATs() error {
+	for n := 1; n < c.f.g.fats; n++ {
+		fat, err := c.f.g.readFAT(c.f.r, n)
+		if err != nil {
+			return err
+		}
+
+		diff, first := 0, uint32(0)
+
+		for i := range c.f.fat {
+			if i >= len(fat) || fat[i] != c.f.fat[i] {
+				if diff == 0 {
+					first = uint32(i)
+				}
+
+				diff++
+			}
+		}
+
+		if diff > 0 {
+			c.r.problem("FAT %d differs from FAT 1 in %d entries, the first at cluster %d", n+1, diff, first)
+		}
+	}
+
+	return nil
+}
+
+/*
+ * checkDirty reads the flags FAT[1] keeps on FAT16 and FAT32, both set
+ * when all is well. A volume that was not cleanly unmounted is only a
+ * warning, but one that hit I/O errors may hold damage the walk cannot
+ * see, so that fails the check.
+ */
+func (c *checker) checkDirty() {
+	entry := c.f.fat[1]
+	hardError := false
+
+	switch c.f.g.bits {
+	case 16:
+		c.r.Dirty = entry&FAT16_CLEAN_SHUTDOWN == 0
+		hardError = entry&FAT16_HARD_ERROR == 0
+	case 32:
+		c.r.Dirty = entry&FAT32_CLEAN_SHUTDOWN == 0
+		hardError = entry&FAT32_HARD_ERROR == 0
+	}
+
+	if hardError {
+		c.r.problem("hard error flag cleared, the volume hit I/O errors")
+	}
+
+	if c.r.Dirty {
+		c.r.Warnings = append(c.r.Warnings, "clean shutdown flag cleared, the volume was not cleanly unmounted")
+	}
+}
+
+/*
+ * walk checks the directory name, whose own cluster is self and whose
+ * parent's is parent, and everything below it. Cluster 0 is the FAT12/16
+ * root directory, which lives outside the data clusters.
+ */
+func (c *checker) walk(name string, self, parent uint32) error {
+	c.r.Dirs++
+
+	var buf []byte
+
+	if self == 0 {
+		buf = make([]byte, int64(c.f.g.rootEntries)*FAT_DIR_ENTRY_SIZE)
+		if err := c.f.readAt(buf, c.f.g.rootOffset); err != nil {
+			return err
+		}
+	} else {
+		clusters, ok := c.own(name, self)
+		if !ok {
+			return nil
+		}
+
+		var err error
+		if buf, err = c.f.readClusters(clusters); err != nil {
+			return err
+		}
+	}
+
+	for _, e := range parseDirents(buf, c.f.cp, c.f.g.bits) {
+		p := path.Join(name, e.name)
+
+		switch {
+		case e.short == ".":
+			if e.cluster != self {
+				c.r.problem("%s: . points at cluster %d instead of %d", name, e.cluster, self)
+			}
+
+			continue
+		case e.short == "..":
+			if e.cluster != parent && !(parent == c.f.g.rootCluster && e.cluster == 0) {
+				c.r.problem("%s: .. points at cluster %d instead of %d", name, e.cluster, parent)
+			}
+
+			continue
+		case e.isDir():
+			if e.cluster == 0 {
+				c.r.problem("%s: directory without clusters", p)
+				continue
+			}
+
+			if err := c.walk(p, e.cluster, self); err != nil {
+				return err
+			}
+		default:
+			c.r.Files++
+			c.checkFile(p, e)
+		}
+	}
+
+	return nil
+}
+
+/* checkFile compares the chain of a file with its size */
+func (c *checker) checkFile(name string, e *dirent) {
+	if e.cluster == 0 {
+		if e.size != 0 {
+			c.r.problem("%s: %d bytes but no clusters", name, e.size)
+		}
+
+		return
+	}
+
+	clusters, ok := c.own(name, e.cluster)
+	if !ok {
+		return
+	}
+
+	cs := uint64(c.f.g.clusterSize)
+	if want := (uint64(e.size) + cs - 1) / cs; want != uint64(len(clusters)) {
+		c.r.problem("%s: %d bytes need %d clusters, the chain has %d", name, e.size, want, len(clusters))
+	}
+}
+
+/*
+ * own follows the chain starting at first and claims its clusters for
+ * name. A chain that is broken is reported and its readable part kept,
+ * one crossing another is reported and not followed further.
+ */
+func (c *checker) own(name string, first uint32) ([]uint32, bool) {
+	g := c.f.g
+	clusters := []uint32{}
+	seen := map[uint32]bool{}
+
+	for cur := first; ; {
+		if !g.validCluster(cur) {
+			c.r.problem("%s: cluster %d is outside the %d clusters of the volume", name, cur, g.clusters)
+			return clusters, len(clusters) > 0
+		}
+
+		if seen[cur] {
+			c.r.problem("%s: cluster chain loops back to cluster %d", name, cur)
+			return clusters, true
+		}
+
+		if other, ok := c.owners[cur]; ok {
+			c.r.problem("%s: cross-linked with %s at cluster %d", name, other, cur)
+			return clusters, false
+		}
+
+		seen[cur] = true
+		c.owners[cur] = name
+		c.r.Used++
+		clusters = append(clusters, cur)
+
+		next := c.f.fat[cur]
+
+		switch {
+		case g.eoc(next):
+			return clusters, true
+		case next == FAT_CLUSTER_FREE:
+			c.r.problem("%s: cluster chain runs into free cluster %d", name, cur)
+			return clusters, true
+		case g.bad(next):
+			c.r.problem("%s: cluster chain runs into a bad cluster after %d", name, cur)
+			return clusters, true
+		}
+
+		cur = next
+	}
+}
+
+/* countLost reports the allocated clusters no chain owns */
+func (c *checker) countLost() {
+	for n := uint32(FAT_CLUSTER_FIRST); n < uint32(len(c.f.fat)); n++ {
+		e := c.f.fat[n]
+		if e != FAT_CLUSTER_FREE && !c.f.g.bad(e) && c.owners[n] == "" {
+			c.r.Lost++
+		}
+	}
+
+	if c.r.Lost > 0 {
+		c.r.problem("%d lost clusters, allocated but not part of any file", c.r.Lost)
+	}
+}
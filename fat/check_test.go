@@ -0,0 +1,133 @@
+package fat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheck(t *testing.T) {
+	for _, bits := range []int{12, 16, 32} {
+		f := newFATImage(bits)
+		f.writeTree(espTree())
+
+		r, err := Check(bytes.NewReader(f.data))
+		assert.Nil(t, err)
+		assert.True(t, r.OK(), "FAT%d: %v", bits, r.Problems)
+		assert.False(t, r.Dirty)
+		assert.Equal(t, 70, r.Files)
+		assert.Equal(t, 6, r.Dirs)
+		assert.Equal(t, uint32(0), r.Lost)
+		assert.Equal(t, uint32(f.clusters())-freeClusters(mustFAT(t, f)), r.Used)
+	}
+}
+
+func TestCheckProblems(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(f *fatImage, readme, abc *Stat)
+		want   string
+	}{
+		{"copies", func(f *fatImage, readme, abc *Stat) {
+			fat2 := f.data[(f.reserved+f.fatLength)*f.sectorSize:]
+			binary.LittleEndian.PutUint16(fat2[2*1000:], 0xffff)
+		}, "FAT 2 differs from FAT 1 in 1 entries, the first at cluster 1000"},
+		{"cross-link", func(f *fatImage, readme, abc *Stat) {
+			f.setFAT(readme.Cluster, abc.Cluster)
+		}, "cross-linked with"},
+		{"lost", func(f *fatImage, readme, abc *Stat) {
+			f.setFAT(1000, 1001)
+			f.setFAT(1001, 0xffff)
+		}, "2 lost clusters"},
+		{"past", func(f *fatImage, readme, abc *Stat) {
+			binary.LittleEndian.PutUint16(f.rootEntry("README.TXT")[26:], 0xfff0)
+		}, "/README.TXT: cluster 65520 is outside the"},
+		{"size", func(f *fatImage, readme, abc *Stat) {
+			binary.LittleEndian.PutUint32(f.rootEntry("README.TXT")[28:], 10000)
+		}, "/README.TXT: 10000 bytes need 5 clusters, the chain has 1"},
+		{"free", func(f *fatImage, readme, abc *Stat) {
+			f.setFAT(readme.Cluster, 0)
+		}, "/README.TXT: cluster chain runs into free cluster"},
+	}
+
+	for _, tt := range tests {
+		f := newFATImage(16)
+		f.writeTree(espTree())
+
+		fsys, err := Open(bytes.NewReader(f.data))
+		assert.Nil(t, err)
+
+		readme, err := fsys.Stat("README.TXT")
+		assert.Nil(t, err)
+
+		abc, err := fsys.Stat("a b c")
+		assert.Nil(t, err)
+
+		tt.change(f, readme.Sys().(*Stat), abc.Sys().(*Stat))
+
+		r, err := Check(bytes.NewReader(f.data))
+		assert.Nil(t, err)
+		assert.False(t, r.OK(), tt.name)
+		assert.True(t, containsProblem(r.Problems, tt.want), "%s: %v", tt.name, r.Problems)
+	}
+}
+
+func TestCheckDirty(t *testing.T) {
+	for bits, entry := range map[int]uint32{16: 0x7fff, 32: 0x07ffffff} {
+		f := newFATImage(bits)
+		f.writeTree(espTree())
+		f.setFAT(1, entry)
+
+		r, err := Check(bytes.NewReader(f.data))
+		assert.Nil(t, err)
+		assert.True(t, r.OK())
+		assert.True(t, r.Dirty)
+		assert.True(t, containsProblem(r.Warnings, "clean shutdown flag cleared"))
+	}
+
+	/* the hard error flag fails the check, clean shutdown or not */
+	for bits, entry := range map[int]uint32{16: 0xbfff, 32: 0x0bffffff} {
+		f := newFATImage(bits)
+		f.writeTree(espTree())
+		f.setFAT(1, entry)
+
+		r, err := Check(bytes.NewReader(f.data))
+		assert.Nil(t, err)
+		assert.False(t, r.OK())
+		assert.False(t, r.Dirty)
+		assert.True(t, containsProblem(r.Problems, "hard error flag cleared"), "FAT%d: %v", bits, r.Problems)
+	}
+}
+
+/* rootEntry finds the short entry of name in the FAT12/16 root directory */
+func (f *fatImage) rootEntry(name string) []byte {
+	root := f.data[f.rootOffset() : f.rootOffset()+f.rootEntries*32]
+
+	for off := 0; off < len(root); off += 32 {
+		if bytes.Equal(root[off:off+11], rawShort(name)) {
+			return root[off : off+32]
+		}
+	}
+
+	return nil
+}
+
+func mustFAT(t *testing.T, f *fatImage) []uint32 {
+	fsys, err := Open(bytes.NewReader(f.data))
+	assert.Nil(t, err)
+
+	return fsys.fat
+}
+
+func containsProblem(problems []string, want string) bool {
+	for _, p := range problems {
+		if strings.Contains(p, want) {
+			return true
+		}
+	}
+
+	return false
+}
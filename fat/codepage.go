@@ -0,0 +1,155 @@
+package fat
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/isi-lincoln/goblkid"
+)
+
+// CodePage maps the 8 bit characters of FAT short names and labels to
+// unicode. The lower half is ASCII in every code page supported here.
+type CodePage struct {
+	Name string
+	high [128]rune
+}
+
+// Decode converts the bytes of a name to UTF-8
+func (cp *CodePage) Decode(b []byte) string {
+	var sb strings.Builder
+
+	for _, c := range b {
+		if c < 0x80 { // nolint:gomnd
+			sb.WriteByte(c)
+		} else {
+			sb.WriteRune(cp.high[c-0x80])
+		}
+	}
+
+	return sb.String()
+}
+
+// Encode converts a name to the code page, failing on a rune it lacks
+func (cp *CodePage) Encode(s string) ([]byte, error) {
+	b := make([]byte, 0, len(s))
+
+	for _, r := range s {
+		if r < 0x80 { // nolint:gomnd
+			b = append(b, byte(r))
+			continue
+		}
+
+		c := cp.index(r)
+		if c < 0 {
+			return nil, fmt.Errorf("%q is not in %s", r, cp.Name)
+		}
+
+		b = append(b, byte(0x80+c)) // nolint:gomnd
+	}
+
+	return b, nil
+}
+
+func (cp *CodePage) index(r rune) int {
+	for i, h := range cp.high {
+		if h == r {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// The code pages a FAT label can be decoded with
+var (
+	// CP437 is the original IBM PC code page, what DOS and Windows use
+	// in the US and what the kernel defaults to
+	CP437 = &CodePage{Name: "cp437", high: [128]rune{ // nolint:gochecknoglobals
+		0x00c7, 0x00fc, 0x00e9, 0x00e2, 0x00e4, 0x00e0, 0x00e5, 0x00e7,
+		0x00ea, 0x00eb, 0x00e8, 0x00ef, 0x00ee, 0x00ec, 0x00c4, 0x00c5,
+		0x00c9, 0x00e6, 0x00c6, 0x00f4, 0x00f6, 0x00f2, 0x00fb, 0x00f9,
+		0x00ff, 0x00d6, 0x00dc, 0x00a2, 0x00a3, 0x00a5, 0x20a7, 0x0192,
+		0x00e1, 0x00ed, 0x00f3, 0x00fa, 0x00f1, 0x00d1, 0x00aa, 0x00ba,
+		0x00bf, 0x2310, 0x00ac, 0x00bd, 0x00bc, 0x00a1, 0x00ab, 0x00bb,
+		0x2591, 0x2592, 0x2593, 0x2502, 0x2524, 0x2561, 0x2562, 0x2556,
+		0x2555, 0x2563, 0x2551, 0x2557, 0x255d, 0x255c, 0x255b, 0x2510,
+		0x2514, 0x2534, 0x252c, 0x251c, 0x2500, 0x253c, 0x255e, 0x255f,
+		0x255a, 0x2554, 0x2569, 0x2566, 0x2560, 0x2550, 0x256c, 0x2567,
+		0x2568, 0x2564, 0x2565, 0x2559, 0x2558, 0x2552, 0x2553, 0x256b,
+		0x256a, 0x2518, 0x250c, 0x2588, 0x2584, 0x258c, 0x2590, 0x2580,
+		0x03b1, 0x00df, 0x0393, 0x03c0, 0x03a3, 0x03c3, 0x00b5, 0x03c4,
+		0x03a6, 0x0398, 0x03a9, 0x03b4, 0x221e, 0x03c6, 0x03b5, 0x2229,
+		0x2261, 0x00b1, 0x2265, 0x2264, 0x2320, 0x2321, 0x00f7, 0x2248,
+		0x00b0, 0x2219, 0x00b7, 0x221a, 0x207f, 0x00b2, 0x25a0, 0x00a0,
+	}}
+
+	// CP850 is the DOS code page of western European installs
+	CP850 = &CodePage{Name: "cp850", high: [128]rune{ // nolint:gochecknoglobals
+		0x00c7, 0x00fc, 0x00e9, 0x00e2, 0x00e4, 0x00e0, 0x00e5, 0x00e7,
+		0x00ea, 0x00eb, 0x00e8, 0x00ef, 0x00ee, 0x00ec, 0x00c4, 0x00c5,
+		0x00c9, 0x00e6, 0x00c6, 0x00f4, 0x00f6, 0x00f2, 0x00fb, 0x00f9,
+		0x00ff, 0x00d6, 0x00dc, 0x00f8, 0x00a3, 0x00d8, 0x00d7, 0x0192,
+		0x00e1, 0x00ed, 0x00f3, 0x00fa, 0x00f1, 0x00d1, 0x00aa, 0x00ba,
+		0x00bf, 0x00ae, 0x00ac, 0x00bd, 0x00bc, 0x00a1, 0x00ab, 0x00bb,
+		0x2591, 0x2592, 0x2593, 0x2502, 0x2524, 0x00c1, 0x00c2, 0x00c0,
+		0x00a9, 0x2563, 0x2551, 0x2557, 0x255d, 0x00a2, 0x00a5, 0x2510,
+		0x2514, 0x2534, 0x252c, 0x251c, 0x2500, 0x253c, 0x00e3, 0x00c3,
+		0x255a, 0x2554, 0x2569, 0x2566, 0x2560, 0x2550, 0x256c, 0x00a4,
+		0x00f0, 0x00d0, 0x00ca, 0x00cb, 0x00c8, 0x0131, 0x00cd, 0x00ce,
+		0x00cf, 0x2518, 0x250c, 0x2588, 0x2584, 0x00a6, 0x00cc, 0x2580,
+		0x00d3, 0x00df, 0x00d4, 0x00d2, 0x00f5, 0x00d5, 0x00b5, 0x00fe,
+		0x00de, 0x00da, 0x00db, 0x00d9, 0x00fd, 0x00dd, 0x00af, 0x00b4,
+		0x00ad, 0x00b1, 0x2017, 0x00be, 0x00b6, 0x00a7, 0x00f7, 0x00b8,
+		0x00b0, 0x00a8, 0x00b7, 0x00b9, 0x00b3, 0x00b2, 0x25a0, 0x00a0,
+	}}
+
+	// CP1252 is the Windows ANSI code page some tools wrongly write
+	// labels in, its undefined bytes map to the C1 controls
+	CP1252 = &CodePage{Name: "cp1252", high: [128]rune{ // nolint:gochecknoglobals
+		0x20ac, 0x0081, 0x201a, 0x0192, 0x201e, 0x2026, 0x2020, 0x2021,
+		0x02c6, 0x2030, 0x0160, 0x2039, 0x0152, 0x008d, 0x017d, 0x008f,
+		0x0090, 0x2018, 0x2019, 0x201c, 0x201d, 0x2022, 0x2013, 0x2014,
+		0x02dc, 0x2122, 0x0161, 0x203a, 0x0153, 0x009d, 0x017e, 0x0178,
+		0x00a0, 0x00a1, 0x00a2, 0x00a3, 0x00a4, 0x00a5, 0x00a6, 0x00a7,
+		0x00a8, 0x00a9, 0x00aa, 0x00ab, 0x00ac, 0x00ad, 0x00ae, 0x00af,
+		0x00b0, 0x00b1, 0x00b2, 0x00b3, 0x00b4, 0x00b5, 0x00b6, 0x00b7,
+		0x00b8, 0x00b9, 0x00ba, 0x00bb, 0x00bc, 0x00bd, 0x00be, 0x00bf,
+		0x00c0, 0x00c1, 0x00c2, 0x00c3, 0x00c4, 0x00c5, 0x00c6, 0x00c7,
+		0x00c8, 0x00c9, 0x00ca, 0x00cb, 0x00cc, 0x00cd, 0x00ce, 0x00cf,
+		0x00d0, 0x00d1, 0x00d2, 0x00d3, 0x00d4, 0x00d5, 0x00d6, 0x00d7,
+		0x00d8, 0x00d9, 0x00da, 0x00db, 0x00dc, 0x00dd, 0x00de, 0x00df,
+		0x00e0, 0x00e1, 0x00e2, 0x00e3, 0x00e4, 0x00e5, 0x00e6, 0x00e7,
+		0x00e8, 0x00e9, 0x00ea, 0x00eb, 0x00ec, 0x00ed, 0x00ee, 0x00ef,
+		0x00f0, 0x00f1, 0x00f2, 0x00f3, 0x00f4, 0x00f5, 0x00f6, 0x00f7,
+		0x00f8, 0x00f9, 0x00fa, 0x00fb, 0x00fc, 0x00fd, 0x00fe, 0x00ff,
+	}}
+)
+
+// CodePageByName returns the supported code page called name, such as cp850
+func CodePageByName(name string) (*CodePage, error) {
+	for _, cp := range []*CodePage{CP437, CP850, CP1252} {
+		if strings.EqualFold(cp.Name, name) || strings.EqualFold("cp"+name, cp.Name) {
+			return cp, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unsupported code page %q, want cp437, cp850 or cp1252", name)
+}
+
+/* orCP437 is cp, or CP437 for the nil code page */
+func orCP437(cp *CodePage) *CodePage {
+	if cp == nil {
+		return CP437
+	}
+
+	return cp
+}
+
+/* probeCodePage is the code page the probe of info decodes labels with */
+func probeCodePage(info *goblkid.ProbeInfo) (*CodePage, error) {
+	if info.FATCodePage == "" {
+		return CP437, nil
+	}
+
+	return CodePageByName(info.FATCodePage)
+}
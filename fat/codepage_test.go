@@ -0,0 +1,52 @@
+package fat
+
+import (
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecode(t *testing.T) {
+	/* "ÉTÉ" in each code page */
+	assert.Equal(t, "ÉTÉ", CP437.Decode([]byte{0x90, 'T', 0x90}))
+	assert.Equal(t, "ÉTÉ", CP850.Decode([]byte{0x90, 'T', 0x90}))
+	assert.Equal(t, "ÉTÉ", CP1252.Decode([]byte{0xc9, 'T', 0xc9}))
+
+	assert.Equal(t, "Ø", CP850.Decode([]byte{0x9d}))
+	assert.Equal(t, "¥", CP437.Decode([]byte{0x9d}))
+	assert.Equal(t, "€", CP1252.Decode([]byte{0x80}))
+
+	for _, cp := range []*CodePage{CP437, CP850, CP1252} {
+		for c := 0; c < 256; c++ {
+			assert.True(t, utf8.ValidString(cp.Decode([]byte{byte(c)})), "%s %#x", cp.Name, c)
+		}
+	}
+}
+
+func TestCodePageByName(t *testing.T) {
+	cp, err := CodePageByName("CP850")
+	assert.Nil(t, err)
+	assert.Equal(t, CP850, cp)
+
+	cp, err = CodePageByName("1252")
+	assert.Nil(t, err)
+	assert.Equal(t, CP1252, cp)
+
+	_, err = CodePageByName("koi8-r")
+	assert.NotNil(t, err)
+}
+
+func TestEncode(t *testing.T) {
+	for _, cp := range []*CodePage{CP437, CP850, CP1252} {
+		for c := 0; c < 256; c++ {
+			s := cp.Decode([]byte{byte(c)})
+			b, err := cp.Encode(s)
+			assert.Nil(t, err, "%s %#x", cp.Name, c)
+			assert.Equal(t, []byte{byte(c)}, b, "%s %#x", cp.Name, c)
+		}
+	}
+
+	_, err := CP437.Encode("€")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,193 @@
+package fat
+
+import (
+	"encoding/binary"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// directory entry attributes and fields besides those the prober uses
+const (
+	FAT_ATTR_READ_ONLY = 0x01 // nolint:golint,stylecheck
+	FAT_ATTR_HIDDEN    = 0x02 // nolint:golint,stylecheck
+	FAT_ATTR_SYSTEM    = 0x04 // nolint:golint,stylecheck
+	FAT_ATTR_ARCHIVE   = 0x20 // nolint:golint,stylecheck
+
+	FAT_DIR_ENTRY_SIZE = 32 // nolint:golint,stylecheck
+
+	FAT_LFN_LAST    = 0x40 // nolint:golint,stylecheck // ordinal flag of the first long name entry
+	FAT_LFN_ORDINAL = 0x1f // nolint:golint,stylecheck
+	FAT_LFN_CHARS   = 13   // nolint:golint,stylecheck // UTF-16 units in each long name entry
+	FAT_LFN_MAX     = 20   // nolint:golint,stylecheck // entries of a 255 character name
+
+	FAT_CASE_LOWER_BASE = 0x08 // nolint:golint,stylecheck // Windows NT lowercase flags
+	FAT_CASE_LOWER_EXT  = 0x10 // nolint:golint,stylecheck
+)
+
+/* offsets of the UTF-16 units within a long name entry */
+var lfnOffsets = [FAT_LFN_CHARS]int{1, 3, 5, 7, 9, 14, 16, 18, 20, 22, 24, 28, 30} // nolint:gochecknoglobals
+
+/* dirent is a short directory entry with its long name assembled */
+type dirent struct {
+	name  string /* the long name when it checks out, the short name otherwise */
+	short string /* as NAME.EXT */
+	attr  uint8
+
+	cluster uint32
+	size    uint32
+
+	created  time.Time
+	modified time.Time
+	accessed time.Time
+
+	slot int /* of the short entry within the directory */
+}
+
+func (e *dirent) isDir() bool {
+	return e.attr&FAT_ATTR_DIR != 0
+}
+
+/* dot reports whether the entry is the . or .. of a subdirectory */
+func (e *dirent) dot() bool {
+	return e.short == "." || e.short == ".."
+}
+
+/*
+ * parseDirents decodes the entries of a directory up to its end marker.
+ * Long name entries precede their short entry from the last part to the
+ * first. A run that is out of order or whose checksum does not match the
+ * short name is an orphan, and the short name is used instead. Free and
+ * volume label entries are skipped.
+ */
+func parseDirents(buf []byte, cp *CodePage, bits int) []*dirent {
+	entries := []*dirent{}
+
+	var (
+		lfn  []uint16
+		next uint8
+		sum  uint8
+	)
+
+	for off := 0; off+FAT_DIR_ENTRY_SIZE <= len(buf); off += FAT_DIR_ENTRY_SIZE {
+		e := buf[off : off+FAT_DIR_ENTRY_SIZE]
+
+		switch {
+		case e[0] == 0:
+			return entries
+		case e[0] == FAT_ENTRY_FREE:
+			lfn = nil
+			continue
+		case e[11]&FAT_ATTR_MASK == FAT_ATTR_LONG_NAME:
+			ord := e[0] & FAT_LFN_ORDINAL
+
+			if e[0]&FAT_LFN_LAST != 0 {
+				lfn, next, sum = nil, ord, e[13]
+				if ord != 0 && ord <= FAT_LFN_MAX {
+					lfn = make([]uint16, int(ord)*FAT_LFN_CHARS)
+				}
+			}
+
+			if lfn == nil || ord == 0 || ord != next || e[13] != sum {
+				lfn = nil
+				continue
+			}
+
+			for i, o := range lfnOffsets {
+				lfn[int(ord-1)*FAT_LFN_CHARS+i] = binary.LittleEndian.Uint16(e[o:])
+			}
+
+			next--
+
+			continue
+		case e[11]&FAT_ATTR_VOLUME_ID != 0:
+			lfn = nil
+			continue
+		}
+
+		ent := &dirent{
+			short:    shortName(e, cp),
+			attr:     e[11],
+			cluster:  uint32(binary.LittleEndian.Uint16(e[26:])),
+			size:     binary.LittleEndian.Uint32(e[28:]),
+			created:  fatTime(binary.LittleEndian.Uint16(e[16:]), binary.LittleEndian.Uint16(e[14:]), e[13]),
+			modified: fatTime(binary.LittleEndian.Uint16(e[24:]), binary.LittleEndian.Uint16(e[22:]), 0),
+			accessed: fatTime(binary.LittleEndian.Uint16(e[18:]), 0, 0),
+			slot:     off / FAT_DIR_ENTRY_SIZE,
+		}
+
+		if bits == 32 {
+			ent.cluster |= uint32(binary.LittleEndian.Uint16(e[20:])) << 16
+		}
+
+		ent.name = ent.short
+		if lfn != nil && next == 0 && lfnChecksum(e[:11]) == sum {
+			ent.name = decodeLFN(lfn)
+		}
+
+		lfn = nil
+		entries = append(entries, ent)
+	}
+
+	return entries
+}
+
+/* shortName decodes an 8.3 name, applying the lowercase flags Windows NT sets */
+func shortName(e []byte, cp *CodePage) string {
+	base := []byte(strings.TrimRight(string(e[0:8]), " "))
+	ext := []byte(strings.TrimRight(string(e[8:11]), " "))
+
+	if len(base) > 0 && base[0] == FAT_ENTRY_E5 {
+		base[0] = FAT_ENTRY_FREE
+	}
+
+	name := cp.Decode(base)
+	if e[12]&FAT_CASE_LOWER_BASE != 0 {
+		name = strings.ToLower(name)
+	}
+
+	if len(ext) == 0 {
+		return name
+	}
+
+	suffix := cp.Decode(ext)
+	if e[12]&FAT_CASE_LOWER_EXT != 0 {
+		suffix = strings.ToLower(suffix)
+	}
+
+	return name + "." + suffix
+}
+
+/* lfnChecksum is the checksum of the 11 byte short name long name entries carry */
+func lfnChecksum(short []byte) uint8 {
+	sum := uint8(0)
+	for _, c := range short {
+		sum = (sum&1)<<7 + sum>>1 + c
+	}
+
+	return sum
+}
+
+/* decodeLFN converts a long name to UTF-8, it ends at a NUL or the last unit */
+func decodeLFN(units []uint16) string {
+	for i, u := range units {
+		if u == 0 {
+			units = units[:i]
+			break
+		}
+	}
+
+	return string(utf16.Decode(units))
+}
+
+/* fatTime decodes a date and time, both in local time of whoever wrote them */
+func fatTime(date, tm uint16, centis uint8) time.Time {
+	if date == 0 {
+		return time.Time{}
+	}
+
+	t := time.Date(1980+int(date>>9), time.Month(date>>5&0xf), int(date&0x1f), // nolint:gomnd
+		int(tm>>11), int(tm>>5&0x3f), int(tm&0x1f)*2, 0, time.UTC) // nolint:gomnd
+
+	return t.Add(time.Duration(centis) * 10 * time.Millisecond) // nolint:gomnd
+}
@@ -0,0 +1,358 @@
+package fat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"unicode/utf16"
+
+	"github.com/isi-lincoln/goblkid"
+)
+
+const (
+	EXFAT_OEM_NAME         = "EXFAT   " // nolint:golint,stylecheck
+	EXFAT_BOOT_SECTORS     = 11         // nolint:golint,stylecheck // covered by the boot region checksum
+	EXFAT_BACKUP_BOOT      = 12         // nolint:golint,stylecheck // first sector of the backup boot region
+	EXFAT_MIN_SECTOR_SHIFT = 9          // nolint:golint,stylecheck
+	EXFAT_MAX_SECTOR_SHIFT = 12         // nolint:golint,stylecheck
+	EXFAT_MAX_CLUSTER_BITS = 25         // nolint:golint,stylecheck // clusters are at most 32M
+	EXFAT_MIN_FAT_OFFSET   = 24         // nolint:golint,stylecheck // sectors, past both boot regions
+
+	EXFAT_ENTRY_EOD    = 0x00 // nolint:golint,stylecheck
+	EXFAT_ENTRY_BITMAP = 0x81 // nolint:golint,stylecheck
+	EXFAT_ENTRY_LABEL  = 0x83 // nolint:golint,stylecheck
+	EXFAT_LABEL_MAX    = 11   // nolint:golint,stylecheck // UTF-16 units
+
+	ExFATName = "exfat"
+)
+
+/* exfatSuperBlock is the main boot sector, sector 0 of the boot region */
+type exfatSuperBlock struct {
+	Jump              [3]byte
+	OEMName           [8]byte
+	MustBeZero        [53]byte
+	PartitionOffset   uint64
+	VolumeLength      uint64 /* sectors */
+	FatOffset         uint32 /* sectors */
+	FatLength         uint32 /* sectors */
+	ClusterHeapOffset uint32 /* sectors */
+	ClusterCount      uint32
+	RootCluster       uint32
+	Serno             [4]byte
+	Revision          [2]byte /* minor, major */
+	VolumeFlags       uint16
+	SectorShift       uint8
+	ClusterShift      uint8 /* sectors per cluster, as a shift */
+	Fats              uint8
+	DriveSelect       uint8
+	PercentInUse      uint8
+	Reserved          [7]byte
+	BootCode          [390]byte
+	Pmagic            [2]byte
+}
+
+var ExFATProber = goblkid.Prober{ // nolint: gochecknoglobals
+	Name:      ExFATName,
+	Usage:     goblkid.FilesystemProbe,
+	ProbeFunc: exfatProbe,
+	MagicInfos: []goblkid.MagicInfo{
+		{Magic: EXFAT_OEM_NAME, SuperblockKbOffset: 0, MagicByteOffset: 3}, // nolint:gomnd
+	},
+}
+
+func exfatProbe(info *goblkid.ProbeInfo, magic goblkid.MagicInfo) (bool, error) {
+	sb, err := exfatGetSuperblock(info)
+	if err != nil {
+		return false, ignoreShort(err)
+	}
+
+	if !isExFATValidSuperblock(sb) {
+		return false, nil
+	}
+
+	r := readerAt{info}
+
+	if err := verifyBootChecksum(r, sb); err != nil {
+		return false, ignoreShort(err)
+	}
+
+	info.UUID = goblkid.NewUUID(goblkid.FATSerial, sb.Serno[:])
+	info.Version = fmt.Sprintf("%d.%d", sb.Revision[1], sb.Revision[0])
+	info.FSSize = sb.VolumeLength << sb.SectorShift
+	info.FSBlockSize = uint64(1) << (sb.SectorShift + sb.ClusterShift)
+	info.FSLastBlock = uint64(sb.ClusterCount)
+
+	label, free, err := exfatRootEntries(r, sb)
+	if err != nil {
+		return false, ignoreShort(err)
+	}
+
+	info.Label = label
+	info.FreeBlocks = free
+
+	return true, nil
+}
+
+func exfatGetSuperblock(info *goblkid.ProbeInfo) (*exfatSuperBlock, error) {
+	if _, err := info.DeviceReader.Seek(info.Offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	sb := &exfatSuperBlock{}
+	if err := binary.Read(info.DeviceReader, binary.LittleEndian, sb); err != nil {
+		return nil, err
+	}
+
+	return sb, nil
+}
+
+/* isExFATValidSuperblock applies the checks of the exFAT specification and the kernel */
+func isExFATValidSuperblock(sb *exfatSuperBlock) bool {
+	if sb.Jump != [3]byte{0xeb, 0x76, 0x90} || string(sb.OEMName[:]) != EXFAT_OEM_NAME {
+		return false
+	}
+
+	if sb.Pmagic[0] != 0x55 || sb.Pmagic[1] != 0xAA {
+		return false
+	}
+
+	/* where the BPB of FAT would be, keeping FAT implementations away */
+	for _, b := range sb.MustBeZero {
+		if b != 0 {
+			return false
+		}
+	}
+
+	if sb.SectorShift < EXFAT_MIN_SECTOR_SHIFT || sb.SectorShift > EXFAT_MAX_SECTOR_SHIFT ||
+		int(sb.SectorShift)+int(sb.ClusterShift) > EXFAT_MAX_CLUSTER_BITS {
+		return false
+	}
+
+	if sb.Fats != 1 && sb.Fats != 2 {
+		return false
+	}
+
+	if sb.FatOffset < EXFAT_MIN_FAT_OFFSET || sb.FatLength == 0 ||
+		uint64(sb.ClusterHeapOffset) < uint64(sb.FatOffset)+uint64(sb.FatLength)*uint64(sb.Fats) {
+		return false
+	}
+
+	return sb.ClusterCount != 0 &&
+		sb.RootCluster >= FAT_CLUSTER_FIRST && sb.RootCluster < sb.ClusterCount+FAT_CLUSTER_FIRST
+}
+
+/*
+ * verifyBootChecksum checks the main boot region against the checksum
+ * sector following it, which repeats the checksum to fill the sector. The
+ * volume flags and the percentage in use change without updating it.
+ */
+func verifyBootChecksum(r io.ReaderAt, sb *exfatSuperBlock) error {
+	sectorSize := 1 << sb.SectorShift
+
+	buf := make([]byte, (EXFAT_BOOT_SECTORS+1)*sectorSize)
+	if _, err := r.ReadAt(buf, 0); err != nil {
+		return err
+	}
+
+	csum := exfatBootChecksum(buf[:EXFAT_BOOT_SECTORS*sectorSize])
+
+	sector := buf[EXFAT_BOOT_SECTORS*sectorSize:]
+	for off := 0; off < len(sector); off += 4 {
+		if stored := binary.LittleEndian.Uint32(sector[off:]); stored != csum {
+			return fmt.Errorf("exfat boot region checksum %08x != %08x: %w", stored, csum, goblkid.ErrCorrupt)
+		}
+	}
+
+	return nil
+}
+
+func exfatBootChecksum(region []byte) uint32 {
+	csum := uint32(0)
+
+	for i, b := range region {
+		if i == 106 || i == 107 || i == 112 { // nolint:gomnd // VolumeFlags, PercentInUse
+			continue
+		}
+
+		csum = bits.RotateLeft32(csum, -1) + uint32(b)
+	}
+
+	return csum
+}
+
+/*
+ * exfatRootEntries walks the root directory for the volume label and the
+ * allocation bitmap, returning the label and the free clusters the bitmap
+ * counts. A label entry holds up to 11 UTF-16 units.
+ */
+func exfatRootEntries(r io.ReaderAt, sb *exfatSuperBlock) (string, uint64, error) {
+	clusterSize := int64(1) << (sb.SectorShift + sb.ClusterShift)
+	buf := make([]byte, clusterSize)
+
+	label, free := "", uint64(0)
+	labelFound, bitmapFound := false, false
+
+	err := exfatChain(r, sb, sb.RootCluster, func(cluster uint32) (bool, error) {
+		if _, err := r.ReadAt(buf, exfatClusterOffset(sb, cluster)); err != nil {
+			return false, err
+		}
+
+		for off := 0; off < len(buf); off += FAT_DIR_ENTRY_SIZE {
+			e := buf[off : off+FAT_DIR_ENTRY_SIZE]
+
+			switch e[0] {
+			case EXFAT_ENTRY_EOD:
+				return false, nil
+			case EXFAT_ENTRY_LABEL:
+				if labelFound {
+					continue
+				}
+
+				n := int(e[1])
+				if n > EXFAT_LABEL_MAX {
+					n = EXFAT_LABEL_MAX
+				}
+
+				units := make([]uint16, n)
+				for i := range units {
+					units[i] = binary.LittleEndian.Uint16(e[2+2*i:])
+				}
+
+				label, labelFound = string(utf16.Decode(units)), true
+			case EXFAT_ENTRY_BITMAP:
+				/* the second bitmap, flag bit 0, belongs to the second FAT */
+				if bitmapFound || e[1]&1 != 0 {
+					continue
+				}
+
+				var err error
+				if free, err = exfatFreeClusters(r, sb, binary.LittleEndian.Uint32(e[20:])); err != nil {
+					return false, err
+				}
+
+				bitmapFound = true
+			}
+
+			if labelFound && bitmapFound {
+				return false, nil
+			}
+		}
+
+		return true, nil
+	})
+
+	return label, free, err
+}
+
+/* exfatFreeClusters counts the clear bits of the allocation bitmap starting at cluster first */
+func exfatFreeClusters(r io.ReaderAt, sb *exfatSuperBlock, first uint32) (uint64, error) {
+	clusterSize := int64(1) << (sb.SectorShift + sb.ClusterShift)
+	buf := make([]byte, clusterSize)
+	left := uint64(sb.ClusterCount)
+	free := uint64(0)
+
+	err := exfatChain(r, sb, first, func(cluster uint32) (bool, error) {
+		if _, err := r.ReadAt(buf, exfatClusterOffset(sb, cluster)); err != nil {
+			return false, err
+		}
+
+		for _, b := range buf {
+			n := uint64(8) // nolint:gomnd
+			if left < n {
+				n = left
+				b |= 0xff << n
+			}
+
+			free += uint64(bits.OnesCount8(^b))
+			left -= n
+
+			if left == 0 {
+				return false, nil
+			}
+		}
+
+		return true, nil
+	})
+
+	return free, err
+}
+
+/*
+ * exfatChain calls fn for each cluster of the chain starting at first for as
+ * long as fn asks for more, stopping at the end of the chain or a cluster
+ * out of range. The chain is bounded by the cluster count, which a loop in
+ * the FAT would otherwise never reach.
+ */
+func exfatChain(r io.ReaderAt, sb *exfatSuperBlock, first uint32, fn func(uint32) (bool, error)) error {
+	entry := make([]byte, 4) // nolint:gomnd
+	fat := int64(sb.FatOffset) << sb.SectorShift
+
+	cluster := first
+	for n := uint32(0); n < sb.ClusterCount; n++ {
+		if cluster < FAT_CLUSTER_FIRST || cluster >= sb.ClusterCount+FAT_CLUSTER_FIRST {
+			return nil
+		}
+
+		more, err := fn(cluster)
+		if err != nil || !more {
+			return err
+		}
+
+		if _, err := r.ReadAt(entry, fat+int64(cluster)*4); err != nil { // nolint:gomnd
+			return err
+		}
+
+		cluster = binary.LittleEndian.Uint32(entry)
+	}
+
+	return nil
+}
+
+func exfatClusterOffset(sb *exfatSuperBlock, cluster uint32) int64 {
+	return int64(sb.ClusterHeapOffset)<<sb.SectorShift +
+		int64(cluster-FAT_CLUSTER_FIRST)<<(sb.SectorShift+sb.ClusterShift)
+}
+
+/* isExFATBootSector reports whether the 512 byte buffer holds an exFAT main boot sector */
+func isExFATBootSector(buf []byte) bool {
+	sb := &exfatSuperBlock{}
+	if err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, sb); err != nil {
+		return false
+	}
+
+	return isExFATValidSuperblock(sb)
+}
+
+/* exfatSignatureExtents is SignatureExtents for exFAT, the OEM name in each boot region */
+func exfatSignatureExtents(info *goblkid.ProbeInfo, backup bool) ([]Extent, error) {
+	sb, err := exfatGetSuperblock(info)
+	if err != nil {
+		return nil, err
+	}
+
+	magic := ExFATProber.MagicInfos[0]
+	extents := []Extent{{
+		int64(magic.MagicByteOffset), int64(len(magic.Magic)),
+		fmt.Sprintf("boot sector magic % x", magic.Magic),
+	}}
+
+	if !backup {
+		return extents, nil
+	}
+
+	base := int64(EXFAT_BACKUP_BOOT) << sb.SectorShift
+	at := *info
+	at.Offset = info.Offset + base
+
+	ok, err := magic.Match(&at)
+	if err != nil || !ok {
+		return extents, ignoreShort(err)
+	}
+
+	return append(extents, Extent{
+		base + int64(magic.MagicByteOffset), int64(len(magic.Magic)),
+		fmt.Sprintf("backup boot sector magic % x", magic.Magic),
+	}), nil
+}
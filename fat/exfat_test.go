@@ -0,0 +1,215 @@
+package fat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/isi-lincoln/goblkid"
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+ * exfatImage is an 8M exFAT volume laid out as mkfs.exfat would: 512 byte
+ * sectors, 4K clusters, the allocation bitmap in cluster 2, the upcase
+ * table in cluster 3 and the root directory from cluster 4.
+ */
+type exfatImage struct {
+	data []byte
+}
+
+const (
+	exfatClusters = 2016
+	exfatHeap     = 256
+)
+
+func newExFATImage() *exfatImage {
+	f := &exfatImage{data: make([]byte, 8<<20)}
+	b := f.data
+
+	b[0], b[1], b[2] = 0xeb, 0x76, 0x90
+	copy(b[3:], EXFAT_OEM_NAME)
+	binary.LittleEndian.PutUint64(b[0x48:], uint64(len(b)/512))
+	binary.LittleEndian.PutUint32(b[0x50:], 128)
+	binary.LittleEndian.PutUint32(b[0x54:], 64)
+	binary.LittleEndian.PutUint32(b[0x58:], exfatHeap)
+	binary.LittleEndian.PutUint32(b[0x5c:], exfatClusters)
+	binary.LittleEndian.PutUint32(b[0x60:], 4)
+	copy(b[0x64:], []byte{0xcd, 0xab, 0x34, 0x12})
+	b[0x68], b[0x69] = 0, 1
+	b[0x6c], b[0x6d], b[0x6e] = 9, 3, 1
+	b[0x70] = 0xff
+	b[0x1fe], b[0x1ff] = 0x55, 0xaa
+
+	/* the extended boot sectors end in a signature too */
+	for s := 1; s <= 8; s++ {
+		b[s*512+510], b[s*512+511] = 0x55, 0xaa
+	}
+
+	f.setFAT(0, 0xfffffff8)
+	f.setFAT(1, 0xffffffff)
+
+	for c := uint32(2); c <= 4; c++ {
+		f.setFAT(c, 0xffffffff)
+	}
+
+	/* clusters 2 to 4 in use */
+	f.cluster(2)[0] = 0x07
+
+	root := f.cluster(4)
+	root[0], root[1] = EXFAT_ENTRY_BITMAP, 0
+	binary.LittleEndian.PutUint32(root[20:], 2)
+	binary.LittleEndian.PutUint64(root[24:], (exfatClusters+7)/8)
+	root[32] = 0x82 /* upcase table */
+	binary.LittleEndian.PutUint32(root[32+20:], 3)
+
+	f.seal()
+
+	return f
+}
+
+func (f *exfatImage) setFAT(cluster, value uint32) {
+	binary.LittleEndian.PutUint32(f.data[128*512+int(cluster)*4:], value)
+}
+
+func (f *exfatImage) cluster(c uint32) []byte {
+	off := exfatHeap*512 + int(c-2)*4096
+	return f.data[off : off+4096]
+}
+
+/* setLabel writes the label entry after the bitmap and upcase entries */
+func (f *exfatImage) setLabel(label string) {
+	e := f.cluster(4)[64:96]
+	units := utf16.Encode([]rune(label))
+
+	e[0], e[1] = EXFAT_ENTRY_LABEL, uint8(len(units))
+	for i, u := range units {
+		binary.LittleEndian.PutUint16(e[2+2*i:], u)
+	}
+}
+
+/* seal fills in the checksum sector and copies the boot region to its backup */
+func (f *exfatImage) seal() {
+	csum := exfatBootChecksum(f.data[:11*512])
+
+	for off := 11 * 512; off < 12*512; off += 4 {
+		binary.LittleEndian.PutUint32(f.data[off:], csum)
+	}
+
+	copy(f.data[12*512:], f.data[:12*512])
+}
+
+func (f *exfatImage) probe() (*goblkid.ProbeInfo, bool, error) {
+	info := &goblkid.ProbeInfo{DeviceReader: bytes.NewReader(f.data), Size: int64(len(f.data))}
+	ok, err := Chain.Probe(info)
+
+	return info, ok, err
+}
+
+func TestExFAT(t *testing.T) {
+	f := newExFATImage()
+
+	info, ok, err := f.probe()
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, ExFATName, info.ProbeName)
+	assert.Equal(t, "", info.Label)
+	assert.Equal(t, "1234-ABCD", info.UUID.String())
+	assert.Equal(t, "1.0", info.Version)
+	assert.Equal(t, uint64(8<<20), info.FSSize)
+	assert.Equal(t, uint64(4096), info.FSBlockSize)
+	assert.Equal(t, uint64(exfatClusters), info.FSLastBlock)
+	assert.Equal(t, uint64(exfatClusters-3), info.FreeBlocks)
+
+	f.setLabel("Ünïcødé SD")
+	info, ok, err = f.probe()
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "Ünïcødé SD", info.Label)
+
+	/* a deleted label entry */
+	f.cluster(4)[64] &^= 0x80
+	info, _, err = f.probe()
+	assert.Nil(t, err)
+	assert.Equal(t, "", info.Label)
+}
+
+func TestExFATRootChain(t *testing.T) {
+	f := newExFATImage()
+
+	/* the root directory fills cluster 4 and goes on in cluster 9 */
+	root := f.cluster(4)
+	for off := 64; off < len(root); off += 32 {
+		root[off] = 0x85 /* file entries */
+	}
+
+	f.setFAT(4, 9)
+	f.setFAT(9, 0xffffffff)
+
+	label := f.cluster(9)
+	label[0], label[1] = EXFAT_ENTRY_LABEL, 4
+	copy(label[2:], []byte{'C', 0, 'A', 0, 'R', 0, 'D', 0})
+
+	info, ok, err := f.probe()
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "CARD", info.Label)
+
+	/* a loop in the FAT ends the walk */
+	f.setFAT(9, 4)
+	info, ok, err = f.probe()
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "CARD", info.Label)
+}
+
+func TestExFATChecksum(t *testing.T) {
+	f := newExFATImage()
+
+	/* the volume flags and percentage in use are left out */
+	f.data[106] |= 0x02
+	f.data[112] = 40
+	_, ok, err := f.probe()
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	f.data[2*512] = 1
+	_, ok, err = f.probe()
+	assert.False(t, ok)
+	assert.True(t, errors.Is(err, goblkid.ErrCorrupt))
+}
+
+func TestExFATNotFAT(t *testing.T) {
+	f := newExFATImage()
+	info := &goblkid.ProbeInfo{DeviceReader: bytes.NewReader(f.data), Size: int64(len(f.data))}
+
+	ok, err := FATProber.Probe(info)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.True(t, IsBootSector(f.data[:512]))
+
+	/* and the other way round */
+	v := newFATImage(32)
+	info = &goblkid.ProbeInfo{DeviceReader: bytes.NewReader(v.data), Size: int64(len(v.data))}
+
+	ok, err = ExFATProber.Probe(info)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func TestExFATSignatureExtents(t *testing.T) {
+	f := newExFATImage()
+	info, _, err := f.probe()
+	assert.Nil(t, err)
+
+	extents, err := SignatureExtents(info, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{3}, offsets(extents))
+
+	extents, err = SignatureExtents(info, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{3, 12*512 + 3}, offsets(extents))
+	assert.Equal(t, int64(8), extents[1].Length)
+}
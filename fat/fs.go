@@ -0,0 +1,426 @@
+package fat
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/isi-lincoln/goblkid"
+)
+
+// FS is a read-only view of the files of a FAT12, FAT16 or FAT32 volume.
+// Names are matched ignoring case as FAT does, short names are decoded with
+// the code page the volume was opened with, and timestamps, which FAT keeps
+// in local time, are reported as UTC.
+type FS struct {
+	r   io.ReaderAt
+	g   *geometry
+	fat []uint32 /* the first FAT, decoded */
+	cp  *CodePage
+}
+
+var (
+	_ fs.ReadDirFS  = (*FS)(nil)
+	_ fs.ReadFileFS = (*FS)(nil)
+	_ fs.StatFS     = (*FS)(nil)
+)
+
+// Stat is what the Sys method of the FileInfo of a FAT file returns
+type Stat struct {
+	ShortName string
+	Attr      uint8
+	Cluster   uint32 /* first cluster, 0 for empty files */
+	Created   time.Time
+	Accessed  time.Time /* a date only */
+}
+
+// Open reads the boot sector and the FAT of the volume in r and returns a
+// read-only fs.FS over its files, decoding short names with CP437
+func Open(r io.ReaderAt) (*FS, error) {
+	return OpenWithCodePage(r, nil)
+}
+
+// OpenWithCodePage is Open decoding short names with cp, CP437 when nil
+func OpenWithCodePage(r io.ReaderAt, cp *CodePage) (*FS, error) {
+	info := &goblkid.ProbeInfo{DeviceReader: io.NewSectionReader(r, 0, 1<<63-1)}
+
+	g, err := readGeometry(info)
+	if err != nil {
+		return nil, err
+	}
+
+	if g.clusterSize == 0 || g.clusters == 0 {
+		return nil, fmt.Errorf("bad geometry: %w", goblkid.ErrCorrupt)
+	}
+
+	fat, err := g.readFAT(r, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(fat) < int(g.clusters)+FAT_CLUSTER_FIRST {
+		return nil, fmt.Errorf("FAT shorter than the %d clusters: %w", g.clusters, goblkid.ErrCorrupt)
+	}
+
+	return &FS{r: r, g: g, fat: fat, cp: orCP437(cp)}, nil
+}
+
+// Open opens the named file
+func (f *FS) Open(name string) (fs.File, error) {
+	ent, err := f.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	st := f.stat(path.Base(name), ent)
+
+	if ent.isDir() {
+		return &dir{f: f, ent: ent, st: st}, nil
+	}
+
+	r, err := f.content(ent)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return &file{st: st, sr: io.NewSectionReader(r, 0, int64(ent.size))}, nil
+}
+
+// ReadFile reads the named file
+func (f *FS) ReadFile(name string) ([]byte, error) {
+	file, err := f.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if _, ok := file.(*dir); ok {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errors.New("is a directory")}
+	}
+
+	return io.ReadAll(file)
+}
+
+// ReadDir reads the named directory, sorted by file name
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	ent, err := f.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &dir{f: f, ent: ent, st: f.stat(path.Base(name), ent)}
+
+	entries, err := d.ReadDir(-1)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+
+	return entries, nil
+}
+
+// Stat describes the named file
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	ent, err := f.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.stat(path.Base(name), ent), nil
+}
+
+/* root is the entry the root directory would have, FAT12/16 keep it outside the clusters */
+func (f *FS) root() *dirent {
+	return &dirent{name: ".", short: ".", attr: FAT_ATTR_DIR, cluster: f.g.rootCluster}
+}
+
+/* lookup walks name from the root */
+func (f *FS) lookup(op, name string) (*dirent, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	cur := f.root()
+	if name == "." {
+		return cur, nil
+	}
+
+	for _, elem := range strings.Split(name, "/") {
+		if !cur.isDir() {
+			return nil, &fs.PathError{Op: op, Path: name, Err: errors.New("not a directory")}
+		}
+
+		entries, err := f.entries(cur)
+		if err != nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+
+		var next *dirent
+
+		for _, e := range entries {
+			if !e.dot() && (strings.EqualFold(e.name, elem) || strings.EqualFold(e.short, elem)) {
+				next = e
+				break
+			}
+		}
+
+		if next == nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+
+		cur = next
+	}
+
+	return cur, nil
+}
+
+/* entries reads and decodes the directory ent, . and .. included */
+func (f *FS) entries(ent *dirent) ([]*dirent, error) {
+	if ent.short == "." && f.g.bits != 32 {
+		buf := make([]byte, int64(f.g.rootEntries)*FAT_DIR_ENTRY_SIZE)
+		if err := f.readAt(buf, f.g.rootOffset); err != nil {
+			return nil, err
+		}
+
+		return parseDirents(buf, f.cp, f.g.bits), nil
+	}
+
+	clusters, err := f.chain(ent.cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := f.readClusters(clusters)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseDirents(buf, f.cp, f.g.bits), nil
+}
+
+/* readClusters reads clusters one after the other into a single buffer */
+func (f *FS) readClusters(clusters []uint32) ([]byte, error) {
+	buf := make([]byte, int64(len(clusters))*f.g.clusterSize)
+
+	for i, c := range clusters {
+		if err := f.readAt(buf[int64(i)*f.g.clusterSize:int64(i+1)*f.g.clusterSize], f.g.clusterOffset(c)); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf, nil
+}
+
+/* chain follows the FAT from cluster first to the end of its chain */
+func (f *FS) chain(first uint32) ([]uint32, error) {
+	clusters := []uint32{}
+
+	for c := first; ; c = f.fat[c] {
+		if !f.g.validCluster(c) {
+			return nil, fmt.Errorf("cluster %d in the chain of %d out of range: %w", c, first, goblkid.ErrCorrupt)
+		}
+
+		if uint32(len(clusters)) >= f.g.clusters {
+			return nil, fmt.Errorf("cluster chain of %d loops: %w", first, goblkid.ErrCorrupt)
+		}
+
+		clusters = append(clusters, c)
+
+		if f.g.eoc(f.fat[c]) {
+			return clusters, nil
+		}
+	}
+}
+
+/* content reads the clusters of a file */
+func (f *FS) content(ent *dirent) (io.ReaderAt, error) {
+	if ent.size == 0 {
+		return &data{f: f}, nil
+	}
+
+	clusters, err := f.chain(ent.cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(clusters))*f.g.clusterSize < int64(ent.size) {
+		return nil, fmt.Errorf("%d clusters cannot hold %d bytes: %w", len(clusters), ent.size, goblkid.ErrCorrupt)
+	}
+
+	return &data{f: f, clusters: clusters, size: int64(ent.size)}, nil
+}
+
+/* data reads a file through its cluster chain */
+type data struct {
+	f        *FS
+	clusters []uint32
+	size     int64
+}
+
+func (d *data) ReadAt(p []byte, off int64) (int, error) {
+	if off >= d.size {
+		return 0, io.EOF
+	}
+
+	cs := d.f.g.clusterSize
+	n := 0
+
+	for n < len(p) && off < d.size {
+		within := off % cs
+
+		chunk := int64(len(p) - n)
+		if rest := cs - within; chunk > rest {
+			chunk = rest
+		}
+
+		if rest := d.size - off; chunk > rest {
+			chunk = rest
+		}
+
+		c := d.clusters[off/cs]
+		if err := d.f.readAt(p[n:n+int(chunk)], d.f.g.clusterOffset(c)+within); err != nil {
+			return n, err
+		}
+
+		n += int(chunk)
+		off += chunk
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (f *FS) readAt(buf []byte, off int64) error {
+	n, err := f.r.ReadAt(buf, off)
+	if n == len(buf) {
+		return nil
+	}
+
+	if err == nil || errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+func (f *FS) stat(name string, ent *dirent) *fileInfo {
+	return &fileInfo{name: name, ent: ent}
+}
+
+/* fileInfo implements fs.FileInfo, Sys returns a *Stat */
+type fileInfo struct {
+	name string
+	ent  *dirent
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return int64(fi.ent.size) }
+func (fi *fileInfo) ModTime() time.Time { return fi.ent.modified }
+func (fi *fileInfo) IsDir() bool        { return fi.ent.isDir() }
+
+func (fi *fileInfo) Mode() fs.FileMode {
+	mode := fs.FileMode(0644) // nolint:gomnd
+	if fi.ent.isDir() {
+		mode = fs.ModeDir | 0755 // nolint:gomnd
+	}
+
+	if fi.ent.attr&FAT_ATTR_READ_ONLY != 0 {
+		mode &^= 0222 // nolint:gomnd
+	}
+
+	return mode
+}
+
+func (fi *fileInfo) Sys() interface{} {
+	return &Stat{
+		ShortName: fi.ent.short,
+		Attr:      fi.ent.attr,
+		Cluster:   fi.ent.cluster,
+		Created:   fi.ent.created,
+		Accessed:  fi.ent.accessed,
+	}
+}
+
+/* file is an open regular file */
+type file struct {
+	st *fileInfo
+	sr *io.SectionReader
+}
+
+func (fl *file) Stat() (fs.FileInfo, error)                { return fl.st, nil }
+func (fl *file) Read(p []byte) (int, error)                { return fl.sr.Read(p) }
+func (fl *file) ReadAt(p []byte, off int64) (int, error)   { return fl.sr.ReadAt(p, off) }
+func (fl *file) Seek(off int64, whence int) (int64, error) { return fl.sr.Seek(off, whence) }
+func (fl *file) Close() error                              { return nil }
+
+/* dir is an open directory, its entries are read on the first ReadDir */
+type dir struct {
+	f       *FS
+	ent     *dirent
+	st      *fileInfo
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) { return d.st, nil }
+func (d *dir) Close() error               { return nil }
+
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.st.name, Err: errors.New("is a directory")}
+}
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		d.read = true
+
+		entries, err := d.f.entries(d.ent)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range entries {
+			if !e.dot() {
+				d.entries = append(d.entries, &dirEntry{d.f.stat(e.name, e)})
+			}
+		}
+
+		sort.Slice(d.entries, func(i, j int) bool { return d.entries[i].Name() < d.entries[j].Name() })
+	}
+
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+
+		return entries, nil
+	}
+
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+
+	return entries, nil
+}
+
+/* dirEntry has everything at hand, FAT keeps it all in the directory */
+type dirEntry struct {
+	st *fileInfo
+}
+
+func (e *dirEntry) Name() string               { return e.st.name }
+func (e *dirEntry) IsDir() bool                { return e.st.IsDir() }
+func (e *dirEntry) Type() fs.FileMode          { return e.st.Mode().Type() }
+func (e *dirEntry) Info() (fs.FileInfo, error) { return e.st, nil }
@@ -0,0 +1,479 @@
+package fat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+	"unicode/utf16"
+
+	"github.com/isi-lincoln/goblkid"
+	"github.com/stretchr/testify/assert"
+)
+
+/* every entry the tests write carries this time, FAT keeps 2 second steps */
+var fixtureTime = time.Date(2021, 3, 4, 5, 6, 8, 0, time.UTC) // nolint:gochecknoglobals
+
+/* fatNode is a file or directory the tests lay out on an image */
+type fatNode struct {
+	name       string
+	data       []byte
+	dir        bool
+	fragmented bool
+	children   []*fatNode
+	cluster    uint32
+}
+
+/*
+ * espTree is an EFI system partition with the odd names FAT allows: long
+ * names of exactly one and of several entries, spaces and non-BMP runes
+ */
+func espTree() map[string][]byte {
+	rnd := rand.New(rand.NewSource(1)) // nolint:gosec
+	efi := make([]byte, 20<<10)
+	rnd.Read(efi)
+
+	files := map[string][]byte{
+		"EFI/BOOT/BOOTX64.EFI":           efi,
+		"EFI/BOOT/grub.cfg":              []byte("set default=0\nset timeout=5\n"),
+		"loader/loader.conf":             []byte("default arch.conf\n"),
+		"loader/entries/arch-linux.conf": []byte("title Arch Linux\nlinux /vmlinuz-linux\n"),
+		"README.TXT":                     []byte("plain 8.3 name\n"),
+		"Ünïcødé 名前 🎉.txt":               []byte("utf-16 long name\n"),
+		"a b c":                          []byte("spaces"),
+		"empty":                          {},
+		"thirteen char":                  []byte("one long name entry without a terminator"),
+		"twenty-seven characters long":   []byte("three long name entries"),
+	}
+
+	for i := 0; i < 60; i++ {
+		files[fmt.Sprintf("many/file with a long name %03d", i)] = []byte(fmt.Sprint(i))
+	}
+
+	return files
+}
+
+/* writeTree lays files out on the image the way mkfs.fat and mcopy would */
+func (f *fatImage) writeTree(files map[string][]byte) {
+	root := &fatNode{dir: true}
+	names := make([]string, 0, len(files))
+
+	for name := range files {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		cur := root
+
+		parts := strings.Split(name, "/")
+		for _, p := range parts[:len(parts)-1] {
+			var next *fatNode
+
+			for _, c := range cur.children {
+				if c.name == p {
+					next = c
+				}
+			}
+
+			if next == nil {
+				next = &fatNode{name: p, dir: true}
+				cur.children = append(cur.children, next)
+			}
+
+			cur = next
+		}
+
+		cur.children = append(cur.children, &fatNode{
+			name: parts[len(parts)-1], data: files[name], fragmented: len(files[name]) > 8<<10,
+		})
+	}
+
+	f.placeRoot(root)
+}
+
+func (f *fatImage) clusterBytes() int {
+	return f.clusterSize * f.sectorSize
+}
+
+func (f *fatImage) clusterOffset(c uint32) int {
+	data := f.rootOffset() + f.rootEntries*32
+	return data + int(c-2)*f.clusterBytes()
+}
+
+/* alloc hands out n clusters, leaving a free one between them when fragmented */
+func (f *fatImage) alloc(n int, fragmented bool) []uint32 {
+	if f.next == 0 {
+		f.next = 2
+		if f.bits == 32 {
+			f.next = 3
+		}
+	}
+
+	clusters := []uint32{}
+
+	for i := 0; i < n; i++ {
+		clusters = append(clusters, f.next)
+		f.next++
+
+		if fragmented {
+			f.next++
+		}
+	}
+
+	return clusters
+}
+
+/* writeChain links clusters in the FAT and fills them with data */
+func (f *fatImage) writeChain(clusters []uint32, data []byte) {
+	for i, c := range clusters {
+		next := uint32(0x0fffffff)
+		if i+1 < len(clusters) {
+			next = clusters[i+1]
+		}
+
+		f.setFAT(c, next)
+
+		chunk := data[i*f.clusterBytes():]
+		if len(chunk) > f.clusterBytes() {
+			chunk = chunk[:f.clusterBytes()]
+		}
+
+		copy(f.data[f.clusterOffset(c):], chunk)
+	}
+}
+
+func (f *fatImage) placeRoot(root *fatNode) {
+	size := dirSize(root, false)
+
+	for _, c := range root.children {
+		f.place(c, 0)
+	}
+
+	buf := f.dirBytes(root, 0, 0, false)
+
+	if f.bits != 32 {
+		copy(f.data[f.rootOffset():], buf)
+		return
+	}
+
+	clusters := append([]uint32{2}, f.alloc((size-1)/f.clusterBytes(), false)...)
+	f.writeChain(clusters, buf)
+}
+
+func (f *fatImage) place(n *fatNode, parent uint32) {
+	if !n.dir {
+		if len(n.data) == 0 {
+			return
+		}
+
+		clusters := f.alloc((len(n.data)+f.clusterBytes()-1)/f.clusterBytes(), n.fragmented)
+		n.cluster = clusters[0]
+		f.writeChain(clusters, n.data)
+
+		return
+	}
+
+	clusters := f.alloc((dirSize(n, true)+f.clusterBytes()-1)/f.clusterBytes(), false)
+	n.cluster = clusters[0]
+
+	for _, c := range n.children {
+		f.place(c, n.cluster)
+	}
+
+	f.writeChain(clusters, f.dirBytes(n, n.cluster, parent, true))
+}
+
+var shortRE = regexp.MustCompile(`^[A-Z0-9_]{1,8}(\.[A-Z0-9_]{1,3})?$`) // nolint:gochecknoglobals
+
+/* dirSize is the bytes of a directory with its end marker */
+func dirSize(n *fatNode, dots bool) int {
+	entries := 1
+	if dots {
+		entries += 2
+	}
+
+	for _, c := range n.children {
+		entries++
+		if !shortRE.MatchString(c.name) {
+			entries += (len(utf16.Encode([]rune(c.name))) + FAT_LFN_CHARS - 1) / FAT_LFN_CHARS
+		}
+	}
+
+	return entries * 32
+}
+
+func (f *fatImage) dirBytes(n *fatNode, self, parent uint32, dots bool) []byte {
+	buf := []byte{}
+
+	if dots {
+		buf = append(buf, shortEntry(".", FAT_ATTR_DIR, self, 0)...)
+		buf = append(buf, shortEntry("..", FAT_ATTR_DIR, parent, 0)...)
+	}
+
+	for i, c := range n.children {
+		attr := uint8(FAT_ATTR_ARCHIVE)
+		if c.dir {
+			attr = FAT_ATTR_DIR
+		}
+
+		short := strings.ToUpper(c.name)
+		if !shortRE.MatchString(c.name) {
+			short = fmt.Sprintf("LONG~%d", i+1)
+			buf = append(buf, lfnEntries(c.name, short)...)
+		}
+
+		buf = append(buf, shortEntry(short, attr, c.cluster, uint32(len(c.data)))...)
+	}
+
+	return append(buf, make([]byte, 32)...)
+}
+
+/* rawShort pads a NAME.EXT name out to the 11 bytes of a directory entry */
+func rawShort(name string) []byte {
+	raw := []byte("           ")
+
+	if name == "." || name == ".." {
+		copy(raw, name)
+		return raw
+	}
+
+	base, ext := name, ""
+	if i := strings.LastIndex(name, "."); i > 0 {
+		base, ext = name[:i], name[i+1:]
+	}
+
+	copy(raw, base)
+	copy(raw[8:], ext)
+
+	return raw
+}
+
+func shortEntry(name string, attr uint8, cluster, size uint32) []byte {
+	e := make([]byte, 32)
+	copy(e, rawShort(name))
+	e[11] = attr
+
+	date := uint16(fixtureTime.Year()-1980)<<9 | uint16(fixtureTime.Month())<<5 | uint16(fixtureTime.Day())
+	tm := uint16(fixtureTime.Hour())<<11 | uint16(fixtureTime.Minute())<<5 | uint16(fixtureTime.Second()/2)
+
+	e[13] = 100 /* one second past the 2 second step */
+	binary.LittleEndian.PutUint16(e[14:], tm)
+	binary.LittleEndian.PutUint16(e[16:], date)
+	binary.LittleEndian.PutUint16(e[18:], date)
+	binary.LittleEndian.PutUint16(e[20:], uint16(cluster>>16))
+	binary.LittleEndian.PutUint16(e[22:], tm)
+	binary.LittleEndian.PutUint16(e[24:], date)
+	binary.LittleEndian.PutUint16(e[26:], uint16(cluster))
+	binary.LittleEndian.PutUint32(e[28:], size)
+
+	return e
+}
+
+/* lfnEntries encodes a long name in the order the entries sit on disk */
+func lfnEntries(name, short string) []byte {
+	units := utf16.Encode([]rune(name))
+	count := (len(units) + FAT_LFN_CHARS - 1) / FAT_LFN_CHARS
+
+	if len(units)%FAT_LFN_CHARS != 0 {
+		units = append(units, 0)
+	}
+
+	for len(units)%FAT_LFN_CHARS != 0 {
+		units = append(units, 0xffff)
+	}
+
+	sum := lfnChecksum(rawShort(short))
+	buf := []byte{}
+
+	for ord := count; ord > 0; ord-- {
+		e := make([]byte, 32)
+		e[0] = uint8(ord)
+
+		if ord == count {
+			e[0] |= FAT_LFN_LAST
+		}
+
+		e[11] = FAT_ATTR_LONG_NAME
+		e[13] = sum
+
+		for i, o := range lfnOffsets {
+			binary.LittleEndian.PutUint16(e[o:], units[(ord-1)*FAT_LFN_CHARS+i])
+		}
+
+		buf = append(buf, e...)
+	}
+
+	return buf
+}
+
+func TestFS(t *testing.T) {
+	files := espTree()
+	names := make([]string, 0, len(files))
+
+	for name := range files {
+		names = append(names, name)
+	}
+
+	for _, bits := range []int{12, 16, 32} {
+		f := newFATImage(bits)
+		f.writeTree(files)
+
+		fsys, err := Open(bytes.NewReader(f.data))
+		assert.Nil(t, err)
+
+		for name, data := range files {
+			got, err := fsys.ReadFile(name)
+			assert.Nil(t, err, "FAT%d %s", bits, name)
+			assert.Equal(t, data, got, "FAT%d %s", bits, name)
+		}
+
+		assert.Nil(t, fstest.TestFS(fsys, names...), "FAT%d", bits)
+
+		entries, err := fsys.ReadDir("many")
+		assert.Nil(t, err)
+		assert.Len(t, entries, 60)
+		assert.Equal(t, "file with a long name 000", entries[0].Name())
+	}
+}
+
+func TestLFNChecksum(t *testing.T) {
+	/* rotate right and add, as in the Microsoft FAT specification */
+	for raw, sum := range map[string]uint8{
+		"README  TXT":    0x73,
+		"LONG~1     ":    0x54,
+		"FOO     BAR":    0x53,
+		"           ":    0xf7,
+		"\x05BC     TXT": 0xc2, /* 0xe5 escaped, the checksum covers the bytes on disk */
+	} {
+		assert.Equal(t, sum, lfnChecksum([]byte(raw)), "%q", raw)
+	}
+
+	/* a long name whose checksum does not match its short entry is an orphan */
+	f := newFATImage(16)
+	lfn := lfnEntries("a long name", "LONG~1")
+	lfn[13]++
+	copy(f.data[f.rootOffset():], append(lfn, shortEntry("LONG~1", FAT_ATTR_ARCHIVE, 0, 0)...))
+
+	fsys, err := Open(bytes.NewReader(f.data))
+	assert.Nil(t, err)
+
+	entries, err := fsys.ReadDir(".")
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "LONG~1", entries[0].Name())
+}
+
+func TestFSLookup(t *testing.T) {
+	f := newFATImage(32)
+	f.writeTree(espTree())
+
+	fsys, err := Open(bytes.NewReader(f.data))
+	assert.Nil(t, err)
+
+	/* FAT ignores case, and the short alias of a long name works too */
+	data, err := fsys.ReadFile("efi/boot/GRUB.CFG")
+	assert.Nil(t, err)
+	assert.Equal(t, "set default=0\nset timeout=5\n", string(data))
+
+	st, err := fsys.Stat("LOADER/LONG~1")
+	assert.Nil(t, err)
+	assert.True(t, st.IsDir())
+	assert.Equal(t, fs.ModeDir|0755, st.Mode())
+
+	st, err = fsys.Stat("EFI/BOOT/BOOTX64.EFI")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(20<<10), st.Size())
+	assert.Equal(t, fixtureTime, st.ModTime())
+
+	sys := st.Sys().(*Stat)
+	assert.Equal(t, "BOOTX64.EFI", sys.ShortName)
+	assert.Equal(t, fixtureTime.Add(time.Second), sys.Created)
+	assert.Equal(t, time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC), sys.Accessed)
+
+	_, err = fsys.Open("EFI/missing")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+
+	_, err = fsys.Open("README.TXT/x")
+	assert.NotNil(t, err)
+
+	_, err = fsys.Open("/EFI")
+	assert.True(t, errors.Is(err, fs.ErrInvalid))
+}
+
+func TestFSShortNames(t *testing.T) {
+	f := newFATImage(16)
+
+	/* Windows NT keeps lowercase 8.3 names in the case flags */
+	e := f.addRootEntry("README  TXT", FAT_ATTR_ARCHIVE)
+	e[12] = FAT_CASE_LOWER_BASE
+
+	e = f.addRootEntry("\x05TE     TXT", FAT_ATTR_ARCHIVE)
+	e[12] = FAT_CASE_LOWER_EXT
+
+	/* a long name left behind by a driver that renamed the short entry */
+	root := f.data[f.rootOffset()+64:]
+	copy(root, lfnEntries("stale long name", "OTHER"))
+	copy(root[64:], shortEntry("KEPT", FAT_ATTR_ARCHIVE, 0, 0))
+
+	fsys, err := Open(bytes.NewReader(f.data))
+	assert.Nil(t, err)
+
+	entries, err := fsys.ReadDir(".")
+	assert.Nil(t, err)
+
+	got := []string{}
+	for _, e := range entries {
+		got = append(got, e.Name())
+	}
+
+	assert.Equal(t, []string{"KEPT", "readme.TXT", "σTE.txt"}, got)
+
+	fsys, err = OpenWithCodePage(bytes.NewReader(f.data), CP850)
+	assert.Nil(t, err)
+
+	_, err = fsys.Stat("ÕTE.txt")
+	assert.Nil(t, err)
+}
+
+func TestFSCorrupt(t *testing.T) {
+	f := newFATImage(16)
+	f.writeTree(map[string][]byte{"big": make([]byte, 6<<10)})
+
+	fsys, err := Open(bytes.NewReader(f.data))
+	assert.Nil(t, err)
+
+	st, err := fsys.Stat("big")
+	assert.Nil(t, err)
+
+	/* loop the chain back onto itself */
+	first := st.Sys().(*Stat).Cluster
+	f.setFAT(first+1, first)
+
+	fsys, err = Open(bytes.NewReader(f.data))
+	assert.Nil(t, err)
+
+	_, err = fsys.ReadFile("big")
+	assert.True(t, errors.Is(err, goblkid.ErrCorrupt))
+
+	/* end the chain too early for the size */
+	f.setFAT(first+1, 0xffff)
+
+	fsys, err = Open(bytes.NewReader(f.data))
+	assert.Nil(t, err)
+
+	_, err = fsys.ReadFile("big")
+	assert.True(t, errors.Is(err, goblkid.ErrCorrupt))
+
+	_, err = Open(bytes.NewReader(make([]byte, 4096)))
+	assert.NotNil(t, err)
+}
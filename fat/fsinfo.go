@@ -0,0 +1,92 @@
+package fat
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/isi-lincoln/goblkid"
+)
+
+// FAT32 FSInfo sector signatures and values
+const (
+	FSINFO_LEAD_SIG   = 0x41615252 // nolint:golint,stylecheck // "RRaA"
+	FSINFO_STRUC_SIG  = 0x61417272 // nolint:golint,stylecheck // "rrAa"
+	FSINFO_TRAIL_SIG  = 0xaa550000 // nolint:golint,stylecheck
+	FSINFO_UNKNOWN    = 0xffffffff // nolint:golint,stylecheck
+	FSINFO_FREE_COUNT = 488        // nolint:golint,stylecheck
+	FSINFO_NEXT_FREE  = 492        // nolint:golint,stylecheck
+)
+
+// FSInfo is the FAT32 sector caching the free cluster count, a hint only
+// that the filesystem driver updates on unmount
+type FSInfo struct {
+	Sector       uint16
+	FreeClusters uint32 /* FSINFO_UNKNOWN when not maintained */
+	NextFree     uint32 /* where to start looking for a free cluster */
+
+	// Problems lists why the sector cannot be trusted
+	Problems []string
+}
+
+// Valid reports whether the signatures and the cached values make sense
+func (fi *FSInfo) Valid() bool {
+	return len(fi.Problems) == 0
+}
+
+// FreeKnown reports whether the free cluster count can be used
+func (fi *FSInfo) FreeKnown() bool {
+	return fi.Valid() && fi.FreeClusters != FSINFO_UNKNOWN
+}
+
+// ReadFSInfo reads the FSInfo sector of the FAT32 volume behind info, nil
+// for FAT12 and FAT16 which have none
+func ReadFSInfo(info *goblkid.ProbeInfo) (*FSInfo, error) {
+	g, err := readGeometry(info)
+	if err != nil {
+		return nil, err
+	}
+
+	return g.readFSInfo(readerAt{info})
+}
+
+func (g *geometry) readFSInfo(r io.ReaderAt) (*FSInfo, error) {
+	if g.bits != 32 {
+		return nil, nil
+	}
+
+	fi := &FSInfo{Sector: g.vs.FsinfoSector}
+
+	if fi.Sector == 0 || fi.Sector >= g.ms.Reserved {
+		fi.Problems = append(fi.Problems, fmt.Sprintf("fsinfo sector %d outside the reserved area", fi.Sector))
+		return fi, nil
+	}
+
+	buf := make([]byte, SuperblockSize)
+	if _, err := r.ReadAt(buf, int64(fi.Sector)*g.sectorSize); err != nil {
+		return nil, err
+	}
+
+	fi.FreeClusters = binary.LittleEndian.Uint32(buf[FSINFO_FREE_COUNT:])
+	fi.NextFree = binary.LittleEndian.Uint32(buf[FSINFO_NEXT_FREE:])
+
+	if binary.LittleEndian.Uint32(buf[0:]) != FSINFO_LEAD_SIG ||
+		binary.LittleEndian.Uint32(buf[484:]) != FSINFO_STRUC_SIG { // nolint:gomnd
+		fi.Problems = append(fi.Problems, "bad fsinfo signature")
+	}
+
+	if binary.LittleEndian.Uint32(buf[508:]) != FSINFO_TRAIL_SIG { // nolint:gomnd
+		fi.Problems = append(fi.Problems, "bad fsinfo trail signature")
+	}
+
+	if fi.FreeClusters != FSINFO_UNKNOWN && fi.FreeClusters > g.clusters {
+		fi.Problems = append(fi.Problems,
+			fmt.Sprintf("fsinfo free count %d exceeds the %d clusters", fi.FreeClusters, g.clusters))
+	}
+
+	if fi.NextFree != FSINFO_UNKNOWN && !g.validCluster(fi.NextFree) {
+		fi.Problems = append(fi.Problems, fmt.Sprintf("fsinfo next free cluster %d out of range", fi.NextFree))
+	}
+
+	return fi, nil
+}
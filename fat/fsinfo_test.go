@@ -0,0 +1,47 @@
+package fat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/isi-lincoln/goblkid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadFSInfo(t *testing.T) {
+	f := newFATImage(32)
+	info := &goblkid.ProbeInfo{DeviceReader: bytes.NewReader(f.data)}
+
+	fi, err := ReadFSInfo(info)
+	assert.Nil(t, err)
+	assert.True(t, fi.Valid())
+	assert.True(t, fi.FreeKnown())
+	assert.Equal(t, uint16(1), fi.Sector)
+	assert.Equal(t, uint32(f.clusters()-1), fi.FreeClusters)
+	assert.Equal(t, uint32(3), fi.NextFree)
+
+	fsinfo := f.data[f.sectorSize:]
+	binary.LittleEndian.PutUint32(fsinfo[FSINFO_FREE_COUNT:], FSINFO_UNKNOWN)
+	binary.LittleEndian.PutUint32(fsinfo[FSINFO_NEXT_FREE:], FSINFO_UNKNOWN)
+
+	fi, err = ReadFSInfo(info)
+	assert.Nil(t, err)
+	assert.True(t, fi.Valid())
+	assert.False(t, fi.FreeKnown())
+	assert.Equal(t, "1", f.probe(t).Tags[TagFreeUnknown])
+
+	binary.LittleEndian.PutUint32(fsinfo[FSINFO_FREE_COUNT:], uint32(f.clusters()+1))
+	binary.LittleEndian.PutUint32(fsinfo[FSINFO_NEXT_FREE:], 1)
+	copy(fsinfo, "rraa")
+
+	fi, err = ReadFSInfo(info)
+	assert.Nil(t, err)
+	assert.False(t, fi.Valid())
+	assert.Len(t, fi.Problems, 3)
+	assert.Equal(t, "1", f.probe(t).Tags[TagFreeUnknown])
+
+	fi, err = ReadFSInfo(&goblkid.ProbeInfo{DeviceReader: bytes.NewReader(newFATImage(16).data)})
+	assert.Nil(t, err)
+	assert.Nil(t, fi)
+}
@@ -0,0 +1,200 @@
+package fat
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/isi-lincoln/goblkid"
+)
+
+// FAT entry values
+const (
+	FAT_CLUSTER_FREE  = 0          // nolint:golint,stylecheck
+	FAT_CLUSTER_FIRST = 2          // nolint:golint,stylecheck // cluster numbers start at 2
+	FAT32_ENTRY_MASK  = 0x0fffffff // nolint:golint,stylecheck
+)
+
+/*
+ * jumpMagic is the magic a boot sector starting with a jump matches, for
+ * validating boot sectors read without matching a magic first
+ */
+var jumpMagic = goblkid.MagicInfo{Magic: "\353"} // nolint:gochecknoglobals
+
+/* geometry is the layout of a FAT volume in bytes, worked out from its boot sector */
+type geometry struct {
+	bits        int
+	sectorSize  int64
+	clusterSize int64
+	fatOffset   int64 /* of the first FAT */
+	fatLength   int64 /* of each FAT */
+	fats        int
+	rootOffset  int64 /* of the FAT12/16 root directory */
+	rootEntries uint32
+	rootCluster uint32 /* of the FAT32 root directory */
+	dataOffset  int64  /* of cluster 2 */
+	clusters    uint32 /* data clusters, numbered from 2 */
+	sectors     uint32
+
+	ms *msdosSuperBlock
+	vs *vfatSuperBlock
+}
+
+/* readGeometry reads and validates the boot sector of the FAT volume behind info */
+func readGeometry(info *goblkid.ProbeInfo) (*geometry, error) {
+	if _, err := info.DeviceReader.Seek(info.Offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	ms, vs, err := vfatGetSuperblock(info.DeviceReader)
+	if err != nil {
+		return nil, err
+	}
+
+	if !hasJump(ms.Ignored[0]) || !isFATValidSuperblock(ms, vs, jumpMagic, false) {
+		return nil, fmt.Errorf("no FAT boot sector")
+	}
+
+	return newGeometry(ms, vs), nil
+}
+
+/* hasJump reports whether a boot sector starts with a jump instruction */
+func hasJump(b uint8) bool {
+	return b == 0xeb || b == 0xe9
+}
+
+func newGeometry(ms *msdosSuperBlock, vs *vfatSuperBlock) *geometry {
+	g := &geometry{
+		sectorSize:  int64(ms.SectorSize),
+		clusterSize: int64(ms.ClusterSize) * int64(ms.SectorSize),
+		fatOffset:   int64(ms.Reserved) * int64(ms.SectorSize),
+		fatLength:   int64(fatSize(ms, vs)/uint32(ms.Fats)) * int64(ms.SectorSize),
+		fats:        int(ms.Fats),
+		rootEntries: uint32(ms.DirEntries),
+		sectors:     fatSectors(ms),
+		clusters:    fatClusterCount(ms, vs),
+		ms:          ms,
+		vs:          vs,
+	}
+
+	g.rootOffset = g.fatOffset + int64(g.fats)*g.fatLength
+	g.dataOffset = g.rootOffset + (int64(g.rootEntries)*32+g.sectorSize-1)/g.sectorSize*g.sectorSize // nolint:gomnd
+
+	switch {
+	case ms.FatLength == 0:
+		g.bits = 32
+		g.rootCluster = vs.RootCluster
+	case g.clusters < FAT12_MAX:
+		g.bits = 12
+	default:
+		g.bits = 16
+	}
+
+	return g
+}
+
+/* clusterOffset is where a data cluster starts */
+func (g *geometry) clusterOffset(cluster uint32) int64 {
+	return g.dataOffset + int64(cluster-FAT_CLUSTER_FIRST)*g.clusterSize
+}
+
+/* validCluster reports whether cluster is a data cluster of the volume */
+func (g *geometry) validCluster(cluster uint32) bool {
+	return cluster >= FAT_CLUSTER_FIRST && cluster < g.clusters+FAT_CLUSTER_FIRST
+}
+
+/* eoc reports whether a FAT entry ends a cluster chain */
+func (g *geometry) eoc(entry uint32) bool {
+	switch g.bits {
+	case 12:
+		return entry >= 0xff8
+	case 16:
+		return entry >= 0xfff8
+	default:
+		return entry >= 0x0ffffff8
+	}
+}
+
+/* bad reports whether a FAT entry marks a bad cluster */
+func (g *geometry) bad(entry uint32) bool {
+	switch g.bits {
+	case 12:
+		return entry == 0xff7
+	case 16:
+		return entry == 0xfff7
+	default:
+		return entry == 0x0ffffff7
+	}
+}
+
+/* readFAT reads FAT copy n and decodes every entry, the two reserved ones included */
+func (g *geometry) readFAT(r io.ReaderAt, n int) ([]uint32, error) {
+	buf := make([]byte, g.fatLength)
+	if _, err := r.ReadAt(buf, g.fatOffset+int64(n)*g.fatLength); err != nil {
+		return nil, err
+	}
+
+	return g.decodeFAT(buf), nil
+}
+
+func (g *geometry) decodeFAT(buf []byte) []uint32 {
+	count := g.clusters + FAT_CLUSTER_FIRST
+	entries := make([]uint32, 0, count)
+
+	for n := uint32(0); n < count; n++ {
+		switch g.bits {
+		case 12:
+			off := n * 3 / 2 // nolint:gomnd
+			if int(off)+1 >= len(buf) {
+				return entries
+			}
+
+			v := uint32(binary.LittleEndian.Uint16(buf[off:]))
+			if n&1 == 1 {
+				v >>= 4
+			}
+
+			entries = append(entries, v&0xfff)
+		case 16:
+			if int(n*2)+1 >= len(buf) {
+				return entries
+			}
+
+			entries = append(entries, uint32(binary.LittleEndian.Uint16(buf[n*2:])))
+		default:
+			if int(n*4)+3 >= len(buf) {
+				return entries
+			}
+
+			entries = append(entries, binary.LittleEndian.Uint32(buf[n*4:])&FAT32_ENTRY_MASK)
+		}
+	}
+
+	return entries
+}
+
+/* freeClusters counts the free entries of a decoded FAT */
+func freeClusters(fat []uint32) uint32 {
+	free := uint32(0)
+
+	for _, e := range fat[FAT_CLUSTER_FIRST:] {
+		if e == FAT_CLUSTER_FREE {
+			free++
+		}
+	}
+
+	return free
+}
+
+/* readerAt adapts the seeker of a ProbeInfo, honoring its offset */
+type readerAt struct {
+	info *goblkid.ProbeInfo
+}
+
+func (r readerAt) ReadAt(p []byte, off int64) (int, error) {
+	if _, err := r.info.DeviceReader.Seek(r.info.Offset+off, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	return io.ReadFull(r.info.DeviceReader, p)
+}
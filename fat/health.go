@@ -0,0 +1,65 @@
+package fat
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/isi-lincoln/goblkid"
+)
+
+// Health is what the FSInfo sector and the FAT say about the free space
+type Health struct {
+	// FSInfo is nil for FAT12 and FAT16
+	FSInfo *FSInfo
+	// FreeClusters is counted in the first FAT
+	FreeClusters uint32
+
+	// Reasons explains every warning
+	Reasons []string
+}
+
+// String summarizes the health in one line
+func (h *Health) String() string {
+	if len(h.Reasons) == 0 {
+		return "clean"
+	}
+
+	return strings.Join(h.Reasons, ", ")
+}
+
+// ReadHealth counts the free clusters of the FAT volume behind info and
+// checks the FSInfo sector against them
+func ReadHealth(info *goblkid.ProbeInfo) (*Health, error) {
+	g, err := readGeometry(info)
+	if err != nil {
+		return nil, err
+	}
+
+	r := readerAt{info}
+	h := &Health{}
+
+	fat, err := g.readFAT(r, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	h.FreeClusters = freeClusters(fat)
+
+	h.FSInfo, err = g.readFSInfo(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.FSInfo == nil {
+		return h, nil
+	}
+
+	h.Reasons = append(h.Reasons, h.FSInfo.Problems...)
+
+	if h.FSInfo.FreeKnown() && h.FSInfo.FreeClusters != h.FreeClusters {
+		h.Reasons = append(h.Reasons, fmt.Sprintf("fsinfo free count %d, the FAT has %d free clusters",
+			h.FSInfo.FreeClusters, h.FreeClusters))
+	}
+
+	return h, nil
+}
@@ -0,0 +1,44 @@
+package fat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/isi-lincoln/goblkid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadHealth(t *testing.T) {
+	f := newFATImage(32)
+	info := &goblkid.ProbeInfo{DeviceReader: bytes.NewReader(f.data)}
+
+	h, err := ReadHealth(info)
+	assert.Nil(t, err)
+	assert.Equal(t, "clean", h.String())
+	assert.Equal(t, uint32(f.clusters()-1), h.FreeClusters)
+
+	/* a file written by a driver that never updated FSInfo */
+	f.setFAT(3, 0x0fffffff)
+
+	h, err = ReadHealth(info)
+	assert.Nil(t, err)
+	assert.Len(t, h.Reasons, 1)
+	assert.Contains(t, h.String(), "the FAT has")
+
+	binary.LittleEndian.PutUint32(f.data[f.sectorSize+508:], 0)
+
+	h, err = ReadHealth(info)
+	assert.Nil(t, err)
+	assert.Equal(t, "bad fsinfo trail signature", h.String())
+
+	f = newFATImage(12)
+	h, err = ReadHealth(&goblkid.ProbeInfo{DeviceReader: bytes.NewReader(f.data)})
+	assert.Nil(t, err)
+	assert.Nil(t, h.FSInfo)
+	assert.Equal(t, uint32(f.clusters()), h.FreeClusters)
+	assert.Equal(t, "clean", h.String())
+
+	_, err = ReadHealth(&goblkid.ProbeInfo{DeviceReader: bytes.NewReader(make([]byte, 4096))})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,291 @@
+package fat
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/isi-lincoln/goblkid"
+)
+
+// Device is what label and serial changes are written to
+type Device interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// LabelLength is the size of a FAT label
+const LabelLength = 11
+
+// FAT_EXT_BOOT_SIGNATURE_OLD marks an extended boot record with a serial
+// but neither label nor filesystem type
+const FAT_EXT_BOOT_SIGNATURE_OLD = 0x28 // nolint:golint,stylecheck
+
+/* characters DOS does not allow in a label */
+const labelInvalid = "\"*+,./:;<=>?[\\]|"
+
+// RandomSerial returns a random volume serial for SetSerial
+func RandomSerial() (goblkid.UUID, error) {
+	u := goblkid.UUID{Format: goblkid.FATSerial}
+
+	if _, err := rand.Read(u.Raw[:4]); err != nil {
+		return u, err
+	}
+
+	return u, nil
+}
+
+// SetLabel changes the label of the FAT volume on dev as fatlabel does.
+// The label is upper cased and encoded with cp, CP437 when nil. It is
+// written to the root directory volume label entry, which is created when
+// missing, and to the primary and FAT32 backup boot sectors. An empty label
+// removes the entry and leaves NO NAME in the boot sectors.
+func SetLabel(dev Device, label string, cp *CodePage) error {
+	raw, err := encodeLabel(label, orCP437(cp))
+	if err != nil {
+		return err
+	}
+
+	f, err := Open(dev)
+	if err != nil {
+		return err
+	}
+
+	boot := raw
+	if boot == nil {
+		boot = []byte(FAT_NO_NAME + "    ")
+	}
+
+	err = updateBoots(dev, f.g, func(b []byte, ebpb int) {
+		if b[ebpb+2] == FAT_EXT_BOOT_SIGNATURE {
+			copy(b[ebpb+7:ebpb+7+LabelLength], boot)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return f.setRootLabel(dev, raw)
+}
+
+// SetSerial changes the volume serial of the FAT volume on dev, as
+// mlabel -N does, in the primary and FAT32 backup boot sectors
+func SetSerial(dev Device, serial goblkid.UUID) error {
+	if serial.Format != goblkid.FATSerial || serial.IsZero() {
+		return fmt.Errorf("FAT needs a non-zero volume serial, not %#v", serial)
+	}
+
+	f, err := Open(dev)
+	if err != nil {
+		return err
+	}
+
+	sig := f.g.ms.Unknown[2]
+	if f.g.bits == 32 {
+		sig = f.g.vs.Unknown[2]
+	}
+
+	if sig != FAT_EXT_BOOT_SIGNATURE && sig != FAT_EXT_BOOT_SIGNATURE_OLD {
+		return fmt.Errorf("no extended boot record to keep a serial in")
+	}
+
+	return updateBoots(dev, f.g, func(b []byte, ebpb int) {
+		copy(b[ebpb+3:ebpb+7], serial.Bytes())
+	})
+}
+
+/* encodeLabel checks a label and pads it out to 11 bytes, nil for no label */
+func encodeLabel(label string, cp *CodePage) ([]byte, error) {
+	if label == "" {
+		return nil, nil
+	}
+
+	upper := strings.ToUpper(label)
+	if strings.TrimRight(upper, " ") == FAT_NO_NAME {
+		return nil, fmt.Errorf("%q stands for no label, use an empty one", label)
+	}
+
+	raw, err := cp.Encode(upper)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(raw) > LabelLength {
+		return nil, fmt.Errorf("label %q longer than %d bytes in %s", label, LabelLength, cp.Name)
+	}
+
+	for _, c := range raw {
+		if c < 0x20 || strings.IndexByte(labelInvalid, c) >= 0 { // nolint:gomnd
+			return nil, fmt.Errorf("label %q contains %q, which FAT does not allow", label, c)
+		}
+	}
+
+	if raw[0] == ' ' {
+		return nil, fmt.Errorf("label %q starts with a space", label)
+	}
+
+	return append(raw, []byte(strings.Repeat(" ", LabelLength-len(raw)))...), nil
+}
+
+/*
+ * updateBoots applies change to the primary boot sector and to the FAT32
+ * backup when it holds one, passing the offset of the extended boot record
+ */
+func updateBoots(dev Device, g *geometry, change func(b []byte, ebpb int)) error {
+	ebpb := 0x24
+	offsets := []int64{0}
+
+	if g.bits == 32 {
+		ebpb = 0x40
+
+		b, err := g.readBackupBoot(dev)
+		if err != nil {
+			return err
+		}
+
+		if b != nil && b.Present {
+			offsets = append(offsets, b.Offset)
+		}
+	}
+
+	buf := make([]byte, SuperblockSize)
+
+	for _, off := range offsets {
+		if _, err := dev.ReadAt(buf, off); err != nil {
+			return err
+		}
+
+		change(buf, ebpb)
+
+		if err := writeAll(dev, buf, off); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ * setRootLabel rewrites the volume label entry of the root directory,
+ * taking the first free slot for a new one and freeing it for no label
+ */
+func (f *FS) setRootLabel(dev Device, raw []byte) error {
+	slots, err := f.rootSlots()
+	if err != nil {
+		return err
+	}
+
+	ent := make([]byte, FAT_DIR_ENTRY_SIZE)
+	label, free, end := -1, -1, -1
+
+	for i, off := range slots {
+		if _, err := dev.ReadAt(ent, off); err != nil {
+			return err
+		}
+
+		switch {
+		case ent[0] == 0:
+			end = i
+		case ent[0] == FAT_ENTRY_FREE:
+		case ent[11]&FAT_ATTR_MASK != FAT_ATTR_LONG_NAME &&
+			ent[11]&(FAT_ATTR_VOLUME_ID|FAT_ATTR_DIR) == FAT_ATTR_VOLUME_ID:
+			label = i
+			continue
+		default:
+			continue
+		}
+
+		if free < 0 {
+			free = i
+		}
+
+		if end >= 0 {
+			break
+		}
+	}
+
+	switch {
+	case raw == nil && label < 0:
+		return nil
+	case raw == nil:
+		return writeAll(dev, []byte{FAT_ENTRY_FREE}, slots[label])
+	case label >= 0:
+		if _, err := dev.ReadAt(ent, slots[label]); err != nil {
+			return err
+		}
+	case free < 0:
+		return fmt.Errorf("no free root directory entry for the label")
+	default:
+		label = free
+		ent = make([]byte, FAT_DIR_ENTRY_SIZE)
+		ent[11] = FAT_ATTR_VOLUME_ID
+
+		/* taking the end marker moves it to the next slot, a freed slot before it has none to move */
+		if label == end && label+1 < len(slots) {
+			if err := writeAll(dev, []byte{0}, slots[label+1]); err != nil {
+				return err
+			}
+		}
+	}
+
+	copy(ent, raw)
+	if ent[0] == FAT_ENTRY_FREE {
+		ent[0] = FAT_ENTRY_E5
+	}
+
+	date, tm := fatDate(time.Now())
+	binary.LittleEndian.PutUint16(ent[22:], tm)
+	binary.LittleEndian.PutUint16(ent[24:], date)
+
+	return writeAll(dev, ent, slots[label])
+}
+
+/* rootSlots lists the offset of every root directory entry */
+func (f *FS) rootSlots() ([]int64, error) {
+	slots := []int64{}
+
+	if f.g.bits != 32 {
+		for i := int64(0); i < int64(f.g.rootEntries); i++ {
+			slots = append(slots, f.g.rootOffset+i*FAT_DIR_ENTRY_SIZE)
+		}
+
+		return slots, nil
+	}
+
+	clusters, err := f.chain(f.g.rootCluster)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range clusters {
+		for off := int64(0); off < f.g.clusterSize; off += FAT_DIR_ENTRY_SIZE {
+			slots = append(slots, f.g.clusterOffset(c)+off)
+		}
+	}
+
+	return slots, nil
+}
+
+/* fatDate encodes t as a FAT date and time, which have no time zone */
+func fatDate(t time.Time) (uint16, uint16) {
+	date := uint16(t.Year()-1980)<<9 | uint16(t.Month())<<5 | uint16(t.Day()) // nolint:gomnd
+	tm := uint16(t.Hour())<<11 | uint16(t.Minute())<<5 | uint16(t.Second()/2) // nolint:gomnd
+
+	return date, tm
+}
+
+func writeAll(dev Device, buf []byte, off int64) error {
+	n, err := dev.WriteAt(buf, off)
+	if err != nil {
+		return err
+	}
+
+	if n != len(buf) {
+		return fmt.Errorf("short write at %d: %d of %d bytes", off, n, len(buf))
+	}
+
+	return nil
+}
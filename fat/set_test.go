@@ -0,0 +1,216 @@
+package fat
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/isi-lincoln/goblkid"
+	"github.com/stretchr/testify/assert"
+)
+
+type memDevice []byte
+
+func (m memDevice) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(m)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, m[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (m memDevice) WriteAt(p []byte, off int64) (int, error) {
+	if off+int64(len(p)) > int64(len(m)) {
+		return 0, io.ErrShortWrite
+	}
+
+	return copy(m[off:], p), nil
+}
+
+/* labelEntries counts the volume label entries of the root directory */
+func labelEntries(t *testing.T, f *fatImage) int {
+	t.Helper()
+
+	fsys, err := Open(bytes.NewReader(f.data))
+	assert.Nil(t, err)
+
+	slots, err := fsys.rootSlots()
+	assert.Nil(t, err)
+
+	n := 0
+
+	for _, off := range slots {
+		e := f.data[off : off+32]
+		if e[0] != 0 && e[0] != FAT_ENTRY_FREE && e[11] == FAT_ATTR_VOLUME_ID {
+			n++
+		}
+	}
+
+	return n
+}
+
+func TestSetLabel(t *testing.T) {
+	for _, bits := range []int{12, 16, 32} {
+		f := newFATImage(bits)
+		f.writeTree(map[string][]byte{"EFI/BOOT/grub.cfg": []byte("x"), "fw.bin": []byte("y")})
+		dev := memDevice(f.data)
+
+		assert.Nil(t, SetLabel(dev, "Firmware", nil))
+
+		info := f.probe(t)
+		assert.Equal(t, "FIRMWARE", info.Label, "FAT%d", bits)
+		assert.Equal(t, "FIRMWARE", info.Tags[TagLabelFATBoot])
+		assert.Equal(t, 1, labelEntries(t, f))
+
+		if bits == 32 {
+			assert.Equal(t, "ok", info.Tags[TagBackupBoot])
+		}
+
+		/* relabelling rewrites the entry in place */
+		assert.Nil(t, SetLabel(dev, "update 2", nil))
+		assert.Equal(t, "UPDATE 2", f.probe(t).Label)
+		assert.Equal(t, 1, labelEntries(t, f))
+
+		fsys, err := Open(bytes.NewReader(f.data))
+		assert.Nil(t, err)
+
+		entries, err := fsys.ReadDir(".")
+		assert.Nil(t, err)
+		assert.Len(t, entries, 2, "FAT%d", bits)
+
+		data, err := fsys.ReadFile("fw.bin")
+		assert.Nil(t, err)
+		assert.Equal(t, "y", string(data))
+
+		assert.Nil(t, SetLabel(dev, "", nil))
+
+		info = f.probe(t)
+		assert.Equal(t, "", info.Label)
+		assert.NotContains(t, info.Tags, TagLabelFATBoot)
+		assert.Equal(t, 0, labelEntries(t, f))
+		assert.Equal(t, "NO NAME    ", string(f.ebpb()[7:18]))
+	}
+}
+
+/* deleteRootEntry frees name and its long name entries as a FAT driver does */
+func deleteRootEntry(t *testing.T, f *fatImage, name string) {
+	t.Helper()
+
+	fsys, err := Open(bytes.NewReader(f.data))
+	assert.Nil(t, err)
+
+	ent, err := fsys.lookup("remove", name)
+	assert.Nil(t, err)
+
+	slots, err := fsys.rootSlots()
+	assert.Nil(t, err)
+
+	for i := ent.slot; i >= 0; i-- {
+		e := f.data[slots[i] : slots[i]+32]
+		if i < ent.slot && e[11]&FAT_ATTR_MASK != FAT_ATTR_LONG_NAME {
+			break
+		}
+
+		e[0] = FAT_ENTRY_FREE
+	}
+}
+
+func TestSetLabelDeletedSlot(t *testing.T) {
+	for _, bits := range []int{12, 16, 32} {
+		f := newFATImage(bits)
+		f.writeTree(map[string][]byte{"a.txt": []byte("a"), "b.txt": []byte("b"), "c.txt": []byte("c")})
+		deleteRootEntry(t, f, "a.txt")
+
+		/* the label reuses the freed slot, which is not the end marker */
+		assert.Nil(t, SetLabel(memDevice(f.data), "NEW", nil))
+		assert.Equal(t, "NEW", f.probe(t).Label, "FAT%d", bits)
+		assert.Equal(t, 1, labelEntries(t, f))
+
+		fsys, err := Open(bytes.NewReader(f.data))
+		assert.Nil(t, err)
+
+		entries, err := fsys.ReadDir(".")
+		assert.Nil(t, err)
+
+		names := []string{}
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+
+		assert.Equal(t, []string{"b.txt", "c.txt"}, names, "FAT%d", bits)
+	}
+}
+
+func TestSetLabelCodePage(t *testing.T) {
+	f := newFATImage(16)
+	dev := memDevice(f.data)
+
+	assert.Nil(t, SetLabel(dev, "été", nil))
+	assert.Equal(t, "ÉTÉ", f.probe(t).Label)
+	assert.Equal(t, "\x90T\x90", f.probe(t).Tags[TagLabelRaw])
+
+	/* a leading 0xe5 is escaped in the directory but not in the boot sector */
+	assert.Nil(t, SetLabel(dev, "õ", CP850))
+	assert.Equal(t, "Õ", f.probeWith(t, "cp850").Label)
+	assert.Equal(t, "Õ", f.probeWith(t, "cp850").Tags[TagLabelFATBoot])
+	assert.Equal(t, byte(0xe5), f.ebpb()[7])
+	assert.Equal(t, byte(FAT_ENTRY_E5), f.data[f.rootOffset()])
+
+	/* the same bytes read back in the default code page */
+	assert.Equal(t, "σ", f.probe(t).Label)
+
+	for _, bad := range []string{"a.b", "twelve chars", "no name", "🎉", " lead", "tab\t"} {
+		assert.NotNil(t, SetLabel(dev, bad, CP850), bad)
+	}
+
+	assert.NotNil(t, SetLabel(dev, "õ", nil))
+	assert.Equal(t, "Õ", f.probeWith(t, "cp850").Label)
+}
+
+func TestSetLabelRootFull(t *testing.T) {
+	f := newFATImage(12)
+
+	for i := 0; i < f.rootEntries; i++ {
+		f.addRootEntry(fmt.Sprintf("F%d", i), FAT_ATTR_ARCHIVE)
+	}
+
+	assert.NotNil(t, SetLabel(memDevice(f.data), "FULL", nil))
+}
+
+func TestSetSerial(t *testing.T) {
+	for _, bits := range []int{12, 16, 32} {
+		f := newFATImage(bits)
+		dev := memDevice(f.data)
+
+		serial, err := goblkid.ParseUUIDFormat("DEAD-BEEF", goblkid.FATSerial)
+		assert.Nil(t, err)
+		assert.Nil(t, SetSerial(dev, serial))
+
+		info := f.probe(t)
+		assert.Equal(t, "DEAD-BEEF", info.UUID.String())
+
+		if bits == 32 {
+			assert.Equal(t, "ok", info.Tags[TagBackupBoot])
+		}
+
+		random, err := RandomSerial()
+		assert.Nil(t, err)
+		assert.Nil(t, SetSerial(dev, random))
+		assert.Equal(t, random.String(), f.probe(t).UUID.String())
+	}
+
+	f := newFATImage(16)
+	assert.NotNil(t, SetSerial(memDevice(f.data), goblkid.UUID{Format: goblkid.FATSerial}))
+	assert.NotNil(t, SetSerial(memDevice(f.data), goblkid.NewUUID(goblkid.RFC4122, []byte("0123456789abcdef"))))
+
+	/* DOS 3.x boot sectors have nowhere to keep a serial */
+	f.ebpb()[2] = 0
+	serial, _ := goblkid.ParseUUIDFormat("DEAD-BEEF", goblkid.FATSerial)
+	assert.NotNil(t, SetSerial(memDevice(f.data), serial))
+}
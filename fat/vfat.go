@@ -1,8 +1,8 @@
 package fat
 
 import (
+	"bytes"
 	"encoding/binary"
-	"fmt"
 	"io"
 	"strings"
 
@@ -19,17 +19,37 @@ const (
 	FAT_ATTR_LONG_NAME = 0x0f // nolint:golint,stylecheck
 	FAT_ATTR_MASK      = 0x3f // nolint:golint,stylecheck
 	FAT_ENTRY_FREE     = 0xe5 // nolint:golint,stylecheck
+	FAT_ENTRY_E5       = 0x05 // nolint:golint,stylecheck // a leading 0xe5 in a name
+
+	FAT_EXT_BOOT_SIGNATURE = 0x29      // nolint:golint,stylecheck
+	FAT_NO_NAME            = "NO NAME" // nolint:golint,stylecheck
 
 	FatName = "vfat"
 )
 
+// Tags the vfat prober sets besides the label
+const (
+	// TagLabelFATBoot is the label kept in the boot sector, which may
+	// differ from the root directory label reported as the filesystem label
+	TagLabelFATBoot = "LABEL_FATBOOT"
+	// TagLabelRaw holds the undecoded bytes of the label when they are
+	// not plain ASCII, the padding trimmed and any 0x05 escape kept
+	TagLabelRaw = "LABEL_RAW"
+	// TagFreeUnknown is set when the probe leaves FreeBlocks unset: FAT12
+	// and FAT16 keep no free count and FSInfo may not have one, ReadHealth
+	// counts the FAT instead
+	TagFreeUnknown = "FAT_FREE_UNKNOWN"
+)
+
+/* exFAT first, its boot sector starts with a jump FATProber would match on */
 var Chain = goblkid.Chain{ // nolint:gochecknoglobals
+	ExFATProber,
 	FATProber,
 }
 
 func vfatProbe(info *goblkid.ProbeInfo, magic goblkid.MagicInfo) (bool, error) { // nolint:funlen
 	_, err := info.DeviceReader.Seek(
-		int64(magic.SuperblockKbOffset<<10), // nolint:gomnd
+		info.Offset+int64(magic.SuperblockKbOffset<<10), // nolint:gomnd
 		io.SeekStart,
 	)
 	if err != nil {
@@ -41,7 +61,7 @@ func vfatProbe(info *goblkid.ProbeInfo, magic goblkid.MagicInfo) (bool, error) {
 		return false, err
 	}
 
-	if !isFATValidSuperblock(ms, vs, magic) {
+	if !isFATValidSuperblock(ms, vs, magic, isWholeDisk(info)) {
 		return false, err
 	}
 
@@ -49,15 +69,20 @@ func vfatProbe(info *goblkid.ProbeInfo, magic goblkid.MagicInfo) (bool, error) {
 	clusterCount := fatClusterCount(ms, vs)
 
 	version := ""
+	rootLabel, bootLabel := "", ""
 
 	if ms.FatLength != 0 {
 		rootStart := (uint32(ms.Reserved) + fatSize) * uint32(ms.SectorSize)
-		info.Label, err = searchFATLabel(info, uint64(rootStart), uint32(vs.DirEntries))
+		rootLabel, err = searchFATLabel(info, uint64(rootStart), uint32(vs.DirEntries))
 
 		if err != nil {
 			return false, err
 		}
 
+		if ms.Unknown[2] == FAT_EXT_BOOT_SIGNATURE {
+			bootLabel = string(ms.Label[:])
+		}
+
 		info.SecType = "msdos"
 
 		if clusterCount < FAT12_MAX {
@@ -66,10 +91,9 @@ func vfatProbe(info *goblkid.ProbeInfo, magic goblkid.MagicInfo) (bool, error) {
 			version = "FAT16"
 		}
 
-		info.UUID = fmt.Sprintf("%02X%02X-%02X%02X",
-			ms.Serno[3], ms.Serno[2], ms.Serno[1], ms.Serno[0])
+		info.UUID = goblkid.NewUUID(goblkid.FATSerial, ms.Serno[:])
 	} else if vs.Fat32Length != 0 {
-		bufSize := uint32(vs.ClusterSize) + uint32(ms.SectorSize)
+		bufSize := uint32(vs.ClusterSize) * uint32(ms.SectorSize)
 		startDataSect := uint32(ms.Reserved) + fatSize
 		entries := vs.Fat32Length * uint32(ms.SectorSize) / 4 // nolint:gomnd // 4=sizeof(uint32)
 		next := vs.RootCluster
@@ -79,15 +103,19 @@ func vfatProbe(info *goblkid.ProbeInfo, magic goblkid.MagicInfo) (bool, error) {
 			nextSectOffset := (next - 2) * uint32(vs.ClusterSize)
 			nextOffset := uint64(startDataSect+nextSectOffset) * uint64(ms.SectorSize)
 			count := bufSize / vfatDirEntrySize
-			info.Label, err = searchFATLabel(info, nextOffset, count)
+			rootLabel, err = searchFATLabel(info, nextOffset, count)
 			if err != nil {
 				return false, err
 			}
 
+			if rootLabel != "" {
+				break
+			}
+
 			fatEntryOffset := uint64(ms.Reserved)*uint64(ms.SectorSize) +
 				uint64(next)*4 // nolint:gomnd // 4=sizeof(uint32)
 			_, err = info.DeviceReader.Seek(
-				int64(fatEntryOffset),
+				info.Offset+int64(fatEntryOffset),
 				io.SeekStart,
 			)
 			if err != nil {
@@ -100,18 +128,72 @@ func vfatProbe(info *goblkid.ProbeInfo, magic goblkid.MagicInfo) (bool, error) {
 			next &= 0x0fffffff
 		}
 		version = "FAT32"
-		info.UUID = fmt.Sprintf("%02X%02X-%02X%02X",
-			vs.Serno[3], vs.Serno[2], vs.Serno[1], vs.Serno[0])
+		info.UUID = goblkid.NewUUID(goblkid.FATSerial, vs.Serno[:])
+
+		if vs.Unknown[2] == FAT_EXT_BOOT_SIGNATURE {
+			bootLabel = string(vs.Label[:])
+		}
+	}
+
+	cp, err := probeCodePage(info)
+	if err != nil {
+		return false, err
+	}
+
+	/* the root directory entry is what Windows and mtools update, prefer it */
+	raw := trimLabel(rootLabel)
+	info.Label = fatLabel(rootLabel, true, cp)
+
+	if info.Label == "" {
+		raw = trimLabel(bootLabel)
+		info.Label = fatLabel(bootLabel, false, cp)
+	}
+
+	if info.Label != "" && info.Label != raw {
+		info.SetTag(TagLabelRaw, raw)
+	}
+
+	if label := fatLabel(bootLabel, false, cp); label != "" {
+		info.SetTag(TagLabelFATBoot, label)
 	}
 
 	info.Version = version
+	info.FSSize = uint64(fatSectors(ms)) * uint64(ms.SectorSize)
+
+	g := newGeometry(ms, vs)
+	info.FSBlockSize = uint64(g.clusterSize)
+	info.FSLastBlock = uint64(g.clusters)
+
+	free, known, err := freeEstimate(info, g)
+	if err != nil {
+		return false, err
+	}
+
+	if known {
+		info.FreeBlocks = free
+	} else {
+		info.SetTag(TagFreeUnknown, "1")
+	}
+
+	if g.bits == 32 {
+		b, err := g.readBackupBoot(readerAt{info})
+		if err != nil {
+			return false, ignoreShort(err)
+		}
+
+		if b == nil {
+			b = &BackupBoot{}
+		}
+
+		info.SetTag(TagBackupBoot, b.String())
+	}
 
 	return true, nil
 }
 
 func searchFATLabel(info *goblkid.ProbeInfo, rootStart uint64, dirEntries uint32) (string, error) {
 	_, err := info.DeviceReader.Seek(
-		int64(rootStart),
+		info.Offset+int64(rootStart),
 		io.SeekStart,
 	)
 	if err != nil {
@@ -121,7 +203,7 @@ func searchFATLabel(info *goblkid.ProbeInfo, rootStart uint64, dirEntries uint32
 	for i := uint32(0); i < dirEntries; i++ {
 		ent, err := unpackVFATDirEntry(info.DeviceReader)
 		if err != nil {
-			panic(err)
+			return "", err
 		}
 
 		if ent.Name[0] == 0 {
@@ -136,13 +218,63 @@ func searchFATLabel(info *goblkid.ProbeInfo, rootStart uint64, dirEntries uint32
 		}
 
 		if ent.Attr&(FAT_ATTR_VOLUME_ID|FAT_ATTR_DIR) == FAT_ATTR_VOLUME_ID {
-			return strings.TrimRight(ent.Name, " \n\t"), nil
+			return ent.Name, nil
 		}
 	}
 
 	return "", nil
 }
 
+/*
+ * fatLabel decodes a label with the code page cp, "NO NAME" stands for no
+ * label. In a directory entry a leading 0x05 stands for 0xe5, which as the
+ * first byte would mark the entry free.
+ */
+func fatLabel(raw string, dirEntry bool, cp *CodePage) string {
+	label := []byte(trimLabel(raw))
+	if string(label) == FAT_NO_NAME {
+		return ""
+	}
+
+	if dirEntry && len(label) > 0 && label[0] == FAT_ENTRY_E5 {
+		label[0] = FAT_ENTRY_FREE
+	}
+
+	return cp.Decode(label)
+}
+
+/* trimLabel drops the space padding of an 11 byte label */
+func trimLabel(raw string) string {
+	return strings.TrimRight(raw, " \x00")
+}
+
+/*
+ * freeEstimate is the free cluster count FSInfo keeps on FAT32. FAT12 and
+ * FAT16 keep none and the probe does not read the whole FAT to count them,
+ * so known is false there and when FSInfo is missing or has no count.
+ */
+func freeEstimate(info *goblkid.ProbeInfo, g *geometry) (free uint64, known bool, err error) {
+	if g.bits != 32 {
+		return 0, false, nil
+	}
+
+	fi, err := g.readFSInfo(readerAt{info})
+	if err != nil || !fi.FreeKnown() {
+		return 0, false, ignoreShort(err)
+	}
+
+	return uint64(fi.FreeClusters), true, nil
+}
+
+/* ignoreShort drops the error of reading past the end of a truncated device */
+func ignoreShort(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil
+	}
+
+	return err
+}
+
 func fatSize(ms *msdosSuperBlock, vs *vfatSuperBlock) uint32 {
 	var fatLength = uint32(ms.FatLength)
 	if fatLength == 0 {
@@ -152,16 +284,24 @@ func fatSize(ms *msdosSuperBlock, vs *vfatSuperBlock) uint32 {
 	return fatLength * uint32(ms.Fats)
 }
 
+func fatSectors(ms *msdosSuperBlock) uint32 {
+	if ms.Sectors != 0 {
+		return uint32(ms.Sectors)
+	}
+
+	return ms.TotalSect
+}
+
 func fatClusterCount(ms *msdosSuperBlock, vs *vfatSuperBlock) uint32 {
 	entrySize := uint32(32) // nolint:gomnd
 	fatSize := fatSize(ms, vs)
 
 	dirSize := (uint32(ms.DirEntries)*entrySize + uint32(ms.SectorSize-1)) / uint32(ms.SectorSize)
 
-	return (uint32(ms.Sectors) - (uint32(ms.Reserved) + fatSize + dirSize)) / uint32(ms.ClusterSize)
+	return (fatSectors(ms) - (uint32(ms.Reserved) + fatSize + dirSize)) / uint32(ms.ClusterSize)
 }
 
-func isFATValidSuperblock(ms *msdosSuperBlock, vs *vfatSuperBlock, magic goblkid.MagicInfo) bool { // nolint:funlen,gocognit,lll
+func isFATValidSuperblock(ms *msdosSuperBlock, vs *vfatSuperBlock, magic goblkid.MagicInfo, wholeDisk bool) bool { // nolint:funlen,gocognit,lll
 	if len(magic.Magic) <= 2 { // nolint:gomnd
 		/* Old floppies have a valid MBR signature */
 		if ms.Pmagic[0] != 0x55 || ms.Pmagic[1] != 0xAA {
@@ -233,18 +373,77 @@ func isFATValidSuperblock(ms *msdosSuperBlock, vs *vfatSuperBlock, magic goblkid
 	if clusterCount > maxCount {
 		return false
 	}
-	/* TODO: missing whole-disk heuristic, to exclude MBRs that looks like FAT */ //nolint
+
+	/*
+	 * OK, seems like FAT, but it's possible that we found boot sector
+	 * with crazy FAT-like stuff (magic strings, media, etc..) before
+	 * MBR. Let's make sure that there is no MBR with usable partition.
+	 */
+	if wholeDisk && hasUsableMBREntry(ms) {
+		return false
+	}
 
 	return true
 }
 
+/* a whole disk is probed from its start and is not a partition device */
+func isWholeDisk(info *goblkid.ProbeInfo) bool {
+	return info.Offset == 0 && info.Devno == info.DiskDevno
+}
+
+func hasUsableMBREntry(ms *msdosSuperBlock) bool {
+	if ms.Pmagic[0] != 0x55 || ms.Pmagic[1] != 0xAA {
+		return false
+	}
+
+	p0 := ms.Dummy2[0x1be-0x3e:]
+	size := binary.LittleEndian.Uint32(p0[12:16])
+
+	return size != 0 && (p0[0] == 0 || p0[0] == 0x80)
+}
+
+// IsBootSector reports whether the 512 byte buffer holds a FAT or exFAT
+// boot sector, without the whole-disk check against MBR partition entries
+func IsBootSector(buf []byte) bool {
+	if len(buf) < SuperblockSize {
+		return false
+	}
+
+	if isExFATBootSector(buf[:SuperblockSize]) {
+		return true
+	}
+
+	ms, vs, err := vfatGetSuperblock(bytes.NewReader(buf))
+	if err != nil {
+		return false
+	}
+
+	for _, magic := range FATProber.MagicInfos {
+		off := magic.MagicByteOffset
+		if string(buf[off:off+uint64(len(magic.Magic))]) != magic.Magic {
+			continue
+		}
+
+		if isFATValidSuperblock(ms, vs, magic, false) {
+			return true
+		}
+	}
+
+	return false
+}
+
 var FATProber = goblkid.Prober{ // nolint: gochecknoglobals
 	Name:      FatName,
 	Usage:     goblkid.FilesystemProbe,
 	ProbeFunc: vfatProbe,
+	/*
+	 * The FAT32 strings are the file system type at 0x52 of the FAT32
+	 * boot sector, vs.Magic below, as in libblkid. 0x56 was four bytes
+	 * into that field and never matched what mkfs.fat writes.
+	 */
 	MagicInfos: []goblkid.MagicInfo{
 		{Magic: "MSWIN", SuperblockKbOffset: 0, MagicByteOffset: 0x52},     // nolint:gomnd
-		{Magic: "FAT32", SuperblockKbOffset: 0, MagicByteOffset: 0x56},     // nolint:gomnd
+		{Magic: "FAT32", SuperblockKbOffset: 0, MagicByteOffset: 0x52},     // nolint:gomnd
 		{Magic: "MSDOS", SuperblockKbOffset: 0, MagicByteOffset: 0x36},     // nolint:gomnd
 		{Magic: "FAT16", SuperblockKbOffset: 0, MagicByteOffset: 0x36},     // nolint:gomnd
 		{Magic: "FAT12", SuperblockKbOffset: 0, MagicByteOffset: 0x36},     // nolint:gomnd
@@ -17,3 +17,349 @@ func TestVfatWithMkfs(t *testing.T) {
 	assert.Equal(t, label, info.Label)
 }
 */
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/isi-lincoln/goblkid"
+	"github.com/stretchr/testify/assert"
+)
+
+/* fatImage is a small freshly formatted FAT volume laid out like mkfs.fat would */
+type fatImage struct {
+	bits        int
+	data        []byte
+	sectorSize  int
+	clusterSize int /* in sectors */
+	reserved    int
+	fats        int
+	rootEntries int
+	fatLength   int    /* in sectors */
+	next        uint32 /* cluster alloc hands out next */
+}
+
+func newFATImage(bits int) *fatImage {
+	f := &fatImage{bits: bits, sectorSize: 512, clusterSize: 4, reserved: 1, fats: 2, rootEntries: 512}
+	sectors := 0
+
+	switch bits {
+	case 12:
+		sectors, f.fatLength = 4096, 3
+	case 16:
+		sectors, f.fatLength = 32768, 32
+	case 32:
+		sectors, f.fatLength = 8192, 64
+		f.clusterSize, f.reserved, f.rootEntries = 1, 32, 0
+	}
+
+	f.data = make([]byte, sectors*f.sectorSize)
+	b := f.data
+
+	copy(b[0:], []byte{0xeb, 0x3c, 0x90})
+	copy(b[3:], "mkfs.fat")
+	binary.LittleEndian.PutUint16(b[0x0b:], uint16(f.sectorSize))
+	b[0x0d] = uint8(f.clusterSize)
+	binary.LittleEndian.PutUint16(b[0x0e:], uint16(f.reserved))
+	b[0x10] = uint8(f.fats)
+	binary.LittleEndian.PutUint16(b[0x11:], uint16(f.rootEntries))
+	b[0x15] = 0xf8
+
+	ebpb := 0x24
+
+	if bits == 32 {
+		binary.LittleEndian.PutUint32(b[0x20:], uint32(sectors))
+		binary.LittleEndian.PutUint32(b[0x24:], uint32(f.fatLength))
+		binary.LittleEndian.PutUint32(b[0x2c:], 2)
+		binary.LittleEndian.PutUint16(b[0x30:], 1)
+		binary.LittleEndian.PutUint16(b[0x32:], 6)
+		ebpb = 0x40
+	} else {
+		binary.LittleEndian.PutUint16(b[0x13:], uint16(sectors))
+		binary.LittleEndian.PutUint16(b[0x16:], uint16(f.fatLength))
+	}
+
+	b[ebpb+2] = FAT_EXT_BOOT_SIGNATURE
+	copy(b[ebpb+3:], []byte{0xcd, 0xab, 0x34, 0x12})
+	copy(b[ebpb+7:], "NO NAME    ")
+	copy(b[ebpb+18:], map[int]string{12: "FAT12   ", 16: "FAT16   ", 32: "FAT32   "}[bits])
+	b[0x1fe], b[0x1ff] = 0x55, 0xaa
+
+	f.setFAT(0, 0x0fffff00|0xf8)
+	f.setFAT(1, 0x0fffffff)
+
+	if bits == 32 {
+		f.setFAT(2, 0x0fffffff)
+
+		fsinfo := b[f.sectorSize:]
+		binary.LittleEndian.PutUint32(fsinfo[0:], FSINFO_LEAD_SIG)
+		binary.LittleEndian.PutUint32(fsinfo[484:], FSINFO_STRUC_SIG)
+		binary.LittleEndian.PutUint32(fsinfo[FSINFO_FREE_COUNT:], uint32(f.clusters()-1))
+		binary.LittleEndian.PutUint32(fsinfo[FSINFO_NEXT_FREE:], 3)
+		binary.LittleEndian.PutUint32(fsinfo[508:], FSINFO_TRAIL_SIG)
+
+		/* backup boot sector and FSInfo */
+		copy(b[6*f.sectorSize:], b[:2*f.sectorSize])
+	}
+
+	return f
+}
+
+/* clusters is the number of data clusters */
+func (f *fatImage) clusters() int {
+	sectors := len(f.data) / f.sectorSize
+	data := sectors - f.reserved - f.fats*f.fatLength - f.rootEntries*32/f.sectorSize
+
+	return data / f.clusterSize
+}
+
+/* setFAT sets a cluster entry in every FAT copy */
+func (f *fatImage) setFAT(cluster, value uint32) {
+	for i := 0; i < f.fats; i++ {
+		fat := f.data[(f.reserved+i*f.fatLength)*f.sectorSize:]
+
+		switch f.bits {
+		case 12:
+			off := cluster * 3 / 2
+			v := binary.LittleEndian.Uint16(fat[off:])
+
+			if cluster&1 == 0 {
+				v = v&0xf000 | uint16(value&0xfff)
+			} else {
+				v = v&0x000f | uint16(value&0xfff)<<4
+			}
+
+			binary.LittleEndian.PutUint16(fat[off:], v)
+		case 16:
+			binary.LittleEndian.PutUint16(fat[cluster*2:], uint16(value))
+		case 32:
+			binary.LittleEndian.PutUint32(fat[cluster*4:], value&0x0fffffff)
+		}
+	}
+}
+
+func (f *fatImage) ebpb() []byte {
+	if f.bits == 32 {
+		return f.data[0x40:0x5a]
+	}
+
+	return f.data[0x24:0x3e]
+}
+
+func (f *fatImage) setBootLabel(label string) {
+	copy(f.ebpb()[7:18], label+"           ")
+}
+
+/* rootOffset is where the root directory starts, cluster 2 for FAT32 */
+func (f *fatImage) rootOffset() int {
+	return (f.reserved + f.fats*f.fatLength) * f.sectorSize
+}
+
+/* addRootEntry writes a short directory entry into the first free root slot */
+func (f *fatImage) addRootEntry(name string, attr uint8) []byte {
+	root := f.data[f.rootOffset():]
+
+	for off := 0; ; off += 32 {
+		if root[off] == 0 {
+			ent := root[off : off+32]
+			copy(ent, name+"           "[:11-len(name)])
+			ent[11] = attr
+
+			return ent
+		}
+	}
+}
+
+func (f *fatImage) probe(t *testing.T) *goblkid.ProbeInfo {
+	t.Helper()
+
+	return f.probeWith(t, "")
+}
+
+/* probeWith probes the image decoding labels with the code page codePage */
+func (f *fatImage) probeWith(t *testing.T, codePage string) *goblkid.ProbeInfo {
+	t.Helper()
+
+	info := &goblkid.ProbeInfo{
+		DeviceReader: bytes.NewReader(f.data),
+		Size:         int64(len(f.data)),
+		FATCodePage:  codePage,
+	}
+	ok, err := Chain.Probe(info)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	return info
+}
+
+func TestFATLabels(t *testing.T) {
+	for _, bits := range []int{12, 16, 32} {
+		f := newFATImage(bits)
+		info := f.probe(t)
+		assert.Equal(t, FatName, info.ProbeName)
+		assert.Equal(t, "", info.Label, "FAT%d NO NAME", bits)
+		assert.NotContains(t, info.Tags, TagLabelFATBoot)
+		assert.Equal(t, "1234-ABCD", info.UUID.String())
+
+		/* formatted by Windows: label in the boot sector only */
+		f.setBootLabel("BOOTLBL")
+		info = f.probe(t)
+		assert.Equal(t, "BOOTLBL", info.Label, "FAT%d boot label", bits)
+		assert.Equal(t, "BOOTLBL", info.Tags[TagLabelFATBoot])
+
+		/* relabelled later: the root directory entry wins */
+		f.addRootEntry("README  TXT", 0x20)
+		f.addRootEntry("ROOTLBL", FAT_ATTR_VOLUME_ID)
+		info = f.probe(t)
+		assert.Equal(t, "ROOTLBL", info.Label, "FAT%d root label", bits)
+		assert.Equal(t, "BOOTLBL", info.Tags[TagLabelFATBoot])
+
+		/* a boot sector label of NO NAME is not exposed */
+		f.setBootLabel(FAT_NO_NAME)
+		info = f.probe(t)
+		assert.Equal(t, "ROOTLBL", info.Label)
+		assert.NotContains(t, info.Tags, TagLabelFATBoot)
+	}
+}
+
+func TestFATRootNoName(t *testing.T) {
+	f := newFATImage(16)
+	f.setBootLabel("BOOTLBL")
+	f.addRootEntry(FAT_NO_NAME, FAT_ATTR_VOLUME_ID)
+
+	info := f.probe(t)
+	assert.Equal(t, "BOOTLBL", info.Label)
+}
+
+func TestFATTruncatedRoot(t *testing.T) {
+	for _, bits := range []int{16, 32} {
+		f := newFATImage(bits)
+
+		/* the image ends in the middle of the fourth root directory entry */
+		for i := 0; i < 4; i++ {
+			copy(f.data[f.rootOffset()+i*32:], shortEntry(fmt.Sprintf("FILE%d", i), FAT_ATTR_ARCHIVE, 0, 0))
+		}
+
+		data := f.data[:f.rootOffset()+100]
+
+		info := &goblkid.ProbeInfo{DeviceReader: bytes.NewReader(data), Size: int64(len(data))}
+		ok, err := Chain.Probe(info)
+		assert.False(t, ok, "FAT%d", bits)
+		assert.NotNil(t, err, "FAT%d", bits)
+	}
+}
+
+func TestFAT32RootChain(t *testing.T) {
+	for _, sectors := range []int{1, 4} {
+		f := newFATImage(32)
+		f.clusterSize = sectors
+		f.data[0x0d] = uint8(sectors)
+		copy(f.data[6*f.sectorSize:], f.data[:f.sectorSize])
+
+		/* the root directory fills cluster 2 and goes on in cluster 5 */
+		first := f.data[f.clusterOffset(2):f.clusterOffset(3)]
+		for off := 0; off < len(first); off += 32 {
+			copy(first[off:], shortEntry(fmt.Sprintf("FILE%d", off/32), FAT_ATTR_ARCHIVE, 0, 0))
+		}
+
+		f.setFAT(2, 5)
+		f.setFAT(5, 0x0fffffff)
+		copy(f.data[f.clusterOffset(5):], shortEntry("CHAINED", FAT_ATTR_VOLUME_ID, 0, 0))
+
+		info := f.probe(t)
+		assert.Equal(t, "CHAINED", info.Label, "%d sector clusters", sectors)
+
+		/* the last entry of the first cluster is read, and the first label wins */
+		copy(first[len(first)-32:], shortEntry("FIRST", FAT_ATTR_VOLUME_ID, 0, 0))
+
+		info = f.probe(t)
+		assert.Equal(t, "FIRST", info.Label, "%d sector clusters", sectors)
+	}
+}
+
+func TestFATLabelCodePage(t *testing.T) {
+	f := newFATImage(16)
+	f.setBootLabel("CAF\x90")
+	ent := f.addRootEntry("\x05T\x90", FAT_ATTR_VOLUME_ID)
+
+	info := f.probe(t)
+	assert.Equal(t, "σTÉ", info.Label)
+	assert.Equal(t, "\x05T\x90", info.Tags[TagLabelRaw])
+	assert.Equal(t, "CAFÉ", info.Tags[TagLabelFATBoot])
+
+	copy(ent, "M\xdcNCHEN    ")
+	info = f.probeWith(t, "cp1252")
+	assert.Equal(t, "MÜNCHEN", info.Label)
+	assert.Equal(t, "M\xdcNCHEN", info.Tags[TagLabelRaw])
+
+	copy(ent, "PLAIN      ")
+	info = f.probeWith(t, "cp1252")
+	assert.Equal(t, "PLAIN", info.Label)
+	assert.NotContains(t, info.Tags, TagLabelRaw)
+
+	info = &goblkid.ProbeInfo{DeviceReader: bytes.NewReader(f.data), FATCodePage: "cp999"}
+	ok, err := Chain.Probe(info)
+	assert.False(t, ok)
+	assert.NotNil(t, err)
+}
+
+func TestFATSizes(t *testing.T) {
+	for _, bits := range []int{12, 16, 32} {
+		f := newFATImage(bits)
+		f.setFAT(3, 4)
+		f.setFAT(4, 0x0fffffff)
+
+		info := f.probe(t)
+		assert.Equal(t, fmt.Sprintf("FAT%d", bits), info.Version)
+		assert.Equal(t, uint64(len(f.data)), info.FSSize)
+		assert.Equal(t, uint64(f.clusterSize*f.sectorSize), info.FSBlockSize)
+		assert.Equal(t, uint64(f.clusters()), info.FSLastBlock)
+
+		if bits == 32 {
+			/* taken from FSInfo, which mkfs left at one cluster for the root */
+			assert.Equal(t, uint64(f.clusters()-1), info.FreeBlocks)
+			assert.NotContains(t, info.Tags, TagFreeUnknown)
+		} else {
+			/* no count without reading the whole FAT, left to ReadHealth */
+			assert.Equal(t, uint64(0), info.FreeBlocks, "FAT%d", bits)
+			assert.Equal(t, "1", info.Tags[TagFreeUnknown], "FAT%d", bits)
+		}
+	}
+}
+
+/* each magic alone must find its volume, the FAT32 string sits at 0x52 as in libblkid */
+func TestFATMagicOffsets(t *testing.T) {
+	for bits, name := range map[int]string{12: "FAT12", 16: "FAT16", 32: "FAT32"} {
+		f := newFATImage(bits)
+
+		var magic goblkid.MagicInfo
+
+		for _, m := range FATProber.MagicInfos {
+			if m.Magic == name {
+				magic = m
+			}
+		}
+
+		only := FATProber
+		only.MagicInfos = []goblkid.MagicInfo{magic}
+
+		info := &goblkid.ProbeInfo{DeviceReader: bytes.NewReader(f.data)}
+		ok, err := only.Probe(info)
+		assert.Nil(t, err)
+		assert.True(t, ok, "%s magic at 0x%x", name, magic.MagicByteOffset)
+		assert.Equal(t, name, info.Version)
+	}
+
+	/* nothing but the FAT32 string at 0x52 identifies this one */
+	f := newFATImage(32)
+	f.data[0] = 0
+	f.data[0x1fe], f.data[0x1ff] = 0, 0
+
+	info := &goblkid.ProbeInfo{DeviceReader: bytes.NewReader(f.data)}
+	ok, err := FATProber.Probe(info)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "FAT32", info.Version)
+}
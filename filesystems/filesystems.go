@@ -0,0 +1,19 @@
+package filesystems
+
+import (
+	"github.com/isi-lincoln/goblkid"
+	"github.com/isi-lincoln/goblkid/ext"
+	"github.com/isi-lincoln/goblkid/fat"
+)
+
+// Chains are the filesystem probers get info, wipe, set and check try, in
+// that order
+var Chains = []goblkid.Chain{ // nolint:gochecknoglobals
+	ext.Chain,
+	fat.Chain,
+}
+
+// Probe runs Chains over info until one matches
+func Probe(info *goblkid.ProbeInfo) (bool, error) {
+	return goblkid.ProbeAll(info, Chains)
+}
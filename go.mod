@@ -1,6 +1,6 @@
 module github.com/isi-lincoln/goblkid
 
-go 1.13
+go 1.16
 
 require (
 	github.com/elazarl/goblkid v0.0.0-20180117165929-73c103321946
@@ -9,4 +9,5 @@ require (
 	github.com/sirupsen/logrus v1.5.0
 	github.com/spf13/cobra v1.0.0
 	github.com/stretchr/testify v1.4.0
+	golang.org/x/sys v0.0.0-20190922100055-0a153f010e69
 )
@@ -0,0 +1,164 @@
+package partitions
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"unicode/utf16"
+
+	"github.com/isi-lincoln/goblkid"
+)
+
+// GPT constant values
+const (
+	GPTSignature       = "EFI PART"
+	GPTHeaderMinSize   = 92
+	GPTEntryMinSize    = 128
+	GPTNameLength      = 36
+	GPTMaxEntries      = 4096
+	GPTRevision        = 0x00010000
+	GPTDefaultEntries  = 128
+	gptEntryArrayLimit = 1 << 20 // nolint:gomnd
+)
+
+// NewGUID returns a random version 4 guid
+func NewGUID() (goblkid.UUID, error) {
+	g := goblkid.UUID{Format: goblkid.GUID}
+
+	if _, err := rand.Read(g.Raw[:]); err != nil {
+		return g, err
+	}
+
+	g.Raw[7] = g.Raw[7]&0x0f | 0x40 /* version 4, high nibble of time_hi */
+	g.Raw[8] = g.Raw[8]&0x3f | 0x80 /* RFC 4122 variant */
+
+	return g, nil
+}
+
+// GPTHeader is the decoded GUID partition table header
+type GPTHeader struct {
+	Signature      [8]uint8
+	Revision       uint32
+	HeaderSize     uint32
+	HeaderCRC32    uint32
+	Reserved       uint32
+	MyLBA          uint64
+	AlternateLBA   uint64
+	FirstUsableLBA uint64
+	LastUsableLBA  uint64
+	DiskGUID       goblkid.UUID
+	EntriesLBA     uint64
+	NumEntries     uint32
+	EntrySize      uint32
+	EntriesCRC32   uint32
+}
+
+// GPTEntry is a single partition entry of the GPT entry array
+type GPTEntry struct {
+	TypeGUID   goblkid.UUID
+	UniqueGUID goblkid.UUID
+	StartLBA   uint64
+	EndLBA     uint64
+	Attributes uint64
+	Name       string
+}
+
+// IsEmpty reports whether the entry is unused
+func (e *GPTEntry) IsEmpty() bool {
+	return e.TypeGUID.IsZero()
+}
+
+// GPT is a validated header together with its entry array
+type GPT struct {
+	Header  GPTHeader
+	Entries []GPTEntry
+	// Backup is true when the table was read from the alternate header
+	Backup bool
+}
+
+func parseGPTHeader(buf []byte) (*GPTHeader, error) {
+	if len(buf) < GPTHeaderMinSize || string(buf[0:8]) != GPTSignature {
+		return nil, fmt.Errorf("no GPT signature")
+	}
+
+	h := &GPTHeader{}
+	copy(h.Signature[:], buf[0:8])
+	h.Revision = binary.LittleEndian.Uint32(buf[8:12])
+	h.HeaderSize = binary.LittleEndian.Uint32(buf[12:16])
+	h.HeaderCRC32 = binary.LittleEndian.Uint32(buf[16:20])
+	h.Reserved = binary.LittleEndian.Uint32(buf[20:24])
+	h.MyLBA = binary.LittleEndian.Uint64(buf[24:32])
+	h.AlternateLBA = binary.LittleEndian.Uint64(buf[32:40])
+	h.FirstUsableLBA = binary.LittleEndian.Uint64(buf[40:48])
+	h.LastUsableLBA = binary.LittleEndian.Uint64(buf[48:56])
+	h.DiskGUID = goblkid.NewUUID(goblkid.GUID, buf[56:72])
+	h.EntriesLBA = binary.LittleEndian.Uint64(buf[72:80])
+	h.NumEntries = binary.LittleEndian.Uint32(buf[80:84])
+	h.EntrySize = binary.LittleEndian.Uint32(buf[84:88])
+	h.EntriesCRC32 = binary.LittleEndian.Uint32(buf[88:92])
+
+	if h.HeaderSize < GPTHeaderMinSize || int(h.HeaderSize) > len(buf) {
+		return nil, fmt.Errorf("bad GPT header size: %d", h.HeaderSize)
+	}
+
+	hdr := make([]byte, h.HeaderSize)
+	copy(hdr, buf[:h.HeaderSize])
+	binary.LittleEndian.PutUint32(hdr[16:20], 0)
+
+	if crc := crc32.ChecksumIEEE(hdr); crc != h.HeaderCRC32 {
+		return nil, fmt.Errorf("GPT header checksum mismatch: %08x != %08x", crc, h.HeaderCRC32)
+	}
+
+	if h.EntrySize < GPTEntryMinSize || h.EntrySize%8 != 0 {
+		return nil, fmt.Errorf("bad GPT entry size: %d", h.EntrySize)
+	}
+
+	if h.NumEntries == 0 || h.NumEntries > GPTMaxEntries ||
+		uint64(h.NumEntries)*uint64(h.EntrySize) > gptEntryArrayLimit {
+		return nil, fmt.Errorf("bad GPT entry count: %d", h.NumEntries)
+	}
+
+	if h.FirstUsableLBA > h.LastUsableLBA {
+		return nil, fmt.Errorf("bad GPT usable range: %d > %d", h.FirstUsableLBA, h.LastUsableLBA)
+	}
+
+	return h, nil
+}
+
+func parseGPTEntries(h *GPTHeader, buf []byte) ([]GPTEntry, error) {
+	if crc := crc32.ChecksumIEEE(buf); crc != h.EntriesCRC32 {
+		return nil, fmt.Errorf("GPT entries checksum mismatch: %08x != %08x", crc, h.EntriesCRC32)
+	}
+
+	entries := make([]GPTEntry, 0, h.NumEntries)
+
+	for i := uint32(0); i < h.NumEntries; i++ {
+		b := buf[i*h.EntrySize : (i+1)*h.EntrySize]
+		e := GPTEntry{}
+		e.TypeGUID = goblkid.NewUUID(goblkid.GUID, b[0:16])
+		e.UniqueGUID = goblkid.NewUUID(goblkid.GUID, b[16:32])
+		e.StartLBA = binary.LittleEndian.Uint64(b[32:40])
+		e.EndLBA = binary.LittleEndian.Uint64(b[40:48])
+		e.Attributes = binary.LittleEndian.Uint64(b[48:56])
+		e.Name = decodeGPTName(b[56:128])
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+func decodeGPTName(b []byte) string {
+	u := make([]uint16, 0, GPTNameLength)
+
+	for i := 0; i+1 < len(b); i += 2 {
+		c := binary.LittleEndian.Uint16(b[i : i+2])
+		if c == 0 {
+			break
+		}
+
+		u = append(u, c)
+	}
+
+	return string(utf16.Decode(u))
+}
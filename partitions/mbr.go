@@ -0,0 +1,115 @@
+package partitions
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// MBR constant values
+const (
+	MBRSize            = 512
+	MBRPartitionOffset = 0x1be
+	MBRSignatureOffset = 0x1fe
+	MBRIDOffset        = 0x1b8
+	MBRPartitionCount  = 4
+	MBREntrySize       = 16
+
+	MBRTypeEmpty       = 0x00
+	MBRTypeExtended    = 0x05
+	MBRTypeExtendedLBA = 0x0f
+	MBRTypeLinuxExt    = 0x85
+	MBRTypeGPT         = 0xee
+	MBRTypeEFI         = 0xef
+
+	MBRBootable = 0x80
+)
+
+// MBRPartition is a single entry of the four primary MBR slots
+type MBRPartition struct {
+	Status   uint8
+	FirstCHS [3]uint8
+	Type     uint8
+	LastCHS  [3]uint8
+	StartLBA uint32
+	Sectors  uint32
+}
+
+// IsEmpty reports whether the slot is unused
+func (p *MBRPartition) IsEmpty() bool {
+	return p.Type == MBRTypeEmpty || p.Sectors == 0
+}
+
+// IsExtended reports whether the slot is an extended partition container
+func (p *MBRPartition) IsExtended() bool {
+	return p.Type == MBRTypeExtended ||
+		p.Type == MBRTypeExtendedLBA ||
+		p.Type == MBRTypeLinuxExt
+}
+
+// MBR is the decoded content of sector 0
+type MBR struct {
+	DiskID     uint32
+	Partitions [MBRPartitionCount]MBRPartition
+	Signature  [2]uint8
+}
+
+// HasSignature reports whether the 0x55AA boot signature is present
+func (m *MBR) HasSignature() bool {
+	return m.Signature[0] == 0x55 && m.Signature[1] == 0xAA
+}
+
+// Used returns the slot indexes which are not empty
+func (m *MBR) Used() []int {
+	used := []int{}
+
+	for i := range m.Partitions {
+		if !m.Partitions[i].IsEmpty() {
+			used = append(used, i)
+		}
+	}
+
+	return used
+}
+
+// ProtectiveSlots returns the slot indexes holding a 0xEE GPT protective entry
+func (m *MBR) ProtectiveSlots() []int {
+	slots := []int{}
+
+	for i := range m.Partitions {
+		if m.Partitions[i].Type == MBRTypeGPT {
+			slots = append(slots, i)
+		}
+	}
+
+	return slots
+}
+
+func (m *MBR) firstUsable() bool {
+	p := m.Partitions[0]
+	return p.Sectors != 0 && (p.Status == 0 || p.Status == MBRBootable)
+}
+
+// ID returns the disk identifier in the form printed by blkid as PTUUID
+func (m *MBR) ID() string {
+	return fmt.Sprintf("%08x", m.DiskID)
+}
+
+func parseMBR(buf []byte) *MBR {
+	m := &MBR{}
+	m.DiskID = binary.LittleEndian.Uint32(buf[MBRIDOffset : MBRIDOffset+4])
+
+	for i := range m.Partitions {
+		e := buf[MBRPartitionOffset+i*MBREntrySize : MBRPartitionOffset+(i+1)*MBREntrySize]
+		p := &m.Partitions[i]
+		p.Status = e[0]
+		copy(p.FirstCHS[:], e[1:4])
+		p.Type = e[4]
+		copy(p.LastCHS[:], e[5:8])
+		p.StartLBA = binary.LittleEndian.Uint32(e[8:12])
+		p.Sectors = binary.LittleEndian.Uint32(e[12:16])
+	}
+
+	copy(m.Signature[:], buf[MBRSignatureOffset:MBRSignatureOffset+2])
+
+	return m
+}
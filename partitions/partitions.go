@@ -0,0 +1,386 @@
+package partitions
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/isi-lincoln/goblkid"
+	"github.com/isi-lincoln/goblkid/fat"
+)
+
+// Partition table types, named as blkid reports PTTYPE
+const (
+	DosName  = "dos"
+	GPTName  = "gpt"
+	PMBRName = "PMBR"
+)
+
+// ErrNotFound is returned when neither an MBR nor a GPT is present
+var ErrNotFound = errors.New("no partition table found")
+
+// Layout describes how sector 0 relates to a GUID partition table
+type Layout int
+
+// Layout mappings
+const (
+	// LayoutMBR is a plain dos partition table without a GPT
+	LayoutMBR Layout = iota
+	// LayoutProtective is a GPT behind a protective MBR
+	LayoutProtective
+	// LayoutHybrid is a GPT behind an MBR with real entries next to 0xEE
+	LayoutHybrid
+	// LayoutLostProtective is a GPT whose protective MBR is gone
+	LayoutLostProtective
+	// LayoutOrphanProtective is a protective MBR without a readable GPT
+	LayoutOrphanProtective
+)
+
+func (l Layout) String() string {
+	switch l {
+	case LayoutMBR:
+		return "mbr"
+	case LayoutProtective:
+		return "protective-mbr"
+	case LayoutHybrid:
+		return "hybrid-mbr"
+	case LayoutLostProtective:
+		return "gpt-without-pmbr"
+	case LayoutOrphanProtective:
+		return "pmbr-without-gpt"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(l))
+	}
+}
+
+// Partition is a table-independent view of a single partition
+type Partition struct {
+	Number   int
+	Start    uint64 /* in sectors */
+	Sectors  uint64
+	Type     string /* "0x83" for dos, type guid for gpt */
+	UUID     string
+	Name     string
+	Bootable bool
+}
+
+// Table is the result of probing a device for partition tables
+type Table struct {
+	Type       string
+	ID         string
+	SectorSize uint64
+	Layout     Layout
+
+	// Diagnostics explain non-trivial layouts in human readable form
+	Diagnostics []string
+
+	MBR        *MBR
+	GPT        *GPT
+	Partitions []Partition
+}
+
+func (t *Table) diag(format string, args ...interface{}) {
+	t.Diagnostics = append(t.Diagnostics, fmt.Sprintf(format, args...))
+}
+
+// Probe reads the partition table of the device behind info
+func Probe(info *goblkid.ProbeInfo) (*Table, error) {
+	r := info.DeviceReader
+
+	buf, err := readAt(r, info.Offset, MBRSize)
+	if err != nil {
+		return nil, err
+	}
+
+	mbr := parseMBR(buf)
+	if !mbr.HasSignature() || !validMBRStatus(mbr) || isFATBootSector(buf, mbr) {
+		mbr = nil
+	}
+
+	size, err := deviceSize(info)
+	if err != nil {
+		return nil, err
+	}
+
+	gpt, sectorSize, gptErr := findGPT(info, size)
+
+	t := &Table{SectorSize: sectorSizeOrDefault(sectorSize), MBR: mbr, GPT: gpt}
+
+	if gpt != nil && gpt.Backup {
+		t.diag("primary GPT header is damaged (%v); using the backup header at LBA %d",
+			gptErr, gpt.Header.MyLBA)
+	}
+
+	var protective, legacy []int
+	if mbr != nil {
+		protective = mbr.ProtectiveSlots()
+		legacy = difference(mbr.Used(), protective)
+	}
+
+	switch {
+	case len(protective) > 0 && gpt == nil:
+		t.Type = PMBRName
+		t.Layout = LayoutOrphanProtective
+		t.ID = mbr.ID()
+		t.diag("protective MBR (type 0xee) found but no valid GPT: %v", gptErr)
+	case len(protective) > 0 && len(legacy) > 0:
+		t.useGPT(gpt)
+		t.Layout = LayoutHybrid
+		t.diag("hybrid MBR: %d legacy entries next to the 0xee entry; using GPT, MBR entries ignored",
+			len(legacy))
+		t.checkHybrid(legacy)
+	case len(protective) > 0:
+		t.useGPT(gpt)
+		t.Layout = LayoutProtective
+		t.diag("protective MBR found; using GPT")
+		t.checkProtective(protective, size)
+	case gpt != nil && (mbr == nil || len(legacy) == 0):
+		t.useGPT(gpt)
+		t.Layout = LayoutLostProtective
+		t.diag("valid GPT found but sector 0 has no protective MBR; using GPT, " +
+			"the protective MBR should be recreated")
+	case mbr != nil:
+		t.useMBR(mbr)
+		t.Layout = LayoutMBR
+
+		if gpt != nil {
+			t.diag("MBR has no 0xee entry but a valid GPT is present; " +
+				"using MBR, the GPT is stale or the MBR was rewritten")
+		}
+	default:
+		return nil, ErrNotFound
+	}
+
+	return t, nil
+}
+
+func (t *Table) useMBR(mbr *MBR) {
+	t.Type = DosName
+	t.ID = mbr.ID()
+
+	for i, p := range mbr.Partitions {
+		if p.IsEmpty() {
+			continue
+		}
+
+		t.Partitions = append(t.Partitions, Partition{
+			Number:   i + 1,
+			Start:    uint64(p.StartLBA),
+			Sectors:  uint64(p.Sectors),
+			Type:     fmt.Sprintf("0x%x", p.Type),
+			UUID:     fmt.Sprintf("%s-%02d", t.ID, i+1),
+			Bootable: p.Status == MBRBootable,
+		})
+	}
+}
+
+func (t *Table) useGPT(gpt *GPT) {
+	t.Type = GPTName
+	t.ID = gpt.Header.DiskGUID.String()
+
+	for i, e := range gpt.Entries {
+		if e.IsEmpty() {
+			continue
+		}
+
+		t.Partitions = append(t.Partitions, Partition{
+			Number:  i + 1,
+			Start:   e.StartLBA,
+			Sectors: e.EndLBA - e.StartLBA + 1,
+			Type:    e.TypeGUID.String(),
+			UUID:    e.UniqueGUID.String(),
+			Name:    e.Name,
+		})
+	}
+}
+
+func (t *Table) checkProtective(slots []int, size int64) {
+	if len(slots) > 1 {
+		t.diag("protective MBR has %d 0xee entries, expected 1", len(slots))
+	}
+
+	p := t.MBR.Partitions[slots[0]]
+	if p.StartLBA != 1 {
+		t.diag("protective entry starts at LBA %d, expected 1", p.StartLBA)
+	}
+
+	if size <= 0 {
+		return
+	}
+
+	want := uint64(size)/t.SectorSize - 1
+	if want > 0xffffffff {
+		want = 0xffffffff
+	}
+
+	if uint64(p.Sectors) != want {
+		t.diag("protective entry covers %d sectors, expected %d", p.Sectors, want)
+	}
+}
+
+func (t *Table) checkHybrid(legacy []int) {
+	for _, i := range legacy {
+		p := t.MBR.Partitions[i]
+		found := false
+
+		for _, gp := range t.Partitions {
+			if gp.Start == uint64(p.StartLBA) && gp.Sectors == uint64(p.Sectors) {
+				t.diag("hybrid MBR entry %d (type 0x%02x) mirrors GPT partition %d",
+					i+1, p.Type, gp.Number)
+
+				found = true
+
+				break
+			}
+		}
+
+		if !found {
+			t.diag("hybrid MBR entry %d (type 0x%02x, start %d, %d sectors) matches no GPT partition",
+				i+1, p.Type, p.StartLBA, p.Sectors)
+		}
+	}
+}
+
+// findGPT looks for a primary header at LBA 1 and falls back to the
+// alternate header in the last sector of the device. When the sector size
+// is not known both 512 and 4096 byte sectors are tried.
+func findGPT(info *goblkid.ProbeInfo, size int64) (*GPT, uint64, error) {
+	sizes := []uint64{info.BlockSize}
+	if info.BlockSize == 0 {
+		sizes = []uint64{goblkid.DefaultSectorSize, 4096} // nolint:gomnd
+	}
+
+	var firstErr error
+
+	for _, ss := range sizes {
+		gpt, err := readGPT(info, 1, ss)
+		if err == nil {
+			return gpt, ss, nil
+		}
+
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if size <= 0 {
+		return nil, info.BlockSize, firstErr
+	}
+
+	for _, ss := range sizes {
+		lastLBA := uint64(size)/ss - 1
+
+		gpt, err := readGPT(info, lastLBA, ss)
+		if err == nil {
+			gpt.Backup = true
+			return gpt, ss, firstErr
+		}
+	}
+
+	return nil, info.BlockSize, firstErr
+}
+
+func readGPT(info *goblkid.ProbeInfo, lba, sectorSize uint64) (*GPT, error) {
+	buf, err := readAt(info.DeviceReader, info.Offset+int64(lba*sectorSize), int(sectorSize))
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := parseGPTHeader(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.MyLBA != lba {
+		return nil, fmt.Errorf("GPT header claims LBA %d, read from LBA %d", h.MyLBA, lba)
+	}
+
+	buf, err = readAt(info.DeviceReader,
+		info.Offset+int64(h.EntriesLBA*sectorSize), int(h.NumEntries*h.EntrySize))
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := parseGPTEntries(h, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GPT{Header: *h, Entries: entries}, nil
+}
+
+/* boot indicator must be 0x00 or 0x80, anything else is not a partition table */
+func validMBRStatus(mbr *MBR) bool {
+	for _, p := range mbr.Partitions {
+		if p.Status != 0 && p.Status != MBRBootable {
+			return false
+		}
+	}
+
+	return true
+}
+
+/*
+ * Now that the 55aa signature is present, this is probably either the boot
+ * sector of a FAT filesystem or a DOS-type partition table. A FAT-looking
+ * boot sector only loses to the table when the first entry is usable, the
+ * same rule the FAT prober applies from its side.
+ */
+func isFATBootSector(buf []byte, mbr *MBR) bool {
+	return fat.IsBootSector(buf) && !mbr.firstUsable()
+}
+
+func deviceSize(info *goblkid.ProbeInfo) (int64, error) {
+	if info.Size > 0 {
+		return info.Size, nil
+	}
+
+	end, err := info.DeviceReader.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+
+	return end - info.Offset, nil
+}
+
+func readAt(r io.ReadSeeker, off int64, n int) ([]byte, error) {
+	if _, err := r.Seek(off, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+func sectorSizeOrDefault(ss uint64) uint64 {
+	if ss == 0 {
+		return goblkid.DefaultSectorSize
+	}
+
+	return ss
+}
+
+func difference(a, b []int) []int {
+	out := []int{}
+
+	for _, x := range a {
+		found := false
+
+		for _, y := range b {
+			if x == y {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			out = append(out, x)
+		}
+	}
+
+	return out
+}
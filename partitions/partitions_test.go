@@ -0,0 +1,139 @@
+package partitions
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+
+	"github.com/isi-lincoln/goblkid"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testSectors = 2048
+	testSS      = 512
+)
+
+func newImage() []byte {
+	return make([]byte, testSectors*testSS)
+}
+
+func putMBREntry(img []byte, slot int, typ uint8, start, sectors uint32) {
+	e := img[MBRPartitionOffset+slot*MBREntrySize:]
+	e[4] = typ
+	binary.LittleEndian.PutUint32(e[8:12], start)
+	binary.LittleEndian.PutUint32(e[12:16], sectors)
+	img[MBRSignatureOffset] = 0x55
+	img[MBRSignatureOffset+1] = 0xAA
+}
+
+func putGPT(img []byte, start, end uint64) {
+	entries := make([]byte, 128*128)
+	entries[0] = 0xaf /* any non-zero type guid */
+	entries[16] = 0x01
+	binary.LittleEndian.PutUint64(entries[32:40], start)
+	binary.LittleEndian.PutUint64(entries[40:48], end)
+
+	put := func(lba, alt, entriesLBA uint64) {
+		h := make([]byte, 92)
+		copy(h, GPTSignature)
+		binary.LittleEndian.PutUint32(h[8:12], 0x00010000)
+		binary.LittleEndian.PutUint32(h[12:16], 92)
+		binary.LittleEndian.PutUint64(h[24:32], lba)
+		binary.LittleEndian.PutUint64(h[32:40], alt)
+		binary.LittleEndian.PutUint64(h[40:48], 34)
+		binary.LittleEndian.PutUint64(h[48:56], testSectors-34)
+		binary.LittleEndian.PutUint64(h[72:80], entriesLBA)
+		binary.LittleEndian.PutUint32(h[80:84], 128)
+		binary.LittleEndian.PutUint32(h[84:88], 128)
+		binary.LittleEndian.PutUint32(h[88:92], crc32.ChecksumIEEE(entries))
+		binary.LittleEndian.PutUint32(h[16:20], crc32.ChecksumIEEE(h))
+		copy(img[lba*testSS:], h)
+		copy(img[entriesLBA*testSS:], entries)
+	}
+
+	put(1, testSectors-1, 2)
+	put(testSectors-1, 1, testSectors-33)
+}
+
+func probe(t *testing.T, img []byte) *Table {
+	t.Helper()
+
+	table, err := Probe(&goblkid.ProbeInfo{DeviceReader: bytes.NewReader(img)})
+	assert.Nil(t, err)
+
+	return table
+}
+
+func TestProtectiveMBR(t *testing.T) {
+	img := newImage()
+	putMBREntry(img, 0, MBRTypeGPT, 1, testSectors-1)
+	putGPT(img, 34, 1000)
+
+	table := probe(t, img)
+	assert.Equal(t, GPTName, table.Type)
+	assert.Equal(t, LayoutProtective, table.Layout)
+	assert.Equal(t, 1, len(table.Partitions))
+	assert.Equal(t, uint64(967), table.Partitions[0].Sectors)
+	assert.Equal(t, 1, len(table.Diagnostics))
+}
+
+func TestHybridMBR(t *testing.T) {
+	img := newImage()
+	putMBREntry(img, 0, MBRTypeGPT, 1, 33)
+	putMBREntry(img, 1, 0x07, 34, 967)
+	putMBREntry(img, 2, 0x0b, 1100, 100)
+	putGPT(img, 34, 1000)
+
+	table := probe(t, img)
+	assert.Equal(t, GPTName, table.Type)
+	assert.Equal(t, LayoutHybrid, table.Layout)
+	assert.Contains(t, table.Diagnostics[1], "mirrors GPT partition 1")
+	assert.Contains(t, table.Diagnostics[2], "matches no GPT partition")
+}
+
+func TestLostProtectiveMBR(t *testing.T) {
+	img := newImage()
+	putGPT(img, 34, 1000)
+
+	table := probe(t, img)
+	assert.Equal(t, GPTName, table.Type)
+	assert.Equal(t, LayoutLostProtective, table.Layout)
+}
+
+func TestBackupGPT(t *testing.T) {
+	img := newImage()
+	putMBREntry(img, 0, MBRTypeGPT, 1, testSectors-1)
+	putGPT(img, 34, 1000)
+	img[testSS] = 0
+
+	table := probe(t, img)
+	assert.Equal(t, GPTName, table.Type)
+	assert.True(t, table.GPT.Backup)
+}
+
+func TestOrphanProtectiveMBR(t *testing.T) {
+	img := newImage()
+	putMBREntry(img, 0, MBRTypeGPT, 1, testSectors-1)
+
+	table := probe(t, img)
+	assert.Equal(t, PMBRName, table.Type)
+	assert.Equal(t, LayoutOrphanProtective, table.Layout)
+	assert.Equal(t, 0, len(table.Partitions))
+}
+
+func TestPlainMBR(t *testing.T) {
+	img := newImage()
+	putMBREntry(img, 0, 0x83, 2048, 100)
+
+	table := probe(t, img)
+	assert.Equal(t, DosName, table.Type)
+	assert.Equal(t, "0x83", table.Partitions[0].Type)
+	assert.Equal(t, 0, len(table.Diagnostics))
+}
+
+func TestNoTable(t *testing.T) {
+	_, err := Probe(&goblkid.ProbeInfo{DeviceReader: bytes.NewReader(newImage())})
+	assert.Equal(t, ErrNotFound, err)
+}
@@ -0,0 +1,165 @@
+package partitions
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/isi-lincoln/goblkid"
+	"github.com/isi-lincoln/goblkid/fat"
+)
+
+// Interpretation is the reading chosen for the start of a whole disk
+type Interpretation int
+
+// Interpretation mappings
+const (
+	// InterpretNothing means neither a table nor a filesystem was found
+	InterpretNothing Interpretation = iota
+	// InterpretTable means the disk is partitioned
+	InterpretTable
+	// InterpretFilesystem means a filesystem was written to the whole disk
+	InterpretFilesystem
+)
+
+func (i Interpretation) String() string {
+	switch i {
+	case InterpretNothing:
+		return "nothing"
+	case InterpretTable:
+		return "partition table"
+	case InterpretFilesystem:
+		return "whole-disk filesystem"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(i))
+	}
+}
+
+// Resolution is the interpretation chosen for a whole disk and why
+type Resolution struct {
+	Interpretation Interpretation
+	Reason         string
+
+	// Table is set when a partition table was found, even if it lost
+	Table *Table
+	// Filesystem is the whole-disk probe result, set when one matched
+	Filesystem *goblkid.ProbeInfo
+}
+
+// Resolve decides whether the disk behind info is partitioned or holds a
+// filesystem written straight to the whole disk, possibly on top of an
+// old partition table. chains are the filesystem probers to consider.
+func Resolve(info *goblkid.ProbeInfo, chains []goblkid.Chain) (*Resolution, error) {
+	r := &Resolution{}
+
+	table, err := Probe(info)
+	if err != nil && err != ErrNotFound {
+		return nil, err
+	}
+
+	r.Table = table
+
+	fs, err := probeFilesystem(info, info.Offset, info.Size, chains)
+	if err != nil {
+		return nil, err
+	}
+
+	r.Filesystem = fs
+
+	sector0, err := readAt(info.DeviceReader, info.Offset, MBRSize)
+	if err != nil {
+		return nil, err
+	}
+
+	fatLike := fat.IsBootSector(sector0)
+
+	switch {
+	case table == nil && fs == nil:
+		r.Interpretation = InterpretNothing
+		r.Reason = "no partition table or filesystem signature found"
+	case fs == nil:
+		r.Interpretation = InterpretTable
+		r.Reason = fmt.Sprintf("%s partition table found and no whole-disk filesystem", table.Type)
+
+		if fatLike {
+			r.Reason = "sector 0 looks like a FAT boot sector, but partition entry 1 is usable; " +
+				"using the partition table"
+		}
+	case table == nil:
+		r.Interpretation = InterpretFilesystem
+		r.Reason = fmt.Sprintf("whole-disk %s filesystem and no partition table", fs.ProbeName)
+
+		if fatLike && parseMBR(sector0).HasSignature() {
+			r.Reason = fmt.Sprintf("sector 0 is a %s boot sector with no usable partition entries; "+
+				"the disk is a superfloppy", fs.ProbeName)
+		}
+	default:
+		return r, r.resolveConflict(info, chains)
+	}
+
+	return r, nil
+}
+
+/*
+ * Both a table and a whole-disk filesystem are present. Most filesystems
+ * leave sector 0 alone, so a disk reformatted without wiping keeps its old
+ * table. The side whose content still makes sense wins: a table whose
+ * partitions hold filesystems is live, one pointing at nothing is stale.
+ */
+func (r *Resolution) resolveConflict(info *goblkid.ProbeInfo, chains []goblkid.Chain) error {
+	t := r.Table
+	fs := r.Filesystem
+
+	for _, p := range t.Partitions {
+		start := int64(p.Start * t.SectorSize)
+
+		pfs, err := probeFilesystem(info, info.Offset+start, int64(p.Sectors*t.SectorSize), chains)
+		if err != nil {
+			return err
+		}
+
+		if pfs != nil {
+			r.Interpretation = InterpretTable
+			r.Reason = fmt.Sprintf("partition %d holds a %s filesystem; "+
+				"the whole-disk %s signature is stale", p.Number, pfs.ProbeName, fs.ProbeName)
+
+			return nil
+		}
+	}
+
+	r.Interpretation = InterpretFilesystem
+	r.Reason = fmt.Sprintf("whole-disk %s filesystem found and none of the %d %s "+
+		"partitions holds a known filesystem; the partition table is stale",
+		fs.ProbeName, len(t.Partitions), t.Type)
+
+	return nil
+}
+
+func probeFilesystem(dev *goblkid.ProbeInfo, offset, size int64, chains []goblkid.Chain) (*goblkid.ProbeInfo, error) {
+	info := &goblkid.ProbeInfo{
+		DeviceReader: dev.DeviceReader,
+		Offset:       offset,
+		Size:         size,
+		Devno:        dev.Devno,
+		DiskDevno:    dev.DiskDevno,
+		BlockSize:    dev.BlockSize,
+		Topology:     dev.Topology,
+	}
+
+	for _, chain := range chains {
+		ok, err := chain.Probe(info)
+		if errors.Is(err, goblkid.ErrCorrupt) {
+			/* a superblock failing its checksum is not a filesystem */
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			return info, nil
+		}
+	}
+
+	return nil, nil
+}
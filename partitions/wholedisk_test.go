@@ -0,0 +1,115 @@
+package partitions
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/isi-lincoln/goblkid"
+	"github.com/isi-lincoln/goblkid/fat"
+	"github.com/stretchr/testify/assert"
+)
+
+/* a FAT12 boot sector of sectors 512 byte sectors at the start of img */
+func putFATBootSector(img []byte, sectors uint16) {
+	copy(img[0:], []byte{0xeb, 0x3c, 0x90})
+	copy(img[3:], "mkfs.fat")
+	binary.LittleEndian.PutUint16(img[0x0b:], 512)
+	img[0x0d] = 4
+	binary.LittleEndian.PutUint16(img[0x0e:], 1)
+	img[0x10] = 2
+	binary.LittleEndian.PutUint16(img[0x11:], 512)
+	binary.LittleEndian.PutUint16(img[0x13:], sectors)
+	img[0x15] = 0xf8
+	binary.LittleEndian.PutUint16(img[0x16:], 2)
+	copy(img[0x36:], "FAT12   ")
+	img[MBRSignatureOffset] = 0x55
+	img[MBRSignatureOffset+1] = 0xAA
+}
+
+func resolve(t *testing.T, img []byte) *Resolution {
+	t.Helper()
+
+	r, err := Resolve(&goblkid.ProbeInfo{DeviceReader: bytes.NewReader(img)},
+		[]goblkid.Chain{fat.Chain})
+	assert.Nil(t, err)
+
+	return r
+}
+
+func TestSuperfloppy(t *testing.T) {
+	img := newImage()
+	putFATBootSector(img, testSectors)
+
+	r := resolve(t, img)
+	assert.Equal(t, InterpretFilesystem, r.Interpretation)
+	assert.Nil(t, r.Table)
+	assert.Contains(t, r.Reason, "superfloppy")
+}
+
+func TestFATLikeMBR(t *testing.T) {
+	img := newImage()
+	putFATBootSector(img, testSectors)
+	putMBREntry(img, 0, 0x0c, 64, 1024)
+
+	r := resolve(t, img)
+	assert.Equal(t, InterpretTable, r.Interpretation)
+	assert.Nil(t, r.Filesystem)
+	assert.Equal(t, DosName, r.Table.Type)
+	assert.Contains(t, r.Reason, "FAT boot sector")
+}
+
+func TestNothing(t *testing.T) {
+	r := resolve(t, newImage())
+	assert.Equal(t, InterpretNothing, r.Interpretation)
+}
+
+/* an exFAT boot sector whose boot region checksum was never written */
+func putExFATBootSector(img []byte) {
+	copy(img[0:], []byte{0xeb, 0x76, 0x90})
+	copy(img[3:], "EXFAT   ")
+	binary.LittleEndian.PutUint64(img[0x48:], 937)
+	binary.LittleEndian.PutUint32(img[0x50:], 128)
+	binary.LittleEndian.PutUint32(img[0x54:], 8)
+	binary.LittleEndian.PutUint32(img[0x58:], 256)
+	binary.LittleEndian.PutUint32(img[0x5c:], 80)
+	binary.LittleEndian.PutUint32(img[0x60:], 4)
+	img[0x6c], img[0x6d], img[0x6e] = 9, 3, 1
+	img[MBRSignatureOffset] = 0x55
+	img[MBRSignatureOffset+1] = 0xAA
+}
+
+/*
+ * A whole-disk FAT written over a GPT disk keeps the GPT headers, which
+ * mkfs.fat does not touch, so both sides of resolveConflict are present.
+ */
+func TestResolveConflict(t *testing.T) {
+	tests := []struct {
+		name      string
+		partition func(p []byte)
+		want      Interpretation
+		reason    string
+	}{
+		{"stale table", func(p []byte) {}, InterpretFilesystem,
+			"whole-disk vfat filesystem found and none of the 1 gpt partitions holds a known filesystem; " +
+				"the partition table is stale"},
+		{"corrupt partition", putExFATBootSector, InterpretFilesystem,
+			"the partition table is stale"},
+		{"live table", func(p []byte) { putFATBootSector(p, 937) }, InterpretTable,
+			"partition 1 holds a vfat filesystem; the whole-disk vfat signature is stale"},
+	}
+
+	for _, tt := range tests {
+		img := newImage()
+		putGPT(img, 64, 1000)
+		putFATBootSector(img, testSectors)
+		tt.partition(img[64*testSS:])
+
+		r := resolve(t, img)
+		assert.Equal(t, tt.want, r.Interpretation, tt.name)
+		assert.Contains(t, r.Reason, tt.reason, tt.name)
+		assert.NotNil(t, r.Table, tt.name)
+		assert.Equal(t, LayoutLostProtective, r.Table.Layout, tt.name)
+		assert.NotNil(t, r.Filesystem, tt.name)
+	}
+}
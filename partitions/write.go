@@ -0,0 +1,516 @@
+package partitions
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/isi-lincoln/goblkid"
+)
+
+// DefaultAlignment is the partition start alignment used by fdisk and parted
+const DefaultAlignment = 1 << 20
+
+// typeAliases maps short type names to a gpt type guid and a dos type byte
+var typeAliases = map[string][2]string{ // nolint:gochecknoglobals
+	"linux":  {"0fc63daf-8483-4772-8e79-3d69d8477de4", "0x83"},
+	"swap":   {"0657fd6d-a4ab-43c4-84e5-0933c84b4f4f", "0x82"},
+	"efi":    {"c12a7328-f81f-11d2-ba4b-00a0c93ec93b", "0xef"},
+	"lvm":    {"e6d6d379-f507-44c2-a23c-238f2a3df928", "0x8e"},
+	"raid":   {"a19d880f-05fc-4d3b-a006-743f0f84911e", "0xfd"},
+	"msdata": {"ebd0a0a2-b9e5-4433-87c0-68b6b72699c7", "0x07"},
+	"bios":   {"21686148-6449-6e6f-744e-656564454649", ""},
+}
+
+// Device is what a partition table is written to
+type Device interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// PartitionSpec declares a single partition. A partition without Size and
+// Percent takes the rest of the disk, which only the last one may do.
+type PartitionSpec struct {
+	Size     uint64 /* in bytes */
+	Percent  uint64 /* of the usable space */
+	Type     string /* alias, gpt type guid or dos type byte */
+	Name     string /* gpt only */
+	UUID     string /* gpt only, random when empty */
+	Bootable bool   /* dos only */
+}
+
+// Spec declares a partition table to lay out on a device
+type Spec struct {
+	Type       string /* DosName or GPTName */
+	SectorSize uint64
+	Alignment  uint64 /* in bytes */
+	ID         string /* disk guid or dos disk id, random when empty */
+	Partitions []PartitionSpec
+}
+
+// ParsePartitionSpec parses the comma separated key=value form used on the
+// command line, e.g. "size=512M,type=efi,name=ESP" or "size=50%,boot"
+func ParsePartitionSpec(s string) (PartitionSpec, error) {
+	p := PartitionSpec{}
+
+	for _, field := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2) // nolint:gomnd
+		key := kv[0]
+		val := ""
+
+		if len(kv) == 2 { // nolint:gomnd
+			val = kv[1]
+		}
+
+		switch key {
+		case "":
+		case "size":
+			if strings.HasSuffix(val, "%") {
+				pct, err := strconv.ParseUint(strings.TrimSuffix(val, "%"), 10, 64)
+				if err != nil || pct == 0 || pct > 100 {
+					return p, fmt.Errorf("invalid percentage: %q", val)
+				}
+
+				p.Percent = pct
+
+				continue
+			}
+
+			size, err := ParseSize(val)
+			if err != nil {
+				return p, err
+			}
+
+			p.Size = size
+		case "type":
+			p.Type = val
+		case "name":
+			p.Name = val
+		case "uuid":
+			p.UUID = val
+		case "boot", "bootable":
+			p.Bootable = true
+		default:
+			return p, fmt.Errorf("unknown partition field: %q", key)
+		}
+	}
+
+	return p, nil
+}
+
+// ParseSize parses a byte count with an optional K, M, G or T binary suffix
+func ParseSize(s string) (uint64, error) {
+	mult := uint64(1)
+	num := strings.TrimSuffix(strings.ToUpper(s), "B")
+	num = strings.TrimSuffix(num, "I")
+
+	if num != "" {
+		switch num[len(num)-1] {
+		case 'K':
+			mult = 1 << 10
+		case 'M':
+			mult = 1 << 20
+		case 'G':
+			mult = 1 << 30
+		case 'T':
+			mult = 1 << 40
+		}
+	}
+
+	if mult != 1 {
+		num = num[:len(num)-1]
+	}
+
+	n, err := strconv.ParseUint(num, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+
+	if n > math.MaxUint64/mult {
+		return 0, fmt.Errorf("size %q overflows 64 bits", s)
+	}
+
+	return n * mult, nil
+}
+
+// Write lays out a fresh partition table on a device of size bytes and
+// returns the partitions as they were placed
+func Write(dev Device, size int64, spec *Spec) ([]Partition, error) {
+	ss := sectorSizeOrDefault(spec.SectorSize)
+	if size <= 0 || uint64(size)%ss != 0 {
+		return nil, fmt.Errorf("device size %d is not a multiple of the %d byte sector", size, ss)
+	}
+
+	switch spec.Type {
+	case DosName:
+		return writeMBR(dev, size, ss, spec)
+	case GPTName, "":
+		return writeGPT(dev, size, ss, spec)
+	default:
+		return nil, fmt.Errorf("unknown partition table type: %s", spec.Type)
+	}
+}
+
+/* place computes start and length of every partition within [first, last] */
+func place(spec *Spec, ss, first, last uint64) ([]Partition, error) {
+	align := spec.Alignment
+	if align == 0 {
+		align = DefaultAlignment
+	}
+
+	if align%ss != 0 {
+		return nil, fmt.Errorf("alignment %d is not a multiple of the %d byte sector", align, ss)
+	}
+
+	align /= ss
+	usable := last - first + 1
+	next := first
+	parts := make([]Partition, 0, len(spec.Partitions))
+
+	for i, ps := range spec.Partitions {
+		start := (next + align - 1) / align * align
+
+		var sectors uint64
+
+		switch {
+		case ps.Size != 0:
+			sectors = (ps.Size + ss - 1) / ss
+		case ps.Percent != 0:
+			sectors = usable * ps.Percent / 100 / align * align
+		case i == len(spec.Partitions)-1:
+			if start <= last {
+				sectors = last - start + 1
+			}
+		default:
+			return nil, fmt.Errorf("partition %d: only the last partition may omit its size", i+1)
+		}
+
+		if sectors == 0 || start+sectors-1 > last {
+			return nil, fmt.Errorf("partition %d does not fit: start %d, %d sectors, last usable %d",
+				i+1, start, sectors, last)
+		}
+
+		parts = append(parts, Partition{Number: i + 1, Start: start, Sectors: sectors})
+		next = start + sectors
+	}
+
+	return parts, nil
+}
+
+func writeMBR(dev Device, size int64, ss uint64, spec *Spec) ([]Partition, error) {
+	if len(spec.Partitions) > MBRPartitionCount {
+		return nil, fmt.Errorf("dos tables hold at most %d primary partitions", MBRPartitionCount)
+	}
+
+	last := uint64(size)/ss - 1
+	if last > 0xffffffff {
+		last = 0xffffffff
+	}
+
+	parts, err := place(spec, ss, 1, last)
+	if err != nil {
+		return nil, err
+	}
+
+	id := uint32(0)
+
+	if spec.ID != "" {
+		v, err := strconv.ParseUint(strings.TrimPrefix(spec.ID, "0x"), 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dos disk id: %q", spec.ID)
+		}
+
+		id = uint32(v)
+	} else {
+		g, err := NewGUID()
+		if err != nil {
+			return nil, err
+		}
+
+		id = binary.LittleEndian.Uint32(g.Raw[:4])
+	}
+
+	buf := make([]byte, MBRSize)
+	binary.LittleEndian.PutUint32(buf[MBRIDOffset:], id)
+
+	for i := range parts {
+		typ, err := dosType(spec.Partitions[i].Type)
+		if err != nil {
+			return nil, fmt.Errorf("partition %d: %v", i+1, err)
+		}
+
+		/* LBA only, the CHS fields point past cylinder 1023 */
+		p := MBRPartition{
+			FirstCHS: [3]uint8{0xfe, 0xff, 0xff},
+			Type:     typ,
+			LastCHS:  [3]uint8{0xfe, 0xff, 0xff},
+			StartLBA: uint32(parts[i].Start),
+			Sectors:  uint32(parts[i].Sectors),
+		}
+		if spec.Partitions[i].Bootable {
+			p.Status = MBRBootable
+		}
+
+		putMBRPartition(buf, i, &p)
+		parts[i].Bootable = spec.Partitions[i].Bootable
+		parts[i].Type = fmt.Sprintf("0x%x", typ)
+		parts[i].UUID = fmt.Sprintf("%08x-%02d", id, i+1)
+	}
+
+	buf[MBRSignatureOffset] = 0x55
+	buf[MBRSignatureOffset+1] = 0xAA
+
+	if err := clearGPT(dev, size, ss); err != nil {
+		return nil, err
+	}
+
+	if _, err := dev.WriteAt(buf, 0); err != nil {
+		return nil, err
+	}
+
+	return parts, nil
+}
+
+func writeGPT(dev Device, size int64, ss uint64, spec *Spec) ([]Partition, error) {
+	if len(spec.Partitions) > GPTDefaultEntries {
+		return nil, fmt.Errorf("gpt tables hold at most %d partitions", GPTDefaultEntries)
+	}
+
+	entrySectors := (GPTDefaultEntries*GPTEntryMinSize + ss - 1) / ss
+	lastLBA := uint64(size)/ss - 1
+	first := 2 + entrySectors
+	last := lastLBA - 1 - entrySectors
+
+	if lastLBA < 2*entrySectors+3 { // nolint:gomnd
+		return nil, fmt.Errorf("device of %d bytes is too small for a gpt", size)
+	}
+
+	parts, err := place(spec, ss, first, last)
+	if err != nil {
+		return nil, err
+	}
+
+	diskGUID, err := guidOrRandom(spec.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]byte, GPTDefaultEntries*GPTEntryMinSize)
+
+	for i := range parts {
+		ps := spec.Partitions[i]
+
+		typ, err := gptType(ps.Type)
+		if err != nil {
+			return nil, fmt.Errorf("partition %d: %v", i+1, err)
+		}
+
+		uuid, err := guidOrRandom(ps.UUID)
+		if err != nil {
+			return nil, fmt.Errorf("partition %d: %v", i+1, err)
+		}
+
+		name := utf16.Encode([]rune(ps.Name))
+		if len(name) > GPTNameLength {
+			return nil, fmt.Errorf("partition %d: name longer than %d characters", i+1, GPTNameLength)
+		}
+
+		e := entries[i*GPTEntryMinSize : (i+1)*GPTEntryMinSize]
+		copy(e[0:16], typ.Bytes())
+		copy(e[16:32], uuid.Bytes())
+		binary.LittleEndian.PutUint64(e[32:40], parts[i].Start)
+		binary.LittleEndian.PutUint64(e[40:48], parts[i].Start+parts[i].Sectors-1)
+
+		for j, c := range name {
+			binary.LittleEndian.PutUint16(e[56+2*j:], c)
+		}
+
+		parts[i].Name = ps.Name
+		parts[i].Type = typ.String()
+		parts[i].UUID = uuid.String()
+	}
+
+	h := GPTHeader{
+		Revision:       GPTRevision,
+		HeaderSize:     GPTHeaderMinSize,
+		FirstUsableLBA: first,
+		LastUsableLBA:  last,
+		DiskGUID:       diskGUID,
+		NumEntries:     GPTDefaultEntries,
+		EntrySize:      GPTEntryMinSize,
+		EntriesCRC32:   crc32.ChecksumIEEE(entries),
+	}
+	copy(h.Signature[:], GPTSignature)
+
+	primary, backup := h, h
+	primary.MyLBA, primary.AlternateLBA, primary.EntriesLBA = 1, lastLBA, 2
+	backup.MyLBA, backup.AlternateLBA, backup.EntriesLBA = lastLBA, 1, lastLBA-entrySectors
+
+	/* backup first, so a crash leaves no primary pointing at nothing */
+	writes := []struct {
+		lba uint64
+		buf []byte
+	}{
+		{backup.EntriesLBA, entries},
+		{backup.MyLBA, packGPTHeader(&backup, ss)},
+		{primary.EntriesLBA, entries},
+		{primary.MyLBA, packGPTHeader(&primary, ss)},
+		{0, protectiveMBR(lastLBA)},
+	}
+
+	for _, w := range writes {
+		if _, err := dev.WriteAt(w.buf, int64(w.lba*ss)); err != nil {
+			return nil, err
+		}
+	}
+
+	return parts, nil
+}
+
+func packGPTHeader(h *GPTHeader, ss uint64) []byte {
+	buf := make([]byte, ss)
+	copy(buf[0:8], h.Signature[:])
+	binary.LittleEndian.PutUint32(buf[8:12], h.Revision)
+	binary.LittleEndian.PutUint32(buf[12:16], h.HeaderSize)
+	binary.LittleEndian.PutUint64(buf[24:32], h.MyLBA)
+	binary.LittleEndian.PutUint64(buf[32:40], h.AlternateLBA)
+	binary.LittleEndian.PutUint64(buf[40:48], h.FirstUsableLBA)
+	binary.LittleEndian.PutUint64(buf[48:56], h.LastUsableLBA)
+	copy(buf[56:72], h.DiskGUID.Bytes())
+	binary.LittleEndian.PutUint64(buf[72:80], h.EntriesLBA)
+	binary.LittleEndian.PutUint32(buf[80:84], h.NumEntries)
+	binary.LittleEndian.PutUint32(buf[84:88], h.EntrySize)
+	binary.LittleEndian.PutUint32(buf[88:92], h.EntriesCRC32)
+
+	h.HeaderCRC32 = crc32.ChecksumIEEE(buf[:h.HeaderSize])
+	binary.LittleEndian.PutUint32(buf[16:20], h.HeaderCRC32)
+
+	return buf
+}
+
+func protectiveMBR(lastLBA uint64) []byte {
+	sectors := lastLBA
+	if sectors > 0xffffffff {
+		sectors = 0xffffffff
+	}
+
+	buf := make([]byte, MBRSize)
+	putMBRPartition(buf, 0, &MBRPartition{
+		FirstCHS: [3]uint8{0x00, 0x02, 0x00},
+		Type:     MBRTypeGPT,
+		LastCHS:  [3]uint8{0xff, 0xff, 0xff},
+		StartLBA: 1,
+		Sectors:  uint32(sectors),
+	})
+	buf[MBRSignatureOffset] = 0x55
+	buf[MBRSignatureOffset+1] = 0xAA
+
+	return buf
+}
+
+func putMBRPartition(buf []byte, slot int, p *MBRPartition) {
+	e := buf[MBRPartitionOffset+slot*MBREntrySize : MBRPartitionOffset+(slot+1)*MBREntrySize]
+	e[0] = p.Status
+	copy(e[1:4], p.FirstCHS[:])
+	e[4] = p.Type
+	copy(e[5:8], p.LastCHS[:])
+	binary.LittleEndian.PutUint32(e[8:12], p.StartLBA)
+	binary.LittleEndian.PutUint32(e[12:16], p.Sectors)
+}
+
+/* a dos table must not leave an old gpt behind for Probe to find */
+func clearGPT(dev Device, size int64, ss uint64) error {
+	for _, lba := range []uint64{1, uint64(size)/ss - 1} {
+		buf := make([]byte, ss)
+		if _, err := dev.ReadAt(buf, int64(lba*ss)); err != nil {
+			return err
+		}
+
+		if string(buf[0:8]) != GPTSignature {
+			continue
+		}
+
+		if _, err := dev.WriteAt(make([]byte, ss), int64(lba*ss)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func guidOrRandom(s string) (goblkid.UUID, error) {
+	if s == "" {
+		return NewGUID()
+	}
+
+	return goblkid.ParseUUIDFormat(s, goblkid.GUID)
+}
+
+func gptType(s string) (goblkid.UUID, error) {
+	if s == "" {
+		s = "linux"
+	}
+
+	if alias, ok := typeAliases[strings.ToLower(s)]; ok {
+		s = alias[0]
+	}
+
+	return goblkid.ParseUUIDFormat(s, goblkid.GUID)
+}
+
+func dosType(s string) (uint8, error) {
+	if s == "" {
+		s = "linux"
+	}
+
+	if alias, ok := typeAliases[strings.ToLower(s)]; ok {
+		if alias[1] == "" {
+			return 0, fmt.Errorf("type %s has no dos equivalent", s)
+		}
+
+		s = alias[1]
+	}
+
+	b, err := hex.DecodeString(fmt.Sprintf("%02s", strings.TrimPrefix(strings.ToLower(s), "0x")))
+	if err != nil || len(b) != 1 || b[0] == MBRTypeEmpty {
+		return 0, fmt.Errorf("invalid dos partition type: %q", s)
+	}
+
+	return b[0], nil
+}
+
+// Verify probes the device after a Write and checks the table it finds
+// matches the partitions that were placed
+func Verify(info *goblkid.ProbeInfo, spec *Spec, placed []Partition) (*Table, error) {
+	t, err := Probe(info)
+	if err != nil {
+		return nil, err
+	}
+
+	want := spec.Type
+	if want == "" {
+		want = GPTName
+	}
+
+	if t.Type != want {
+		return t, fmt.Errorf("wrote a %s table but read back %s", want, t.Type)
+	}
+
+	if len(t.Partitions) != len(placed) {
+		return t, fmt.Errorf("wrote %d partitions but read back %d", len(placed), len(t.Partitions))
+	}
+
+	for i, p := range placed {
+		if t.Partitions[i] != p {
+			return t, fmt.Errorf("partition %d reads back as %+v, wrote %+v", i+1, t.Partitions[i], p)
+		}
+	}
+
+	return t, nil
+}
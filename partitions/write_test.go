@@ -0,0 +1,115 @@
+package partitions
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/isi-lincoln/goblkid"
+	"github.com/stretchr/testify/assert"
+)
+
+type memDevice []byte
+
+func (m memDevice) ReadAt(p []byte, off int64) (int, error) {
+	return copy(p, m[off:]), nil
+}
+
+func (m memDevice) WriteAt(p []byte, off int64) (int, error) {
+	return copy(m[off:], p), nil
+}
+
+func roundTrip(t *testing.T, dev memDevice, spec *Spec) *Table {
+	t.Helper()
+
+	placed, err := Write(dev, int64(len(dev)), spec)
+	assert.Nil(t, err)
+
+	table, err := Verify(&goblkid.ProbeInfo{DeviceReader: bytes.NewReader(dev)}, spec, placed)
+	assert.Nil(t, err)
+
+	return table
+}
+
+func TestWriteGPT(t *testing.T) {
+	dev := make(memDevice, 64<<20)
+	spec := &Spec{
+		Type: GPTName,
+		ID:   "0fc63daf-8483-4772-8e79-3d69d8477de4",
+		Partitions: []PartitionSpec{
+			{Size: 8 << 20, Type: "efi", Name: "ESP"},
+			{Percent: 50, Name: "root"},
+			{Type: "8da63339-0007-60c0-c436-083ac8230908"},
+		},
+	}
+
+	table := roundTrip(t, dev, spec)
+	assert.Equal(t, LayoutProtective, table.Layout)
+	assert.Equal(t, spec.ID, table.ID)
+	assert.Equal(t, uint64(2048), table.Partitions[0].Start)
+	assert.Equal(t, "ESP", table.Partitions[0].Name)
+	assert.Equal(t, table.GPT.Header.LastUsableLBA,
+		table.Partitions[2].Start+table.Partitions[2].Sectors-1)
+
+	/* the backup alone must be enough to recover the table */
+	copy(dev[512:1024], make([]byte, 512))
+	table, err := Probe(&goblkid.ProbeInfo{DeviceReader: bytes.NewReader(dev)})
+	assert.Nil(t, err)
+	assert.True(t, table.GPT.Backup)
+	assert.Equal(t, 3, len(table.Partitions))
+}
+
+func TestWriteMBROverGPT(t *testing.T) {
+	dev := make(memDevice, 64<<20)
+	roundTrip(t, dev, &Spec{Partitions: []PartitionSpec{{}}})
+
+	table := roundTrip(t, dev, &Spec{
+		Type:       DosName,
+		ID:         "0xdeadbeef",
+		Partitions: []PartitionSpec{{Size: 1 << 20, Type: "efi", Bootable: true}, {Type: "0x7"}},
+	})
+	assert.Equal(t, LayoutMBR, table.Layout)
+	assert.Equal(t, 0, len(table.Diagnostics))
+	assert.Equal(t, "deadbeef-02", table.Partitions[1].UUID)
+}
+
+func TestWriteErrors(t *testing.T) {
+	dev := make(memDevice, 4<<20)
+
+	_, err := Write(dev, int64(len(dev)), &Spec{Partitions: []PartitionSpec{{}, {}}})
+	assert.NotNil(t, err)
+
+	_, err = Write(dev, int64(len(dev)), &Spec{Partitions: []PartitionSpec{{Size: 8 << 20}}})
+	assert.NotNil(t, err)
+
+	_, err = Write(dev, int64(len(dev)), &Spec{Type: DosName, Partitions: []PartitionSpec{{Type: "bios"}}})
+	assert.NotNil(t, err)
+}
+
+func TestParsePartitionSpec(t *testing.T) {
+	p, err := ParsePartitionSpec("size=512M,type=efi,name=ESP,boot")
+	assert.Nil(t, err)
+	assert.Equal(t, PartitionSpec{Size: 512 << 20, Type: "efi", Name: "ESP", Bootable: true}, p)
+
+	p, err = ParsePartitionSpec("size=25%")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(25), p.Percent)
+
+	_, err = ParsePartitionSpec("size=lots")
+	assert.NotNil(t, err)
+}
+
+func TestParseSize(t *testing.T) {
+	n, err := ParseSize("16777215T")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(16777215)<<40, n)
+
+	n, err = ParseSize("18446744073709551615")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(math.MaxUint64), n)
+
+	for _, s := range []string{"16777216T", "17179869184G", "18446744073709551615K"} {
+		_, err = ParseSize(s)
+		assert.NotNil(t, err, s)
+	}
+}
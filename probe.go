@@ -1,9 +1,14 @@
 package goblkid
 
 import (
+	"errors"
 	"io"
 )
 
+// ErrCorrupt is returned by a prober whose magic matched but whose
+// superblock failed its checksum
+var ErrCorrupt = errors.New("corrupt superblock")
+
 // ProbeUsage is the type of probe being used
 type ProbeUsage int
 
@@ -24,7 +29,16 @@ type Prober struct {
 
 func (pr *Prober) Probe(info *ProbeInfo) (bool, error) {
 	for _, magic := range pr.MagicInfos {
-		ok, err := pr.ProbeFunc(info, magic)
+		ok, err := magic.Match(info)
+		if err != nil {
+			return false, err
+		}
+
+		if !ok {
+			continue
+		}
+
+		ok, err = pr.ProbeFunc(info, magic)
 		if err != nil {
 			return false, err
 		}
@@ -43,6 +57,30 @@ type MagicInfo struct {
 	MagicByteOffset    uint64
 }
 
+// Match reports whether the magic string is present on the device
+func (magic *MagicInfo) Match(info *ProbeInfo) (bool, error) {
+	offset := info.Offset + int64(magic.SuperblockKbOffset<<10+magic.MagicByteOffset) // nolint:gomnd
+
+	_, err := info.DeviceReader.Seek(offset, io.SeekStart)
+	if err != nil {
+		return false, err
+	}
+
+	buf := make([]byte, len(magic.Magic))
+
+	_, err = io.ReadFull(info.DeviceReader, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		/* device is too small to hold the magic */
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return string(buf) == magic.Magic, nil
+}
+
 type ProbeInfo struct {
 	DeviceReader io.ReadSeeker
 	Offset       int64
@@ -53,15 +91,38 @@ type ProbeInfo struct {
 	BlockSize uint64 /* from BLKSSZGET ioctl */
 	Mode      int    /* from stat.sb_mode */
 
+	Topology Topology
+
+	// FATCodePage names the code page FAT labels are decoded with, such as
+	// cp850. Empty means cp437, as the kernel and mtools default to.
+	FATCodePage string
+
 	ProbeName string
 
-	UUID  string
+	UUID  UUID
 	Label string
 
-	ExtJournal string
+	ExtJournal UUID
 	SecType    string
 
 	Version string
+
+	FSSize      uint64 /* filesystem size in bytes */
+	FSBlockSize uint64 /* filesystem block size in bytes */
+	FSLastBlock uint64 /* blocks in the filesystem, as blkid reports FSLASTBLOCK */
+	FreeBlocks  uint64 /* in FSBlockSize units, as of the last superblock update */
+	FreeInodes  uint64
+
+	Tags map[string]string /* prober specific results, named as blkid -o export would */
+}
+
+// SetTag records a prober specific result
+func (info *ProbeInfo) SetTag(name, value string) {
+	if info.Tags == nil {
+		info.Tags = map[string]string{}
+	}
+
+	info.Tags[name] = value
 }
 
 type Chain []Prober
@@ -81,3 +142,16 @@ func (chain Chain) Probe(info *ProbeInfo) (bool, error) {
 
 	return false, nil
 }
+
+// ProbeAll runs the chains over info in order and stops at the first one
+// that matches or fails
+func ProbeAll(info *ProbeInfo, chains []Chain) (bool, error) {
+	for _, chain := range chains {
+		ok, err := chain.Probe(info)
+		if ok || err != nil {
+			return ok, err
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,59 @@
+package goblkid
+
+import (
+	"os"
+)
+
+// DefaultSectorSize is assumed when the device does not report one
+const DefaultSectorSize = 512
+
+// Topology describes the I/O geometry of a device
+type Topology struct {
+	LogicalSectorSize  uint64 /* from BLKSSZGET ioctl */
+	PhysicalSectorSize uint64 /* from BLKPBSZGET ioctl */
+	MinimumIOSize      uint64 /* from BLKIOMIN ioctl */
+	OptimalIOSize      uint64 /* from BLKIOOPT ioctl */
+	AlignmentOffset    int64  /* from BLKALIGNOFF ioctl */
+}
+
+// SetDevice attaches an opened device to info and fills in its size,
+// device numbers, mode and topology
+func (info *ProbeInfo) SetDevice(fi *os.File) error {
+	info.DeviceReader = fi
+
+	st, err := fi.Stat()
+	if err != nil {
+		return err
+	}
+
+	info.Mode = int(st.Mode())
+	info.Size = st.Size()
+	info.Topology = defaultTopology()
+
+	if err := deviceTopology(fi, info); err != nil {
+		return err
+	}
+
+	info.BlockSize = info.Topology.LogicalSectorSize
+
+	return nil
+}
+
+// Major returns the major number of a linux dev_t
+func Major(dev uint64) uint64 {
+	return ((dev >> 8) & 0xfff) | ((dev >> 32) & ^uint64(0xfff)) // nolint:gomnd
+}
+
+// Minor returns the minor number of a linux dev_t
+func Minor(dev uint64) uint64 {
+	return (dev & 0xff) | ((dev >> 12) & ^uint64(0xff)) // nolint:gomnd
+}
+
+/* regular files behave like a disk with 512 byte sectors */
+func defaultTopology() Topology {
+	return Topology{
+		LogicalSectorSize:  DefaultSectorSize,
+		PhysicalSectorSize: DefaultSectorSize,
+		MinimumIOSize:      DefaultSectorSize,
+	}
+}
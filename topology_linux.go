@@ -0,0 +1,167 @@
+//go:build linux
+// +build linux
+
+package goblkid
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// block device ioctls, see linux/fs.h. BLKGETSIZE64 encodes the size of
+// its argument, so it comes from x/sys/unix for each architecture.
+const (
+	BLKSSZGET   = 0x1268 // nolint:golint,stylecheck
+	BLKIOMIN    = 0x1278 // nolint:golint,stylecheck
+	BLKIOOPT    = 0x1279 // nolint:golint,stylecheck
+	BLKALIGNOFF = 0x127a // nolint:golint,stylecheck
+	BLKPBSZGET  = 0x127b // nolint:golint,stylecheck
+
+	sysfsBlock = "/sys/dev/block"
+)
+
+func deviceTopology(fi *os.File, info *ProbeInfo) error {
+	var st syscall.Stat_t
+	if err := syscall.Fstat(int(fi.Fd()), &st); err != nil {
+		return err
+	}
+
+	info.Mode = int(st.Mode)
+
+	if st.Mode&syscall.S_IFMT != syscall.S_IFBLK {
+		return nil
+	}
+
+	info.Devno = int(st.Rdev)
+	info.DiskDevno = int(wholeDiskDevno(st.Rdev))
+
+	sysfs := fmt.Sprintf("%s/%d:%d", sysfsBlock, Major(st.Rdev), Minor(st.Rdev))
+	fd := fi.Fd()
+	t := &info.Topology
+
+	if size, err := ioctlUint64(fd, unix.BLKGETSIZE64); err == nil {
+		info.Size = int64(size)
+	} else if sectors, err := sysfsUint(sysfs, "size"); err == nil {
+		info.Size = int64(sectors * DefaultSectorSize)
+	}
+
+	t.LogicalSectorSize = topologyValue(fd, BLKSSZGET, sysfs, "queue/logical_block_size", t.LogicalSectorSize)
+	t.PhysicalSectorSize = topologyValue(fd, BLKPBSZGET, sysfs, "queue/physical_block_size", t.PhysicalSectorSize)
+	t.MinimumIOSize = topologyValue(fd, BLKIOMIN, sysfs, "queue/minimum_io_size", t.MinimumIOSize)
+	t.OptimalIOSize = topologyValue(fd, BLKIOOPT, sysfs, "queue/optimal_io_size", t.OptimalIOSize)
+	t.AlignmentOffset = alignmentOffset(fd, sysfs)
+
+	return nil
+}
+
+/* ask the kernel first and fall back to sysfs, keeping def when both fail */
+func topologyValue(fd uintptr, req uintptr, sysfs, attr string, def uint64) uint64 {
+	if v, err := ioctlUint32(fd, req); err == nil {
+		return uint64(v)
+	}
+
+	if v, err := sysfsUint(sysfs, attr); err == nil {
+		return v
+	}
+
+	return def
+}
+
+/* BLKALIGNOFF and alignment_offset are signed, -1 when the device cannot be aligned */
+func alignmentOffset(fd uintptr, sysfs string) int64 {
+	if v, err := ioctlInt32(fd, BLKALIGNOFF); err == nil {
+		return int64(v)
+	}
+
+	if v, err := sysfsInt(sysfs, "alignment_offset"); err == nil {
+		return v
+	}
+
+	return 0
+}
+
+/*
+ * a partition has a "partition" attribute and sits in the directory of its
+ * parent disk, whose "dev" attribute holds the whole disk major:minor
+ */
+func wholeDiskDevno(devno uint64) uint64 {
+	sysfs := fmt.Sprintf("%s/%d:%d", sysfsBlock, Major(devno), Minor(devno))
+
+	if _, err := os.Stat(filepath.Join(sysfs, "partition")); err != nil {
+		return devno
+	}
+
+	path, err := filepath.EvalSymlinks(sysfs)
+	if err != nil {
+		return devno
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(filepath.Dir(path), "dev"))
+	if err != nil {
+		return devno
+	}
+
+	var major, minor uint64
+	if _, err := fmt.Sscanf(strings.TrimSpace(string(b)), "%d:%d", &major, &minor); err != nil {
+		return devno
+	}
+
+	return mkdev(major, minor)
+}
+
+func mkdev(major, minor uint64) uint64 {
+	return (major&0xfff)<<8 | (major&^0xfff)<<32 | (minor & 0xff) | (minor&^0xff)<<12 // nolint:gomnd
+}
+
+func sysfsUint(dir, attr string) (uint64, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, attr))
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+}
+
+func sysfsInt(dir, attr string) (int64, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, attr))
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+}
+
+func ioctlInt32(fd, req uintptr) (int32, error) {
+	v, err := ioctlUint32(fd, req)
+	return int32(v), err
+}
+
+func ioctlUint32(fd, req uintptr) (uint32, error) {
+	var v uint32
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(unsafe.Pointer(&v)))
+	if errno != 0 {
+		return 0, errno
+	}
+
+	return v, nil
+}
+
+func ioctlUint64(fd, req uintptr) (uint64, error) {
+	var v uint64
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(unsafe.Pointer(&v)))
+	if errno != 0 {
+		return 0, errno
+	}
+
+	return v, nil
+}
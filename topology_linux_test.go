@@ -0,0 +1,77 @@
+//go:build linux
+// +build linux
+
+package goblkid
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/* fakeSysfs writes attributes into a directory standing in for /sys/dev/block/M:m */
+func fakeSysfs(t *testing.T, attrs map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	for name, value := range attrs {
+		path := filepath.Join(dir, name)
+		assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.Nil(t, ioutil.WriteFile(path, []byte(value+"\n"), 0644))
+	}
+
+	return dir
+}
+
+/* a regular file answers no block device ioctl, so sysfs is used */
+func regularFile(t *testing.T) *os.File {
+	t.Helper()
+
+	fi, err := os.Create(filepath.Join(t.TempDir(), "disk.img"))
+	assert.Nil(t, err)
+
+	t.Cleanup(func() { fi.Close() })
+
+	return fi
+}
+
+func TestTopologyValue(t *testing.T) {
+	fd := regularFile(t).Fd()
+	sysfs := fakeSysfs(t, map[string]string{
+		"queue/physical_block_size": "4096",
+		"queue/optimal_io_size":     "garbage",
+	})
+
+	assert.Equal(t, uint64(4096), topologyValue(fd, BLKPBSZGET, sysfs, "queue/physical_block_size", 512))
+	assert.Equal(t, uint64(512), topologyValue(fd, BLKSSZGET, sysfs, "queue/logical_block_size", 512))
+	assert.Equal(t, uint64(0), topologyValue(fd, BLKIOOPT, sysfs, "queue/optimal_io_size", 0))
+}
+
+func TestAlignmentOffset(t *testing.T) {
+	fd := regularFile(t).Fd()
+
+	for value, want := range map[string]int64{"-1": -1, "3584": 3584, "0": 0, "junk": 0} {
+		sysfs := fakeSysfs(t, map[string]string{"alignment_offset": value})
+		assert.Equal(t, want, alignmentOffset(fd, sysfs), value)
+	}
+
+	assert.Equal(t, int64(0), alignmentOffset(fd, t.TempDir()))
+}
+
+func TestSetDeviceRegularFile(t *testing.T) {
+	fi := regularFile(t)
+	_, err := fi.Write(make([]byte, 3<<20))
+	assert.Nil(t, err)
+
+	info := &ProbeInfo{}
+	assert.Nil(t, info.SetDevice(fi))
+	assert.Equal(t, int64(3<<20), info.Size)
+	assert.Equal(t, uint64(DefaultSectorSize), info.BlockSize)
+	assert.Equal(t, defaultTopology(), info.Topology)
+	assert.Equal(t, int64(0), info.Topology.AlignmentOffset)
+	assert.Equal(t, 0, info.Devno)
+}
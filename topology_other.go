@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package goblkid
+
+import (
+	"os"
+)
+
+/* without block device ioctls every device is treated like a regular file */
+func deviceTopology(fi *os.File, info *ProbeInfo) error {
+	return nil
+}
@@ -0,0 +1,188 @@
+package goblkid
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// UUIDFormat is the on-disk flavour of a filesystem identifier
+type UUIDFormat int
+
+// UUIDFormat mappings
+const (
+	// RFC4122 is 16 bytes printed as 8-4-4-4-12 lower case hex
+	RFC4122 UUIDFormat = iota
+	// GUID is 16 bytes with the first three groups stored little endian
+	GUID
+	// FATSerial is a 4 byte little endian volume serial printed XXXX-XXXX
+	FATSerial
+	// NTFSSerial is an 8 byte little endian volume serial printed as 16 hex digits
+	NTFSSerial
+)
+
+func (f UUIDFormat) size() int {
+	switch f {
+	case FATSerial:
+		return 4 // nolint:gomnd
+	case NTFSSerial:
+		return 8 // nolint:gomnd
+	default:
+		return 16 // nolint:gomnd
+	}
+}
+
+func (f UUIDFormat) String() string {
+	switch f {
+	case RFC4122:
+		return "rfc4122"
+	case GUID:
+		return "guid"
+	case FATSerial:
+		return "fat"
+	case NTFSSerial:
+		return "ntfs"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(f))
+	}
+}
+
+// UUID is a filesystem identifier as stored on disk together with the
+// format needed to print it the way blkid and /dev/disk/by-uuid do
+type UUID struct {
+	Format UUIDFormat
+	Raw    [16]byte
+}
+
+// NewUUID copies the on-disk bytes of an identifier of the given format
+func NewUUID(format UUIDFormat, b []byte) UUID {
+	u := UUID{Format: format}
+	copy(u.Raw[:format.size()], b)
+
+	return u
+}
+
+// Bytes returns the on-disk bytes of the identifier
+func (u UUID) Bytes() []byte {
+	return u.Raw[:u.Format.size()]
+}
+
+// IsZero reports whether the identifier is unset or all zero
+func (u UUID) IsZero() bool {
+	return u.Raw == [16]byte{}
+}
+
+// Equal reports whether both identifiers have the same format and bytes
+func (u UUID) Equal(o UUID) bool {
+	return u.Format == o.Format && u.Raw == o.Raw
+}
+
+// Compare orders identifiers by format, then by their on-disk bytes
+func (u UUID) Compare(o UUID) int {
+	if u.Format != o.Format {
+		if u.Format < o.Format {
+			return -1
+		}
+
+		return 1
+	}
+
+	return bytes.Compare(u.Raw[:], o.Raw[:])
+}
+
+// String returns the canonical text form, empty for a zero identifier
+func (u UUID) String() string {
+	if u.IsZero() {
+		return ""
+	}
+
+	b := u.Raw
+
+	switch u.Format {
+	case GUID:
+		return fmt.Sprintf("%08x-%04x-%04x-%x-%x",
+			binary.LittleEndian.Uint32(b[0:4]),
+			binary.LittleEndian.Uint16(b[4:6]),
+			binary.LittleEndian.Uint16(b[6:8]),
+			b[8:10], b[10:16])
+	case FATSerial:
+		return fmt.Sprintf("%02X%02X-%02X%02X", b[3], b[2], b[1], b[0])
+	case NTFSSerial:
+		return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(b[0:8]))
+	default:
+		return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+	}
+}
+
+// GoString keeps %#v dumps of a ProbeInfo readable
+func (u UUID) GoString() string {
+	return fmt.Sprintf("goblkid.UUID{%s %q}", u.Format, u.String())
+}
+
+// ParseUUID parses the canonical text form of any format, telling them
+// apart by shape. 36 character forms are taken as RFC4122.
+func ParseUUID(s string) (UUID, error) {
+	switch {
+	case len(s) == 36: // nolint:gomnd
+		return ParseUUIDFormat(s, RFC4122)
+	case len(s) == 9 && s[4] == '-': // nolint:gomnd
+		return ParseUUIDFormat(s, FATSerial)
+	case len(s) == 16: // nolint:gomnd
+		return ParseUUIDFormat(s, NTFSSerial)
+	default:
+		return UUID{}, fmt.Errorf("unrecognized uuid: %q", s)
+	}
+}
+
+// ParseUUIDFormat parses the canonical text form of the given format
+func ParseUUIDFormat(s string, format UUIDFormat) (UUID, error) {
+	u := UUID{Format: format}
+
+	var groups []int
+
+	switch format {
+	case RFC4122, GUID:
+		groups = []int{8, 4, 4, 4, 12}
+	case FATSerial:
+		groups = []int{4, 4}
+	case NTFSSerial:
+		groups = []int{16}
+	}
+
+	parts := strings.Split(s, "-")
+	if len(parts) != len(groups) {
+		return u, fmt.Errorf("invalid %s uuid: %q", format, s)
+	}
+
+	for i, p := range parts {
+		if len(p) != groups[i] {
+			return u, fmt.Errorf("invalid %s uuid: %q", format, s)
+		}
+	}
+
+	b, err := hex.DecodeString(strings.Join(parts, ""))
+	if err != nil {
+		return u, fmt.Errorf("invalid %s uuid: %q: %v", format, s, err)
+	}
+
+	switch format {
+	case GUID:
+		reverse(b[0:4])
+		reverse(b[4:6])
+		reverse(b[6:8])
+	case FATSerial, NTFSSerial:
+		reverse(b)
+	}
+
+	copy(u.Raw[:], b)
+
+	return u, nil
+}
+
+func reverse(b []byte) {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+}
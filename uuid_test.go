@@ -0,0 +1,47 @@
+package goblkid
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUUIDString(t *testing.T) {
+	raw := []byte{0x5e, 0xa7, 0xc8, 0xac, 0x97, 0xa9, 0x4b, 0xe4,
+		0x8f, 0xb9, 0x9d, 0xeb, 0xf8, 0x5b, 0xbb, 0x6a}
+
+	assert.Equal(t, "5ea7c8ac-97a9-4be4-8fb9-9debf85bbb6a", NewUUID(RFC4122, raw).String())
+	assert.Equal(t, "acc8a75e-a997-e44b-8fb9-9debf85bbb6a", NewUUID(GUID, raw).String())
+	assert.Equal(t, "ACC8-A75E", NewUUID(FATSerial, raw).String())
+	assert.Equal(t, "E44BA997ACC8A75E", NewUUID(NTFSSerial, raw).String())
+	assert.Equal(t, "", UUID{}.String())
+}
+
+func TestUUIDParse(t *testing.T) {
+	for _, s := range []string{
+		"5ea7c8ac-97a9-4be4-8fb9-9debf85bbb6a",
+		"ACC8-A75E",
+		"E44BA997ACC8A75E",
+	} {
+		u, err := ParseUUID(s)
+		assert.Nil(t, err)
+		assert.Equal(t, s, u.String())
+	}
+
+	g, err := ParseUUIDFormat("c12a7328-f81f-11d2-ba4b-00a0c93ec93b", GUID)
+	assert.Nil(t, err)
+	assert.Equal(t, byte(0x28), g.Bytes()[0])
+
+	_, err = ParseUUID("not-a-uuid")
+	assert.NotNil(t, err)
+}
+
+func TestUUIDCompare(t *testing.T) {
+	a, _ := ParseUUID("ACC8-A75E")
+	b, _ := ParseUUID("ACC8-A75F")
+
+	assert.True(t, a.Equal(a))
+	assert.False(t, a.Equal(b))
+	assert.Equal(t, -1, a.Compare(b))
+	assert.False(t, a.Equal(NewUUID(RFC4122, a.Bytes())))
+}
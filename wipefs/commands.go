@@ -3,78 +3,593 @@ package wipefs
 import (
 	"fmt" // return errors
 	"os"  // open device
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/isi-lincoln/goblkid"
+	"github.com/isi-lincoln/goblkid/check"
 	"github.com/isi-lincoln/goblkid/ext"
 	"github.com/isi-lincoln/goblkid/fat"
+	"github.com/isi-lincoln/goblkid/filesystems"
+	"github.com/isi-lincoln/goblkid/partitions"
 
 	log "github.com/sirupsen/logrus"
 )
 
-// GetProbeInfo probes the passed in block and returns the probe info
-func GetProbeInfo(blk string) (*goblkid.ProbeInfo, error) {
+// GetProbeInfo probes the passed in block and returns the probe info,
+// decoding FAT labels with the named code page, cp437 when empty. A
+// superblock that matched but failed its checksum is not a match: the
+// error then wraps goblkid.ErrCorrupt, as libblkid refuses those too.
+func GetProbeInfo(blk, codePage string) (*goblkid.ProbeInfo, error) {
+	if codePage != "" {
+		if _, err := fat.CodePageByName(codePage); err != nil {
+			return nil, err
+		}
+	}
+
 	fi, err := os.Open(blk)
 	if err != nil {
 		return nil, err
 	}
 	defer fi.Close()
 
-	info := &goblkid.ProbeInfo{DeviceReader: fi}
+	info := &goblkid.ProbeInfo{FATCodePage: codePage}
+	if err := info.SetDevice(fi); err != nil {
+		return nil, err
+	}
 
-	chain := &ext.Chain
-	t, err := chain.Probe(info)
+	if _, err := filesystems.Probe(info); err != nil {
+		return nil, fmt.Errorf("probing %s: %w", blk, err)
+	}
 
-	log.Infof("returned: %t %v", t, err)
+	return info, nil
+}
+
+// GetTopology returns the size and I/O topology of the passed in block
+// without probing it for filesystems
+func GetTopology(blk string) (*goblkid.ProbeInfo, error) {
+	fi, err := os.Open(blk)
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close()
+
+	info := &goblkid.ProbeInfo{}
+	if err := info.SetDevice(fi); err != nil {
+		return nil, err
+	}
 
 	return info, nil
 }
 
-// PrintProbeInfo prints the preliminary probe info
+// PrintProbeInfo prints the preliminary probe info followed by the
+// prober specific tags, one per line
 func PrintProbeInfo(info *goblkid.ProbeInfo) {
 	log.Infof("%#v", info)
+
+	if info.FSBlockSize != 0 {
+		free := fmt.Sprint(info.FreeBlocks)
+		if _, ok := info.Tags[fat.TagFreeUnknown]; ok {
+			free = "unknown"
+		}
+
+		log.Infof("fs size: %d block size: %d blocks: %d free blocks: %s free inodes: %d",
+			info.FSSize, info.FSBlockSize, info.FSLastBlock, free, info.FreeInodes)
+	}
+
+	names := make([]string, 0, len(info.Tags))
+	for name := range info.Tags {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := info.Tags[name]
+		if !utf8.ValidString(value) {
+			value = fmt.Sprintf("%q", value)
+		}
+
+		log.Infof("%s=%s", name, value)
+	}
 }
 
-// WipeFileSystemSignature removes the magic string on the filesystem
-func WipeFileSystemSignature(dev string) error {
-	info, err := GetProbeInfo(dev)
+// PrintTopology prints the size, device numbers and I/O geometry of the device
+func PrintTopology(info *goblkid.ProbeInfo) {
+	t := info.Topology
+	log.Infof("size: %d mode: %o devno: %d:%d disk devno: %d:%d",
+		info.Size, info.Mode,
+		goblkid.Major(uint64(info.Devno)), goblkid.Minor(uint64(info.Devno)),
+		goblkid.Major(uint64(info.DiskDevno)), goblkid.Minor(uint64(info.DiskDevno)))
+	log.Infof("logical sector: %d physical sector: %d minimum io: %d optimal io: %d alignment offset: %d",
+		t.LogicalSectorSize, t.PhysicalSectorSize, t.MinimumIOSize, t.OptimalIOSize, t.AlignmentOffset)
+}
+
+// GetExtDetails decodes the ext superblock of the passed in block
+func GetExtDetails(blk string) (*ext.Details, error) {
+	fi, err := os.Open(blk)
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close()
+
+	info := &goblkid.ProbeInfo{}
+	if err := info.SetDevice(fi); err != nil {
+		return nil, err
+	}
+
+	return ext.ReadDetails(info)
+}
+
+// PrintExtDetails prints the superblock in the layout of dumpe2fs -h
+func PrintExtDetails(d *ext.Details) {
+	when := func(t time.Time) string {
+		if t.IsZero() {
+			return "n/a"
+		}
+
+		return t.Format(time.ANSIC)
+	}
+
+	log.Infof("Filesystem volume name:   %s", orNone(d.Label))
+	log.Infof("Last mounted on:          %s", orNone(d.LastMountedOn))
+	log.Infof("Filesystem UUID:          %s", d.UUID)
+	log.Infof("Filesystem revision #:    %d.%d", d.RevLevel, d.MinorRevLevel)
+	log.Infof("Filesystem features:      %s", strings.Join(d.Features(), " "))
+	log.Infof("Filesystem state:         %s", d.State)
+	log.Infof("Errors behavior:          %s", d.Errors)
+	log.Infof("Filesystem OS type:       %s", d.CreatorOS)
+	log.Infof("Inode count:              %d", d.InodesCount)
+	log.Infof("Block count:              %d", d.BlocksCount)
+	log.Infof("Reserved block count:     %d", d.RBlocksCount)
+	log.Infof("Free blocks:              %d", d.FreeBlocksCount)
+	log.Infof("Free inodes:              %d", d.FreeInodesCount)
+	log.Infof("First block:              %d", d.FirstDataBlock)
+	log.Infof("Block size:               %d", d.BlockSize)
+	log.Infof("Cluster size:             %d", d.ClusterSize)
+	log.Infof("Blocks per group:         %d", d.BlocksPerGroup)
+	log.Infof("Inodes per group:         %d", d.InodesPerGroup)
+	log.Infof("Filesystem created:       %s", when(d.Created))
+	log.Infof("Last mount time:          %s", when(d.LastMount))
+	log.Infof("Last write time:          %s", when(d.LastWrite))
+	log.Infof("Mount count:              %d", d.MountCount)
+	log.Infof("Maximum mount count:      %d", d.MaxMountCount)
+	log.Infof("Last checked:             %s", when(d.LastCheck))
+	log.Infof("Check interval:           %s", d.CheckInterval)
+	log.Infof("First inode:              %d", d.FirstInode)
+	log.Infof("Inode size:               %d", d.InodeSize)
+
+	if !d.JournalUUID.IsZero() {
+		log.Infof("Journal UUID:             %s", d.JournalUUID)
+	}
+
+	if d.JournalInode != 0 {
+		log.Infof("Journal inode:            %d", d.JournalInode)
+	}
+
+	if d.JournalDevice != 0 {
+		log.Infof("Journal device:           0x%04x", d.JournalDevice)
+	}
+
+	if d.LastOrphan != 0 {
+		log.Infof("First orphan inode:       %d", d.LastOrphan)
+	}
+
+	if d.ErrorCount != 0 {
+		log.Infof("FS Error count:           %d", d.ErrorCount)
+	}
+}
+
+// GetExtBackups locates the backup superblocks of the passed in block.
+// With identify set and the primary gone, the filesystem is also probed
+// from the first usable backup.
+func GetExtBackups(blk string, identify bool) (*ext.BackupReport, *goblkid.ProbeInfo, error) {
+	fi, err := os.Open(blk)
 	if err != nil {
-		return err
-	}
-
-	switch info.ProbeName {
-	case ext.Ext2Name:
-		fallthrough
-	case ext.Ext3Name:
-		fallthrough
-	case ext.Ext4Name:
-		fallthrough
-	case ext.Ext4devName:
-		return wipeExt(dev)
-	case fat.FatName:
-		return fmt.Errorf("not implemented")
+		return nil, nil, err
+	}
+	defer fi.Close()
+
+	info := &goblkid.ProbeInfo{}
+	if err := info.SetDevice(fi); err != nil {
+		return nil, nil, err
+	}
+
+	r, err := ext.FindBackups(info)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !identify || r.Primary == ext.PrimaryOK {
+		return r, nil, nil
+	}
+
+	b, err := ext.ProbeBackup(info)
+	if err != nil || b == nil {
+		return r, nil, err
+	}
+
+	return r, info, nil
+}
+
+// PrintExtBackups prints the state of the primary superblock and its backups
+func PrintExtBackups(r *ext.BackupReport) {
+	log.Infof("primary superblock: %s", r.Primary)
+
+	if r.BlockSize == 0 {
+		log.Infof("no backup superblocks found")
+		return
+	}
+
+	log.Infof("uuid: %s block size: %d", r.UUID, r.BlockSize)
+
+	for _, b := range r.Backups {
+		state := "ok"
+
+		switch {
+		case !b.Present:
+			state = "missing: " + b.Problem
+		case !b.Consistent:
+			state = "inconsistent: " + b.Problem
+		}
+
+		log.Infof("group %d at block %d: %s", b.Group, uint64(b.Offset)/r.BlockSize, state)
+	}
+}
+
+// GetFATBackup compares the FAT32 backup boot sector of the passed in block
+// with the primary. With identify set and a primary the prober rejects, the
+// volume is identified from the backup.
+func GetFATBackup(blk string, identify bool) (*fat.BackupBoot, *goblkid.ProbeInfo, error) {
+	fi, err := os.Open(blk)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer fi.Close()
+
+	info := &goblkid.ProbeInfo{}
+	if err := info.SetDevice(fi); err != nil {
+		return nil, nil, err
+	}
+
+	b, err := fat.ReadBackupBoot(info)
+	if err != nil || !identify {
+		return b, nil, err
+	}
+
+	if ok, err := fat.Chain.Probe(info); ok || err != nil {
+		return b, nil, err
+	}
+
+	if _, err := fat.ProbeBackup(info); err != nil || info.ProbeName == "" {
+		return b, nil, err
+	}
+
+	return b, info, nil
+}
+
+// PrintFATBackup prints how the backup boot sector compares to the primary
+func PrintFATBackup(b *fat.BackupBoot) {
+	if b == nil {
+		log.Infof("no FAT32 backup boot sector found")
+		return
+	}
+
+	log.Infof("backup boot sector %d at 0x%x: %s", b.Sector, b.Offset, b)
+}
+
+// GetExtHealth evaluates the health of the ext filesystem probed into
+// info, returning nil for anything else
+func GetExtHealth(blk string, info *goblkid.ProbeInfo) (*ext.Health, error) {
+	if !isExt(info.ProbeName) {
+		return nil, nil
+	}
+
+	fi, err := os.Open(blk)
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close()
+
+	dev := &goblkid.ProbeInfo{}
+	if err := dev.SetDevice(fi); err != nil {
+		return nil, err
+	}
+
+	return ext.ReadHealth(dev, time.Now())
+}
+
+// PrintExtHealth prints the health of an ext filesystem and whether it
+// should be checked before mounting
+func PrintExtHealth(h *ext.Health) {
+	log.Infof("health: %s", h)
+	log.Infof("clean: %t errors: %t needs recovery: %t check overdue: %t orphans pending: %t",
+		h.Clean, h.Errors, h.NeedsRecovery, h.CheckOverdue, h.OrphansPending)
+	log.Infof("fsck needed: %t", h.NeedsFsck())
+}
+
+// GetFATHealth checks the free space bookkeeping of the FAT filesystem
+// probed into info, nil when it is not FAT
+func GetFATHealth(blk string, info *goblkid.ProbeInfo) (*fat.Health, error) {
+	if info.ProbeName != fat.FatName {
+		return nil, nil
+	}
+
+	fi, err := os.Open(blk)
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close()
+
+	dev := &goblkid.ProbeInfo{}
+	if err := dev.SetDevice(fi); err != nil {
+		return nil, err
+	}
+
+	return fat.ReadHealth(dev)
+}
+
+// PrintFATHealth prints the FAT health and the FSInfo sector
+func PrintFATHealth(h *fat.Health) {
+	log.Infof("health: %s", h)
+	log.Infof("free clusters: %d", h.FreeClusters)
+
+	if fi := h.FSInfo; fi != nil {
+		log.Infof("fsinfo sector: %d valid: %t free clusters: %s next free: %s",
+			fi.Sector, fi.Valid(), fsinfoValue(fi.FreeClusters), fsinfoValue(fi.NextFree))
+	}
+}
+
+func fsinfoValue(v uint32) string {
+	if v == fat.FSINFO_UNKNOWN {
+		return "unknown"
+	}
+
+	return fmt.Sprint(v)
+}
+
+// CheckFAT walks the FAT and the directory tree of the FAT volume on the
+// passed in block without writing to it
+func CheckFAT(blk string) (*fat.CheckReport, error) {
+	fi, err := os.Open(blk)
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close()
+
+	return fat.Check(fi)
+}
+
+// PrintFATCheck prints the problems and warnings a FAT check found
+func PrintFATCheck(blk string, r *fat.CheckReport) {
+	log.Infof("%s: %s, %d files in %d directories, %d of %d clusters used",
+		blk, r.Version, r.Files, r.Dirs, r.Used, r.Clusters)
+
+	for _, p := range r.Problems {
+		log.Errorf("%s: %s", blk, p)
+	}
+
+	for _, w := range r.Warnings {
+		log.Warnf("%s: %s", blk, w)
+	}
+
+	switch {
+	case !r.OK():
+		log.Errorf("%s: failed, %d problems", blk, len(r.Problems))
+	case len(r.Warnings) != 0:
+		log.Infof("%s: passed with %d warnings", blk, len(r.Warnings))
+	default:
+		log.Infof("%s: clean", blk)
+	}
+}
+
+// GetExtMMP reads the multi-mount protection block of the passed in block
+func GetExtMMP(blk string) (*ext.MMP, error) {
+	fi, err := os.Open(blk)
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close()
+
+	info := &goblkid.ProbeInfo{}
+	if err := info.SetDevice(fi); err != nil {
+		return nil, err
+	}
+
+	return ext.ReadMMP(info)
+}
+
+// PrintExtMMP prints the multi-mount protection block
+func PrintExtMMP(m *ext.MMP) {
+	if m == nil {
+		log.Infof("mmp: not enabled")
+		return
+	}
+
+	log.Infof("mmp: %s", m.State)
+	log.Infof("block: %d sequence: %08x updated: %s", m.Block, m.Sequence, m.Updated.Format(time.RFC3339))
+	log.Infof("node: %s device: %s", orNone(m.NodeName), orNone(m.DeviceName))
+	log.Infof("update interval: %s check interval: %s", m.UpdateInterval, m.CheckInterval)
+}
+
+// GetJournalLinks probes every passed in block and matches the external
+// journals among them with the filesystems using them
+func GetJournalLinks(blks []string) ([]ext.JournalLink, error) {
+	devices := map[string]*goblkid.ProbeInfo{}
+
+	for _, blk := range blks {
+		fi, err := os.Open(blk)
+		if err != nil {
+			return nil, err
+		}
+		defer fi.Close()
+
+		info := &goblkid.ProbeInfo{}
+		if err := info.SetDevice(fi); err != nil {
+			return nil, err
+		}
+
+		if _, err := ext.Chain.Probe(info); err != nil {
+			return nil, fmt.Errorf("%s: %v", blk, err)
+		}
+
+		devices[blk] = info
+	}
+
+	return ext.LinkJournals(devices)
+}
+
+// PrintJournalLinks prints every external journal and who uses it
+func PrintJournalLinks(links []ext.JournalLink) {
+	if len(links) == 0 {
+		log.Infof("no external journals found")
+	}
+
+	for _, l := range links {
+		j := l.Journal
+		log.Infof("%s: jbd2 v%d journal %s", l.Device, j.Version(), j.UUID)
+		log.Infof("block size: %d length: %d first: %d sequence: 0x%08x start: %d",
+			j.BlockSize, j.MaxLen, j.First, j.Sequence, j.Start)
+
+		for _, u := range j.Users {
+			log.Infof("user: %s", u)
+		}
+
+		for _, fs := range l.Filesystems {
+			log.Infof("used by: %s", fs)
+		}
+
+		for _, u := range l.Missing {
+			log.Warnf("user %s not among the devices", u)
+		}
+
+		log.Infof("in use: %t", l.InUse())
+	}
+}
+
+func isExt(name string) bool {
+	switch name {
+	case ext.Ext2Name, ext.Ext3Name, ext.Ext4Name, ext.Ext4devName:
+		return true
 	default:
-		return fmt.Errorf("unknown case: %s", info.ProbeName)
+		return false
+	}
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+
+	return s
+}
+
+// ResolveDisk probes the passed in block for an MBR or GPT and decides
+// whether those or a whole-disk filesystem describe the disk
+func ResolveDisk(blk string) (*partitions.Resolution, error) {
+	fi, err := os.Open(blk)
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close()
+
+	info := &goblkid.ProbeInfo{}
+	if err := info.SetDevice(fi); err != nil {
+		return nil, err
+	}
+
+	return partitions.Resolve(info, filesystems.Chains)
+}
+
+// PrintResolution prints the chosen interpretation of the disk and, when
+// one was found, its partition table
+func PrintResolution(r *partitions.Resolution) {
+	log.Infof("interpretation: %s", r.Interpretation)
+
+	if r.Interpretation == partitions.InterpretNothing ||
+		(r.Table == nil || r.Filesystem == nil) {
+		log.Info(r.Reason)
+	} else {
+		log.Warn(r.Reason)
+	}
+
+	if r.Table != nil {
+		PrintPartitionTable(r.Table)
 	}
 }
 
-func wipeExt(dev string) error {
-	fi, err := os.OpenFile(dev, os.O_RDWR, 0777)
+// PrintPartitionTable prints the partition table along with its diagnostics
+func PrintPartitionTable(t *partitions.Table) {
+	log.Infof("type: %s id: %s layout: %s sector size: %d",
+		t.Type, t.ID, t.Layout, t.SectorSize)
+
+	for _, d := range t.Diagnostics {
+		if t.Layout == partitions.LayoutMBR || t.Layout == partitions.LayoutProtective {
+			log.Info(d)
+		} else {
+			log.Warn(d)
+		}
+	}
+
+	for _, p := range t.Partitions {
+		log.Infof("%d: start=%d sectors=%d type=%s uuid=%s name=%q boot=%t",
+			p.Number, p.Start, p.Sectors, p.Type, p.UUID, p.Name, p.Bootable)
+	}
+}
+
+// CreatePartitionTable lays out a fresh partition table on the block and
+// reads it back to make sure it parses to what was written
+func CreatePartitionTable(blk string, spec *partitions.Spec) (*partitions.Table, error) {
+	fi, err := os.OpenFile(blk, os.O_RDWR, 0)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer fi.Close()
 
-	data := make([]byte, len(ext.ExtMagic[0].Magic))
-	offset := int64(ext.ExtMagic[0].SuperblockKbOffset<<10 + ext.ExtMagic[0].MagicByteOffset) // nolint
-	n, err := fi.WriteAt(data, offset)
+	info := &goblkid.ProbeInfo{}
+	if err := info.SetDevice(fi); err != nil {
+		return nil, err
+	}
+
+	if spec.SectorSize == 0 {
+		spec.SectorSize = info.BlockSize
+	}
 
+	placed, err := partitions.Write(fi, info.Size, spec)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	if err := fi.Sync(); err != nil {
+		return nil, err
 	}
 
-	if n != len(data) {
-		return fmt.Errorf("write != length: %d != %d", n, len(data))
+	return partitions.Verify(info, spec, placed)
+}
+
+// PrintCheckReport prints every finding of a device check
+func PrintCheckReport(r *check.Report) {
+	for _, f := range r.Findings {
+		msg := fmt.Sprintf("%s: %s", r.Device, f.Message)
+		if f.Partition != 0 {
+			msg = fmt.Sprintf("%s partition %d: %s", r.Device, f.Partition, f.Message)
+		}
+
+		switch f.Severity {
+		case check.Error:
+			log.Error(msg)
+		case check.Warning:
+			log.Warn(msg)
+		default:
+			log.Info(msg)
+		}
 	}
+}
 
-	return nil
+// WipeFileSystemSignature removes the magic string on the filesystem
+func WipeFileSystemSignature(dev string) error {
+	_, err := Wipe(dev, WipeOptions{})
+	return err
 }
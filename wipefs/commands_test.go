@@ -0,0 +1,33 @@
+package wipefs
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/isi-lincoln/goblkid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetProbeInfoCorrupt(t *testing.T) {
+	img := mkfs(t, "mkfs.ext4", "-O", "metadata_csum")
+
+	info, err := GetProbeInfo(img, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "ext4", info.ProbeName)
+
+	/* a label byte changed behind the checksum's back */
+	fi, err := os.OpenFile(img, os.O_RDWR, 0)
+	assert.Nil(t, err)
+	_, err = fi.WriteAt([]byte{'x'}, 1024+0x78)
+	assert.Nil(t, err)
+	assert.Nil(t, fi.Close())
+
+	_, err = GetProbeInfo(img, "")
+	assert.True(t, errors.Is(err, goblkid.ErrCorrupt), "%v", err)
+
+	/* nothing found is not an error */
+	info, err = GetProbeInfo(writeImage(t, make([]byte, 1<<20)), "")
+	assert.Nil(t, err)
+	assert.Equal(t, "", info.ProbeName)
+}
@@ -0,0 +1,78 @@
+package wipefs
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math/bits"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/* exfatImage is a blank 8M exFAT volume with 4K clusters and its backup boot region */
+func exfatImage() []byte {
+	b := make([]byte, 8<<20)
+
+	copy(b, []byte{0xeb, 0x76, 0x90})
+	copy(b[3:], "EXFAT   ")
+	binary.LittleEndian.PutUint64(b[0x48:], uint64(len(b)/512))
+	binary.LittleEndian.PutUint32(b[0x50:], 128)
+	binary.LittleEndian.PutUint32(b[0x54:], 64)
+	binary.LittleEndian.PutUint32(b[0x58:], 256)
+	binary.LittleEndian.PutUint32(b[0x5c:], 2016)
+	binary.LittleEndian.PutUint32(b[0x60:], 4)
+	copy(b[0x64:], []byte{0xcd, 0xab, 0x34, 0x12})
+	b[0x69] = 1
+	b[0x6c], b[0x6d], b[0x6e] = 9, 3, 1
+	b[0x1fe], b[0x1ff] = 0x55, 0xaa
+
+	csum := uint32(0)
+
+	for i, c := range b[:11*512] {
+		if i != 106 && i != 107 && i != 112 {
+			csum = bits.RotateLeft32(csum, -1) + uint32(c)
+		}
+	}
+
+	for off := 11 * 512; off < 12*512; off += 4 {
+		binary.LittleEndian.PutUint32(b[off:], csum)
+	}
+
+	copy(b[12*512:], b[:12*512])
+
+	table := b[128*512:]
+	for c := 0; c <= 4; c++ {
+		binary.LittleEndian.PutUint32(table[c*4:], 0xffffffff)
+	}
+
+	return b
+}
+
+func TestWipeExFATBackup(t *testing.T) {
+	img := writeImage(t, exfatImage())
+
+	/* a shallow wipe leaves the backup boot region alone */
+	_, err := Wipe(img, WipeOptions{})
+	assert.Nil(t, err)
+
+	data, err := ioutil.ReadFile(img)
+	assert.Nil(t, err)
+	assert.Equal(t, make([]byte, 8), data[3:11])
+	assert.Equal(t, "EXFAT   ", string(data[12*512+3:12*512+11]))
+
+	img = writeImage(t, exfatImage())
+
+	p, err := Wipe(img, WipeOptions{Deep: true})
+	assert.Nil(t, err)
+
+	offsets := []int64{}
+	for _, r := range p.Regions {
+		offsets = append(offsets, r.Offset)
+	}
+
+	assert.Equal(t, []int64{3, 12*512 + 3}, offsets)
+
+	data, err = ioutil.ReadFile(img)
+	assert.Nil(t, err)
+	assert.Equal(t, make([]byte, 8), data[12*512+3:12*512+11])
+}
@@ -0,0 +1,43 @@
+package wipefs
+
+import "encoding/binary"
+
+/* fat32Image is a blank 4M FAT32 volume with 1 sector clusters and a backup boot sector */
+func fat32Image() []byte {
+	b := make([]byte, 4<<20)
+
+	copy(b, []byte{0xeb, 0x58, 0x90})
+	copy(b[3:], "mkfs.fat")
+	binary.LittleEndian.PutUint16(b[0x0b:], 512)
+	b[0x0d] = 1
+	binary.LittleEndian.PutUint16(b[0x0e:], 32)
+	b[0x10] = 2
+	b[0x15] = 0xf8
+	binary.LittleEndian.PutUint32(b[0x20:], uint32(len(b)/512))
+	binary.LittleEndian.PutUint32(b[0x24:], 64)
+	binary.LittleEndian.PutUint32(b[0x2c:], 2)
+	binary.LittleEndian.PutUint16(b[0x30:], 1)
+	binary.LittleEndian.PutUint16(b[0x32:], 6)
+	b[0x42] = 0x29
+	copy(b[0x43:], []byte{0xcd, 0xab, 0x34, 0x12})
+	copy(b[0x47:], "NO NAME    FAT32   ")
+	b[0x1fe], b[0x1ff] = 0x55, 0xaa
+
+	fsinfo := b[512:]
+	copy(fsinfo, "RRaA")
+	copy(fsinfo[484:], "rrAa")
+	binary.LittleEndian.PutUint32(fsinfo[488:], 0xffffffff)
+	binary.LittleEndian.PutUint32(fsinfo[492:], 0xffffffff)
+	copy(fsinfo[508:], []byte{0, 0, 0x55, 0xaa})
+
+	copy(b[6*512:], b[:2*512])
+
+	for i := 0; i < 2; i++ {
+		table := b[(32+i*64)*512:]
+		binary.LittleEndian.PutUint32(table[0:], 0x0ffffff8)
+		binary.LittleEndian.PutUint32(table[4:], 0x0fffffff)
+		binary.LittleEndian.PutUint32(table[8:], 0x0fffffff)
+	}
+
+	return b
+}
@@ -0,0 +1,42 @@
+package wipefs
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os/exec"
+	"testing"
+
+	"github.com/isi-lincoln/goblkid/ext"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWipeJournal(t *testing.T) {
+	if _, err := exec.LookPath("mke2fs"); err != nil {
+		t.Skip("mke2fs not installed")
+	}
+
+	img := writeImage(t, make([]byte, 8<<20))
+	if out, err := exec.Command("mke2fs", "-q", "-F", "-b", "1024", "-O", "journal_dev", img).CombinedOutput(); err != nil {
+		t.Skipf("mke2fs: %v: %s", err, out)
+	}
+
+	/* a journal nothing uses can go */
+	_, err := PlanWipe(img, WipeOptions{NoAct: true})
+	assert.Nil(t, err)
+
+	/* list a filesystem as its user, as mke2fs -J device= does on a block device */
+	data, err := ioutil.ReadFile(img)
+	assert.Nil(t, err)
+
+	jsb := data[2*1024:]
+	binary.BigEndian.PutUint32(jsb[0x40:], 1)
+	copy(jsb[0x100:], []byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0, 1, 2, 3, 4, 5, 6, 7, 8})
+	assert.Nil(t, ioutil.WriteFile(img, data, 0600))
+
+	_, err = PlanWipe(img, WipeOptions{})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "is the journal of 12345678-9abc-def0-0102-030405060708")
+
+	p := roundTrip(t, img, WipeOptions{Force: true})
+	assert.Equal(t, ext.JbdName, p.Filesystem)
+}
@@ -0,0 +1,41 @@
+package wipefs
+
+import (
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/isi-lincoln/goblkid"
+	"github.com/isi-lincoln/goblkid/ext"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWipeMMP(t *testing.T) {
+	img := mkfs(t, "mkfs.ext4", "-O", "mmp,^metadata_csum")
+
+	fi, err := os.OpenFile(img, os.O_RDWR, 0)
+	assert.Nil(t, err)
+
+	defer fi.Close()
+
+	info := &goblkid.ProbeInfo{}
+	assert.Nil(t, info.SetDevice(fi))
+
+	mmp, err := ext.ReadMMP(info)
+	assert.Nil(t, err)
+	assert.False(t, mmp.Active())
+
+	/* another node mounted it: any sequence but the clean and fsck markers */
+	seq := make([]byte, 4)
+	binary.LittleEndian.PutUint32(seq, 42)
+	_, err = fi.WriteAt(seq, int64(mmp.Block)*1024+4)
+	assert.Nil(t, err)
+
+	_, err = PlanWipe(img, WipeOptions{})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "is in-use on")
+
+	p, err := PlanWipe(img, WipeOptions{Force: true})
+	assert.Nil(t, err)
+	assert.Len(t, p.Regions, 1)
+}
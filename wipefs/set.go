@@ -0,0 +1,151 @@
+package wipefs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/isi-lincoln/goblkid"
+	"github.com/isi-lincoln/goblkid/ext"
+	"github.com/isi-lincoln/goblkid/fat"
+	"github.com/isi-lincoln/goblkid/filesystems"
+)
+
+// SetLabel changes the label of the filesystem on the passed in block.
+// backups only matters for ext, FAT always keeps its backup boot sector
+// in step as fatlabel does. codePage names the code page a FAT label is
+// encoded with, cp437 when empty.
+func SetLabel(blk, label string, backups bool, codePage string) error {
+	fi, name, err := openForSet(blk)
+	if err != nil {
+		return err
+	}
+	defer fi.Close()
+
+	switch {
+	case isExt(name):
+		err = ext.SetLabel(fi, label, backups)
+	case name == fat.FatName:
+		var cp *fat.CodePage
+		if codePage != "" {
+			if cp, err = fat.CodePageByName(codePage); err != nil {
+				return err
+			}
+		}
+
+		err = fat.SetLabel(fi, label, cp)
+	default:
+		err = fmt.Errorf("cannot set the label of %s", orNone(name))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return fi.Sync()
+}
+
+// SetUUID changes the uuid of the filesystem on the passed in block, a
+// uuid of "random" generates a new one. It returns the uuid written.
+// keepSeed allows enabling metadata_csum_seed on ext, see ext.SetUUID.
+// A mounted ext filesystem is refused, the kernel would write its own
+// copy of the superblock back.
+func SetUUID(blk, uuid string, backups, keepSeed bool) (goblkid.UUID, error) {
+	var u goblkid.UUID
+
+	fi, name, err := openForSet(blk)
+	if err != nil {
+		return u, err
+	}
+	defer fi.Close()
+
+	switch {
+	case isExt(name):
+		if err := checkUnmounted(fi); err != nil {
+			return u, err
+		}
+
+		if uuid == "random" {
+			u, err = ext.RandomUUID()
+		} else {
+			u, err = goblkid.ParseUUIDFormat(uuid, goblkid.RFC4122)
+		}
+
+		if err == nil {
+			err = ext.SetUUID(fi, u, backups, keepSeed)
+		}
+	case name == fat.FatName:
+		if uuid == "random" {
+			u, err = fat.RandomSerial()
+		} else {
+			u, err = goblkid.ParseUUIDFormat(uuid, goblkid.FATSerial)
+		}
+
+		if err == nil {
+			err = fat.SetSerial(fi, u)
+		}
+	default:
+		err = fmt.Errorf("cannot set the uuid of %s", orNone(name))
+	}
+
+	if err != nil {
+		return u, err
+	}
+
+	return u, fi.Sync()
+}
+
+/* openForSet opens blk for writing and probes what is on it */
+func openForSet(blk string) (*os.File, string, error) {
+	fi, err := os.OpenFile(blk, os.O_RDWR, 0)
+	if err != nil {
+		return nil, "", err
+	}
+
+	info := &goblkid.ProbeInfo{}
+	if err := info.SetDevice(fi); err != nil {
+		fi.Close()
+		return nil, "", err
+	}
+
+	if _, err := filesystems.Probe(info); err != nil {
+		fi.Close()
+		return nil, "", err
+	}
+
+	return fi, info.ProbeName, nil
+}
+
+/* checkUnmounted refuses a block device listed in /proc/self/mountinfo */
+func checkUnmounted(fi *os.File) error {
+	info := &goblkid.ProbeInfo{}
+	if err := info.SetDevice(fi); err != nil {
+		return err
+	}
+
+	if info.Devno == 0 {
+		return nil
+	}
+
+	mounts, err := ioutil.ReadFile("/proc/self/mountinfo")
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	devno := fmt.Sprintf("%d:%d", goblkid.Major(uint64(info.Devno)), goblkid.Minor(uint64(info.Devno)))
+
+	for _, line := range strings.Split(string(mounts), "\n") {
+		/* mount id, parent id, major:minor, root, mount point, ... */
+		fields := strings.Fields(line)
+		if len(fields) > 4 && fields[2] == devno {
+			return fmt.Errorf("%s is mounted on %s", fi.Name(), fields[4])
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,296 @@
+package wipefs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/isi-lincoln/goblkid"
+	"github.com/isi-lincoln/goblkid/ext"
+	"github.com/isi-lincoln/goblkid/fat"
+	"github.com/isi-lincoln/goblkid/filesystems"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Region is a byte range a wipe overwrites with zeros
+type Region struct {
+	Offset int64
+	Data   []byte /* the bytes found there when planning */
+	What   string
+}
+
+// Plan lists everything a wipe of a device will overwrite
+type Plan struct {
+	Device     string
+	Filesystem string
+	UUID       goblkid.UUID
+	Regions    []Region
+}
+
+// WipeOptions select how much of a filesystem is wiped
+type WipeOptions struct {
+	// Deep also wipes backup superblocks and group descriptor copies, and
+	// the FAT32 backup boot sector
+	Deep bool
+	// NoAct only plans the wipe
+	NoAct bool
+	// BackupDir saves every region to a file before wiping it
+	BackupDir string
+	// Force wipes even when multi-mount protection shows the filesystem in
+	// use, or when filesystems still list the journal device as theirs
+	Force bool
+}
+
+// PlanWipe probes dev and works out which bytes a wipe would overwrite,
+// reading them so they can be saved and restored
+func PlanWipe(dev string, opts WipeOptions) (*Plan, error) {
+	fi, err := os.Open(dev)
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close()
+
+	info := &goblkid.ProbeInfo{}
+	if err := info.SetDevice(fi); err != nil {
+		return nil, err
+	}
+
+	ok, err := filesystems.Probe(info)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("no filesystem signature found on %s", dev)
+	}
+
+	p := &Plan{Device: dev, Filesystem: info.ProbeName, UUID: info.UUID}
+
+	switch {
+	case isExt(info.ProbeName):
+		err = p.planExt(info, opts)
+	case info.ProbeName == ext.JbdName:
+		err = p.planJournal(info, opts)
+	case info.ProbeName == fat.FatName || info.ProbeName == fat.ExFATName:
+		err = p.planFAT(info, opts)
+	default:
+		err = fmt.Errorf("unknown case: %s", info.ProbeName)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range p.Regions {
+		r := &p.Regions[i]
+		if _, err := fi.ReadAt(r.Data, r.Offset); err != nil {
+			return nil, fmt.Errorf("%s: %v", r.What, err)
+		}
+	}
+
+	return p, nil
+}
+
+func (p *Plan) planExt(info *goblkid.ProbeInfo, opts WipeOptions) error {
+	mmp, err := ext.ReadMMP(info)
+	if err != nil && !opts.Force {
+		return fmt.Errorf("cannot read mmp block: %v", err)
+	}
+
+	if mmp != nil && mmp.Active() && !opts.Force {
+		return fmt.Errorf("%s is %s on %s (%s), mmp sequence %08x at %s",
+			p.Device, mmp.State, mmp.NodeName, mmp.DeviceName, mmp.Sequence, mmp.Updated.Format(time.RFC3339))
+	}
+
+	magic := ext.ExtMagic[0]
+	p.add(int64(magic.SuperblockKbOffset<<10+magic.MagicByteOffset), int64(len(magic.Magic)), "superblock magic") // nolint:gomnd
+
+	if !opts.Deep {
+		return nil
+	}
+
+	copies, err := ext.MetadataCopies(info)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range copies {
+		p.add(c.Offset, c.Length, c.What)
+	}
+
+	return nil
+}
+
+/* planJournal refuses to wipe a journal device filesystems still list as theirs */
+func (p *Plan) planJournal(info *goblkid.ProbeInfo, opts WipeOptions) error {
+	j, err := ext.ReadJournal(info)
+	if err != nil && !opts.Force {
+		return fmt.Errorf("cannot read journal superblock: %v", err)
+	}
+
+	if j != nil && len(j.Users) > 0 && !opts.Force {
+		users := []string{}
+		for _, u := range j.Users {
+			users = append(users, u.String())
+		}
+
+		return fmt.Errorf("%s is the journal of %s", p.Device, strings.Join(users, ", "))
+	}
+
+	magic := ext.ExtMagic[0]
+	p.add(int64(magic.SuperblockKbOffset<<10+magic.MagicByteOffset), int64(len(magic.Magic)), "superblock magic") // nolint:gomnd
+
+	return nil
+}
+
+/* planFAT wipes the boot sector signatures, with Deep those of the FAT32 or exFAT backup too */
+func (p *Plan) planFAT(info *goblkid.ProbeInfo, opts WipeOptions) error {
+	extents, err := fat.SignatureExtents(info, opts.Deep)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range extents {
+		p.add(e.Offset, e.Length, e.What)
+	}
+
+	return nil
+}
+
+func (p *Plan) add(offset, length int64, what string) {
+	p.Regions = append(p.Regions, Region{Offset: offset, Data: make([]byte, length), What: what})
+}
+
+// Size is the number of bytes the plan overwrites
+func (p *Plan) Size() int64 {
+	var n int64
+	for _, r := range p.Regions {
+		n += int64(len(r.Data))
+	}
+
+	return n
+}
+
+// Backup saves every region as wipefs does, to
+// <dir>/wipefs-<device>-0x<offset>.bak, and returns the file names
+func (p *Plan) Backup(dir string) ([]string, error) {
+	files := []string{}
+
+	for _, r := range p.Regions {
+		name := filepath.Join(dir, fmt.Sprintf("wipefs-%s-0x%08x.bak", filepath.Base(p.Device), r.Offset))
+
+		if err := ioutil.WriteFile(name, r.Data, 0600); err != nil { // nolint:gomnd
+			return files, err
+		}
+
+		files = append(files, name)
+	}
+
+	return files, nil
+}
+
+// Execute zeroes every region of the plan
+func (p *Plan) Execute() error {
+	fi, err := os.OpenFile(p.Device, os.O_RDWR, 0777)
+	if err != nil {
+		return err
+	}
+	defer fi.Close()
+
+	for _, r := range p.Regions {
+		if err := writeAt(fi, make([]byte, len(r.Data)), r.Offset); err != nil {
+			return fmt.Errorf("%s: %v", r.What, err)
+		}
+	}
+
+	return fi.Sync()
+}
+
+// Wipe plans and, unless opts.NoAct is set, carries out a wipe of dev
+func Wipe(dev string, opts WipeOptions) (*Plan, error) {
+	p, err := PlanWipe(dev, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.BackupDir != "" {
+		files, err := p.Backup(opts.BackupDir)
+		if err != nil {
+			return p, err
+		}
+
+		log.Debugf("saved %d regions: %s", len(files), strings.Join(files, " "))
+	}
+
+	if opts.NoAct {
+		return p, nil
+	}
+
+	return p, p.Execute()
+}
+
+// PrintWipePlan prints every region the wipe overwrites
+func PrintWipePlan(p *Plan) {
+	log.Infof("%s: %s %s, %d bytes in %d regions", p.Device, p.Filesystem, p.UUID, p.Size(), len(p.Regions))
+
+	for _, r := range p.Regions {
+		log.Infof("0x%08x %8d %s", r.Offset, len(r.Data), r.What)
+	}
+}
+
+// RestoreWipe writes backup files made by Plan.Backup back to dev, taking
+// the offset from each file name
+func RestoreWipe(dev string, files []string) error {
+	fi, err := os.OpenFile(dev, os.O_RDWR, 0777)
+	if err != nil {
+		return err
+	}
+	defer fi.Close()
+
+	for _, name := range files {
+		offset, err := backupOffset(name)
+		if err != nil {
+			return err
+		}
+
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return err
+		}
+
+		if err := writeAt(fi, data, offset); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+
+	return fi.Sync()
+}
+
+func backupOffset(name string) (int64, error) {
+	base := strings.TrimSuffix(filepath.Base(name), ".bak")
+
+	i := strings.LastIndex(base, "-0x")
+	if !strings.HasPrefix(base, "wipefs-") || i < 0 {
+		return 0, fmt.Errorf("not a wipefs backup file: %s", name)
+	}
+
+	return strconv.ParseInt(base[i+3:], 16, 64)
+}
+
+func writeAt(fi *os.File, data []byte, offset int64) error {
+	n, err := fi.WriteAt(data, offset)
+	if err != nil {
+		return err
+	}
+
+	if n != len(data) {
+		return fmt.Errorf("write != length: %d != %d", n, len(data))
+	}
+
+	return nil
+}
@@ -0,0 +1,187 @@
+package wipefs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/isi-lincoln/goblkid"
+	"github.com/isi-lincoln/goblkid/ext"
+	"github.com/isi-lincoln/goblkid/fat"
+	"github.com/isi-lincoln/goblkid/filesystems"
+	"github.com/stretchr/testify/assert"
+)
+
+/* mkfs formats a fresh 16M image file, skipping without e2fsprogs */
+func mkfs(t *testing.T, tool string, args ...string) string {
+	t.Helper()
+
+	if _, err := exec.LookPath(tool); err != nil {
+		t.Skipf("%s not installed", tool)
+	}
+
+	img := filepath.Join(t.TempDir(), "disk.img")
+	assert.Nil(t, ioutil.WriteFile(img, make([]byte, 16<<20), 0600))
+
+	args = append([]string{"-q", "-F", "-b", "1024"}, append(args, img)...)
+	if out, err := exec.Command(tool, args...).CombinedOutput(); err != nil {
+		t.Skipf("%s %v: %v: %s", tool, args, err, out)
+	}
+
+	return img
+}
+
+func writeImage(t *testing.T, data []byte) string {
+	t.Helper()
+
+	img := filepath.Join(t.TempDir(), "disk.img")
+	assert.Nil(t, ioutil.WriteFile(img, data, 0600))
+
+	return img
+}
+
+/* probe runs every chain wipefs knows over the image */
+func probe(t *testing.T, img string) *goblkid.ProbeInfo {
+	t.Helper()
+
+	fi, err := os.Open(img)
+	assert.Nil(t, err)
+
+	defer fi.Close()
+
+	info := &goblkid.ProbeInfo{}
+	assert.Nil(t, info.SetDevice(fi))
+
+	ok, err := filesystems.Probe(info)
+	assert.Nil(t, err)
+
+	if !ok {
+		return nil
+	}
+
+	return info
+}
+
+/* backupProbe identifies the image from its backup metadata, as get ext-backups and fat-backup -i do */
+func backupProbe(t *testing.T, img string) bool {
+	t.Helper()
+
+	fi, err := os.Open(img)
+	assert.Nil(t, err)
+
+	defer fi.Close()
+
+	info := &goblkid.ProbeInfo{}
+	assert.Nil(t, info.SetDevice(fi))
+
+	eb, err := ext.ProbeBackup(info)
+	assert.Nil(t, err)
+
+	fb, err := fat.ProbeBackup(info)
+	assert.Nil(t, err)
+
+	return eb != nil || fb != nil
+}
+
+/*
+ * roundTrip wipes img after saving the regions, checks nothing probes any
+ * more, restores the saved regions and checks the image is back byte for
+ * byte. It returns the plan.
+ */
+func roundTrip(t *testing.T, img string, opts WipeOptions) *Plan {
+	t.Helper()
+
+	orig, err := ioutil.ReadFile(img)
+	assert.Nil(t, err)
+
+	p, err := PlanWipe(img, opts)
+	assert.Nil(t, err)
+
+	for _, r := range p.Regions {
+		assert.Equal(t, orig[r.Offset:r.Offset+int64(len(r.Data))], r.Data, r.What)
+	}
+
+	files, err := p.Backup(t.TempDir())
+	assert.Nil(t, err)
+	assert.Len(t, files, len(p.Regions))
+
+	assert.Nil(t, p.Execute())
+	assert.Nil(t, probe(t, img), "%s still probes after the wipe", p.Filesystem)
+
+	if opts.Deep {
+		assert.False(t, backupProbe(t, img), "%s backup still probes after a deep wipe", p.Filesystem)
+	}
+
+	assert.Nil(t, RestoreWipe(img, files))
+
+	restored, err := ioutil.ReadFile(img)
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(orig, restored), "%s not restored byte for byte", p.Filesystem)
+	assert.NotNil(t, probe(t, img))
+
+	return p
+}
+
+func TestWipeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		img     func(t *testing.T) string
+		fs      string
+		regions int /* without Deep */
+	}{
+		{"ext4", func(t *testing.T) string { return mkfs(t, "mkfs.ext4") }, "ext4", 1},
+		{"ext2", func(t *testing.T) string { return mkfs(t, "mkfs.ext2") }, "ext2", 1},
+		{"fat32", func(t *testing.T) string { return writeImage(t, fat32Image()) }, fat.FatName, 3},
+		{"exfat", func(t *testing.T) string { return writeImage(t, exfatImage()) }, fat.ExFATName, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := tt.img(t)
+
+			p := roundTrip(t, img, WipeOptions{})
+			assert.Equal(t, tt.fs, p.Filesystem)
+			assert.Len(t, p.Regions, tt.regions)
+
+			/* a shallow wipe leaves the backups to recover from */
+			_, err := Wipe(img, WipeOptions{})
+			assert.Nil(t, err)
+			assert.Nil(t, probe(t, img))
+
+			/* nothing probes from the exFAT backup region, TestWipeExFATBackup reads it */
+			if tt.fs != fat.ExFATName {
+				assert.True(t, backupProbe(t, img), "%s backup gone after a shallow wipe", tt.name)
+			}
+		})
+
+		t.Run(tt.name+"-deep", func(t *testing.T) {
+			img := tt.img(t)
+
+			p := roundTrip(t, img, WipeOptions{Deep: true})
+			assert.Greater(t, len(p.Regions), tt.regions)
+		})
+	}
+}
+
+func TestWipeNoAct(t *testing.T) {
+	img := mkfs(t, "mkfs.ext4")
+
+	orig, err := ioutil.ReadFile(img)
+	assert.Nil(t, err)
+
+	dir := t.TempDir()
+
+	p, err := Wipe(img, WipeOptions{NoAct: true, Deep: true, BackupDir: dir})
+	assert.Nil(t, err)
+
+	after, err := ioutil.ReadFile(img)
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(orig, after))
+
+	files, err := filepath.Glob(filepath.Join(dir, "wipefs-disk.img-0x*.bak"))
+	assert.Nil(t, err)
+	assert.Len(t, files, len(p.Regions))
+}